@@ -1,10 +1,278 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
 	"github.com/vincentchyu/vincentchyu.github.io/internal/photo"
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+	"github.com/vincentchyu/vincentchyu.github.io/internal/watch"
 	_ "github.com/vincentchyu/vincentchyu.github.io/pkg/config"
 )
 
 func main() {
-	photo.UpdatePhotosHandler(nil)
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		restore(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diff(os.Args[2:])
+		return
+	}
+
+	full := flag.Bool("full", false, "rehash every file instead of trusting the index cache")
+	watchMode := flag.Bool("watch", false, "keep running and process photos as they are added, changed or removed")
+	poll := flag.Bool("poll", false, "with --watch, scan for changes periodically instead of using filesystem notifications")
+	dryRun := flag.Bool("dry-run", false, "print what an update would do without uploading, deleting or writing photos.json")
+	format := flag.String("format", "text", "with --dry-run, print the plan as text or json")
+	planFile := flag.String("plan", "", "with --dry-run, also save the plan to this file")
+	applyFile := flag.String("apply", "", "apply a plan saved with --dry-run --plan, if nothing changed since")
+	allowDeletions := flag.Bool("allow-deletions", false, "delete photos even when more than PHOTO_DELETE_THRESHOLD percent are gone")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("❌ Unknown format %q, expected text or json", *format)
+	}
+	if (*dryRun || *applyFile != "") && *watchMode {
+		log.Fatalln("❌ --dry-run and --apply cannot be combined with --watch")
+	}
+	if *dryRun && *applyFile != "" {
+		log.Fatalln("❌ --dry-run and --apply cannot be combined")
+	}
+
+	// Ctrl-C stops the rebuild without writing a partial photos.json
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *dryRun {
+		if err := printPlan(ctx, *full, *format, *planFile); err != nil {
+			log.Fatalf("❌ Dry run failed: %v", err)
+		}
+		return
+	}
+
+	var result *photo.RebuildResult
+	var err error
+	if *applyFile != "" {
+		plan, loadErr := photo.LoadPlan(*applyFile)
+		if loadErr != nil {
+			log.Fatalf("❌ %v", loadErr)
+		}
+		opts := photo.UpdateOptions{Trigger: photo.TriggerCLI, AllowDeletions: *allowDeletions}
+		result, err = photo.ApplyPlan(ctx, nil, plan, opts)
+	} else {
+		opts := photo.UpdateOptions{Full: *full, Trigger: photo.TriggerCLI, AllowDeletions: *allowDeletions}
+		result, err = photo.Rebuild(ctx, nil, opts)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("⏹ Rebuild cancelled: %s\n", result.Summary())
+		os.Exit(130)
+	}
+
+	if *watchMode {
+		// A failed initial run is retried with the next change instead of ending the watch
+		if err != nil {
+			log.Printf("❌ Rebuild failed: %v", err)
+		}
+		if err := watchPhotos(ctx, *poll); err != nil {
+			log.Fatalf("❌ Watch failed: %v", err)
+		}
+		return
+	}
+
+	if err != nil {
+		log.Fatalf("❌ Rebuild failed: %v", err)
+	}
+	if result.HasFailures() {
+		for _, failure := range result.Failed {
+			log.Printf("❌ %s: %s\n", failure.File, failure.Error)
+		}
+		os.Exit(1)
+	}
+}
+
+// restore handles the restore subcommand, which brings deleted photos back from the trash
+func restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	job := fs.String("job", "", "restore from the trash batch of this job, all of its photos unless files are given")
+	list := fs.Bool("list", false, "list the trash batches and their photos")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: update-photos restore [--list] [--job ID] [filename...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *list {
+		rootDir, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		batches, err := photo.NewTrashStore(rootDir).List()
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		for _, batch := range batches {
+			fmt.Printf("%s  %d photos, %d objects\n", batch.ID, len(batch.Photos), len(batch.Objects))
+			for _, trashed := range batch.Photos {
+				fmt.Printf("  %s\n", trashed.Photo.Source)
+			}
+		}
+		return
+	}
+	if *job == "" && fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	result, err := photo.Restore(context.Background(), *job, fs.Args())
+	if result != nil {
+		for _, failure := range result.Failed {
+			log.Printf("❌ %s: %s\n", failure.File, failure.Error)
+		}
+		log.Printf("✓ Restored %d photos\n", len(result.Restored))
+	}
+	if err != nil {
+		log.Fatalf("❌ Restore failed: %v", err)
+	}
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// diff handles the diff subcommand, which compares two versions of photos.json photo by photo
+func diff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "text", "print the diff as text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: update-photos diff [--format text|json] [OLD [NEW]]")
+		fmt.Fprintln(fs.Output(), "OLD and NEW are files or versions: current, local:<backup>, r2:<snapshot>.")
+		fmt.Fprintln(fs.Output(), "OLD defaults to the newest local backup and NEW to current.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 2 || (*format != "text" && *format != "json") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	rootDir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	refs := []string{"", photo.CurrentVersion}
+	copy(refs, fs.Args())
+	if fs.NArg() == 0 {
+		backups, err := photo.ListBackups(rootDir)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if len(backups) == 0 {
+			log.Fatalln("❌ No backups of photos.json to compare with")
+		}
+		refs[0] = photo.VersionLocal + ":" + backups[0].Name
+	}
+
+	ctx := context.Background()
+	var contents [2][]byte
+	for i, ref := range refs {
+		if contents[i], err = loadManifestRef(ctx, rootDir, ref); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+	result, err := photo.DiffManifests(contents[0], contents[1])
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if *format == "json" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("%s -> %s\n", refs[0], refs[1])
+	result.WriteText(os.Stdout)
+}
+
+// loadManifestRef reads a manifest from a file, or a version when no such file exists
+func loadManifestRef(ctx context.Context, rootDir, ref string) ([]byte, error) {
+	if info, err := os.Stat(ref); err == nil && !info.IsDir() {
+		return os.ReadFile(ref)
+	}
+	var r2Client *storage.R2Client
+	if strings.HasPrefix(ref, photo.VersionRemote+":") {
+		config, err := storage.LoadR2Config()
+		if err != nil {
+			return nil, err
+		}
+		if r2Client, err = storage.NewR2Client(config); err != nil {
+			return nil, err
+		}
+	}
+	return photo.LoadManifestVersion(ctx, rootDir, r2Client, ref)
+}
+
+// printPlan computes the plan of an update and prints it to stdout, logs go to stderr
+func printPlan(ctx context.Context, full bool, format, planFile string) error {
+	result, err := photo.Rebuild(ctx, nil, photo.UpdateOptions{Full: full, Trigger: photo.TriggerCLI, DryRun: true})
+	if err != nil {
+		return err
+	}
+	if planFile != "" {
+		if err := photo.SavePlan(result.Plan, planFile); err != nil {
+			return err
+		}
+		log.Printf("✓ Plan saved to %s, apply it with --apply %s\n", planFile, planFile)
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result.Plan)
+	}
+	result.Plan.WriteText(os.Stdout)
+	return nil
+}
+
+// watchPhotos runs an incremental rebuild for every batch of changed files until ctx is done
+func watchPhotos(ctx context.Context, poll bool) error {
+	rootDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	watcher := &watch.Watcher{
+		Root:   filepath.Join(rootDir, photo.ImgDir),
+		Poll:   poll,
+		Filter: photo.IsSupportedImage,
+	}
+	log.Println("👀 Watching for photo changes, press Ctrl-C to stop")
+	return watcher.Run(
+		ctx, func(paths []string) {
+			opts := photo.UpdateOptions{Paths: paths, Trigger: photo.TriggerWatch}
+			if paths == nil {
+				log.Println("🟢 Rescanning all photos...")
+			} else {
+				log.Printf("🟢 %d changed files, updating...\n", len(paths))
+			}
+			result, err := photo.Rebuild(ctx, nil, opts)
+			if err != nil {
+				log.Printf("❌ Rebuild failed: %v", err)
+				return
+			}
+			for _, failure := range result.Failed {
+				log.Printf("❌ %s: %s\n", failure.File, failure.Error)
+			}
+		},
+	)
 }
@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/photo"
+)
+
+const (
+	// maxBufferedLogs caps the rebuild log lines kept in memory
+	maxBufferedLogs = 1000
+	// heartbeatInterval is how often an idle event stream sends a comment to keep proxies from closing it
+	heartbeatInterval = 15 * time.Second
+	// minFlushInterval batches bursts of events, progress is reported for every processed photo
+	minFlushInterval = 250 * time.Millisecond
+)
+
+// Event types of the rebuild event stream
+const (
+	eventLog      = "log"
+	eventProgress = "progress"
+	eventStatus   = "status"
+)
+
+// streamEvent is a single Server-Sent Event
+type streamEvent struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+// progressPayload is the data of a progress event
+type progressPayload struct {
+	Progress int                 `json:"progress"`
+	Message  string              `json:"message"`
+	Detail   photo.ProgressEvent `json:"detail"`
+}
+
+// eventStream keeps the recent rebuild events for streaming and replay.
+// Log lines are kept in a ring buffer; progress and status only matter in their latest state,
+// so just the last event of each is kept and a slow client skips intermediate ones.
+type eventStream struct {
+	mu       sync.Mutex
+	nextID   uint64
+	logs     []streamEvent // Ring buffer, oldest entry at head once full
+	head     int
+	progress *streamEvent
+	status   *streamEvent
+	changed  chan struct{} // Closed and replaced on every publish
+}
+
+// newEventStream creates an empty event stream
+func newEventStream() *eventStream {
+	return &eventStream{changed: make(chan struct{})}
+}
+
+// reset drops the events of the previous rebuild. IDs keep increasing so clients
+// resuming with an older ID receive everything of the new rebuild.
+func (es *eventStream) reset() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.logs, es.head = nil, 0
+	es.progress, es.status = nil, nil
+}
+
+// publish appends an event with a JSON payload and wakes all subscribers
+func (es *eventStream) publish(eventType string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("⚠ Warning: failed to marshal %s event: %v", eventType, err)
+		return
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.nextID++
+	event := streamEvent{ID: es.nextID, Type: eventType, Data: data}
+	switch eventType {
+	case eventLog:
+		if len(es.logs) < maxBufferedLogs {
+			es.logs = append(es.logs, event)
+		} else {
+			es.logs[es.head] = event
+			es.head = (es.head + 1) % maxBufferedLogs
+		}
+	case eventProgress:
+		es.progress = &event
+	case eventStatus:
+		es.status = &event
+	}
+	close(es.changed)
+	es.changed = make(chan struct{})
+}
+
+// since returns the buffered events after id in order, and a channel that is closed
+// on the next publish
+func (es *eventStream) since(id uint64) ([]streamEvent, <-chan struct{}) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	// An ID from before a server restart would hide every event
+	if id > es.nextID {
+		id = 0
+	}
+
+	var events []streamEvent
+	for i := range es.logs {
+		if event := es.logs[(es.head+i)%len(es.logs)]; event.ID > id {
+			events = append(events, event)
+		}
+	}
+	for _, event := range []*streamEvent{es.progress, es.status} {
+		if event != nil && event.ID > id {
+			events = append(events, *event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, es.changed
+}
+
+// handleRebuildEvents handles GET /api/rebuild/events, a Server-Sent Events stream of the
+// rebuild's log lines, progress and status. Clients resume after the Last-Event-ID header,
+// or the last_event_id query parameter for a fresh EventSource.
+func (s *AdminServer) handleRebuildEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var sent uint64
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid event ID: %s", lastID), http.StatusBadRequest)
+			return
+		}
+		sent = id
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("⚠ Warning: event stream not supported: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		events, changed := s.events.since(sent)
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+			sent = event.ID
+		}
+		if len(events) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			heartbeat.Reset(heartbeatInterval)
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+			// Let a burst of events accumulate before the next write
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(minFlushInterval):
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
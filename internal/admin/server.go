@@ -1,13 +1,17 @@
 package admin
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,30 +23,43 @@ import (
 
 // AdminServer manages the photo admin HTTP server
 type AdminServer struct {
-	rootDir      string
-	photosPath   string
-	imagesDir    string
-	mu           sync.RWMutex
-	rebuildTask  *RebuildTask
-	rebuildMutex sync.Mutex
-	R2Client     *storage.R2Client
+	rootDir       string
+	photosPath    string
+	imagesDir     string
+	mu            sync.RWMutex
+	rebuildTask   *RebuildTask
+	rebuildMutex  sync.Mutex
+	rebuildCancel context.CancelFunc // Cancels the running rebuild, nil when idle
+	processing    int                // Running processPaths calls and rollbacks, guarded by rebuildMutex
+	events        *eventStream       // Rebuild logs, progress and status for /api/rebuild/events
+	R2Client      *storage.R2Client
 }
 
 // RebuildTask tracks the status of a rebuild operation
 type RebuildTask struct {
-	Status    string    `json:"status"` // "idle", "running", "completed", "failed"
+	Status    string    `json:"status"` // "idle", "running", "completed", "failed", "cancelled"
 	Progress  int       `json:"progress"`
 	Message   string    `json:"message"`
 	StartTime time.Time `json:"start_time,omitempty"`
 	EndTime   time.Time `json:"end_time,omitempty"`
-	Logs      []string  `json:"logs"`
+	Logs      []string  `json:"logs,omitempty"` // The last maxBufferedLogs lines
+
+	// DeletionBlocked is set when the rebuild stopped because too many photos would be deleted,
+	// it can be repeated with allow_deletions=true
+	DeletionBlocked bool `json:"deletion_blocked,omitempty"`
+
+	Detail *photo.ProgressEvent `json:"detail,omitempty"` // Latest progress event of the processor
+	Result *photo.RebuildResult `json:"result,omitempty"` // Set once the rebuild finished
 }
 
 // PhotoUpdateRequest represents a photo metadata update request
 type PhotoUpdateRequest struct {
 	Alt      *string  `json:"alt,omitempty"`
+	Title    *string  `json:"title,omitempty"`
+	Rating   *int     `json:"rating,omitempty"`
 	IsHidden *bool    `json:"is_hidden,omitempty"`
-	Subject  []string `json:"Subject,omitempty"`
+	Subject  []string `json:"subject,omitempty"`
+	Lens     *string  `json:"lens,omitempty"` // Manual lens assignment, "" removes it
 }
 
 // BatchUpdateRequest represents a batch update request
@@ -80,6 +97,7 @@ func NewAdminServer() (*AdminServer, error) {
 			Status: "idle",
 			Logs:   []string{},
 		},
+		events:   newEventStream(),
 		R2Client: r2Client,
 	}, nil
 }
@@ -98,10 +116,21 @@ func StartAdminServer(addr string) error {
 	mux.HandleFunc("/api/photos/", loggingMiddleware(server.handlePhotoResource)) // Renamed from handlePhotoUpdate
 	mux.HandleFunc("/api/photos/batch", loggingMiddleware(server.handleBatchUpdate))
 	mux.HandleFunc("/api/photos/upload", loggingMiddleware(server.handlePhotoUpload))
+	mux.HandleFunc("/api/photos/process", loggingMiddleware(server.handleProcessPhotos))
+	mux.HandleFunc("/api/places", loggingMiddleware(server.handlePlaces))
+	mux.HandleFunc("/api/gear", loggingMiddleware(server.handleGear))
 	mux.HandleFunc("/api/rebuild", loggingMiddleware(server.handleRebuild))
 	mux.HandleFunc("/api/rebuild/status", loggingMiddleware(server.handleRebuildStatus))
+	mux.HandleFunc("/api/rebuild/cancel", loggingMiddleware(server.handleRebuildCancel))
+	mux.HandleFunc("/api/rebuild/events", loggingMiddleware(server.handleRebuildEvents))
+	mux.HandleFunc("/api/rebuild/jobs", loggingMiddleware(server.handleRebuildJobs))
+	mux.HandleFunc("/api/rebuild/jobs/", loggingMiddleware(server.handleRebuildJobs))
+	mux.HandleFunc("/api/manifest/versions", loggingMiddleware(server.handleManifestVersions))
+	mux.HandleFunc("/api/manifest/diff", loggingMiddleware(server.handleManifestDiff))
+	mux.HandleFunc("/api/manifest/rollback", loggingMiddleware(server.handleManifestRollback))
 	mux.HandleFunc("/api/images/", loggingMiddleware(server.handleImageServe))
 	mux.HandleFunc("/api/proxy", loggingMiddleware(server.handleProxy))
+	mux.HandleFunc("/api/private", loggingMiddleware(server.handlePrivateObject))
 
 	// Static files
 	webAdminDir := filepath.Join(server.rootDir, "web", "admin")
@@ -131,8 +160,177 @@ func (s *AdminServer) handlePhotos(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse photos.json: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Optional place filters: ?country=China&region=Zhejiang&city=Hangzhou
+	query := r.URL.Query()
+	country, region, city := query.Get("country"), query.Get("region"), query.Get("city")
+	if country != "" || region != "" || city != "" {
+		filtered := make([]photo.YearAlbum, 0, len(manifest.Albums))
+		for _, album := range manifest.Albums {
+			var photos []photo.Photo
+			for _, p := range album.Photos {
+				if matchesPlace(p, country, region, city) {
+					photos = append(photos, p)
+				}
+			}
+			if len(photos) > 0 {
+				filtered = append(filtered, photo.YearAlbum{Year: album.Year, Photos: photos})
+			}
+		}
+		manifest.Albums, manifest.Counts = filtered, photo.NewManifest(filtered).Counts
+	}
+
+	// Older manifests are returned migrated, so the panel only knows the current schema
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// handlePlaces handles GET /api/places, listing the places photos were taken at with counts
+func (s *AdminServer) handlePlaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	data, err := os.ReadFile(s.photosPath)
+	s.mu.RUnlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read photos.json: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse photos.json: %v", err), http.StatusInternalServerError)
+		return
+	}
+	albums := manifest.Albums
+
+	type placeCount struct {
+		Country string `json:"country"`
+		Region  string `json:"region,omitempty"`
+		City    string `json:"city,omitempty"`
+		Count   int    `json:"count"`
+	}
+	counts := make(map[placeCount]int)
+	for _, album := range albums {
+		for _, p := range album.Photos {
+			if p.Location == nil {
+				continue
+			}
+			counts[placeCount{Country: p.Location.Country, Region: p.Location.Region, City: p.Location.City}]++
+		}
+	}
+
+	places := make([]placeCount, 0, len(counts))
+	for place, count := range counts {
+		place.Count = count
+		places = append(places, place)
+	}
+	sort.Slice(
+		places, func(i, j int) bool {
+			if places[i].Count != places[j].Count {
+				return places[i].Count > places[j].Count
+			}
+			return places[i].City < places[j].City
+		},
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(places)
+}
+
+// matchesPlace reports whether a photo was taken at the given place, empty filters match anything
+func matchesPlace(p photo.Photo, country, region, city string) bool {
+	if p.Location == nil {
+		return false
+	}
+	return (country == "" || strings.EqualFold(p.Location.Country, country) ||
+		strings.EqualFold(p.Location.CountryCode, country)) &&
+		(region == "" || strings.EqualFold(p.Location.Region, region)) &&
+		(city == "" || strings.EqualFold(p.Location.City, city))
+}
+
+// handleGear handles GET/PUT /api/gear, the camera and lens name registry.
+// Saving the registry re-applies the canonical names to every photo in photos.json.
+func (s *AdminServer) handleGear(w http.ResponseWriter, r *http.Request) {
+	gearPath := photo.GearRegistryPath(s.rootDir)
+
+	switch r.Method {
+	case http.MethodGet:
+		gear, err := photo.LoadGearRegistry(gearPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load gear registry: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gear)
+	case http.MethodPut:
+		var gear photo.GearRegistry
+		if err := json.NewDecoder(r.Body).Decode(&gear); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := gear.Save(gearPath); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save gear registry: %v", err), http.StatusInternalServerError)
+			return
+		}
+		updated, err := s.applyGear(r.Context(), &gear)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to apply gear registry: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "updated": updated})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// applyGear re-applies the gear registry to all photos and returns how many changed
+func (s *AdminServer) applyGear(ctx context.Context, gear *photo.GearRegistry) (int, error) {
+	unlock, err := s.lockPhotos()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(s.photosPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read photos.json: %w", err)
+	}
+
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse photos.json: %w", err)
+	}
+	albums := manifest.Albums
+
+	updated := 0
+	for i := range albums {
+		for j := range albums[i].Photos {
+			p := &albums[i].Photos[j]
+			camera, lens := p.Camera, p.Lens
+			gear.Apply(p)
+			if p.Camera != camera || p.Lens != lens {
+				updated++
+			}
+		}
+	}
+
+	if updated == 0 {
+		return 0, nil
+	}
+	if err := s.updatePhotoJson(ctx, albums); err != nil {
+		return 0, fmt.Errorf("failed to update photos.json: %w", err)
+	}
+	return updated, nil
 }
 
 // handlePhotoResource handles operations on specific photos (PUT, DELETE)
@@ -144,6 +342,19 @@ func (s *AdminServer) handlePhotoResource(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if name, ok := strings.CutSuffix(filename, "/metadata"); ok {
+		s.handlePhotoMetadata(w, r, name)
+		return
+	}
+	if name, ok := strings.CutSuffix(filename, "/process"); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeProcessResponse(w, r, []string{name})
+		return
+	}
+
 	switch r.Method {
 	case http.MethodPut:
 		s.handlePhotoUpdate(w, r, filename)
@@ -162,7 +373,7 @@ func (s *AdminServer) handlePhotoUpdate(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	if err := s.updatePhoto(filename, req); err != nil {
+	if err := s.updatePhoto(r.Context(), filename, req); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update photo: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -171,9 +382,63 @@ func (s *AdminServer) handlePhotoUpdate(w http.ResponseWriter, r *http.Request,
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
+// handlePhotoMetadata handles GET /api/photos/:filename/metadata, the published EXIF
+// together with the private fields from the metadata store
+func (s *AdminServer) handlePhotoMetadata(w http.ResponseWriter, r *http.Request, filename string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	data, err := os.ReadFile(s.photosPath)
+	s.mu.RUnlock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read photos.json: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse photos.json: %v", err), http.StatusInternalServerError)
+		return
+	}
+	albums := manifest.Albums
+
+	var public map[string]interface{}
+	found := false
+	for _, album := range albums {
+		for _, p := range album.Photos {
+			if p.Filename == filename {
+				public, found = p.Exif, true
+				break
+			}
+		}
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("Photo not found: %s", filename), http.StatusNotFound)
+		return
+	}
+
+	private, err := photo.NewMetadataStore(s.rootDir).Load(filename)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load metadata: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(
+		map[string]interface{}{
+			"filename": filename,
+			"public":   public,
+			"private":  private,
+		},
+	)
+}
+
 // handlePhotoDelete handles DELETE /api/photos/:filename
 func (s *AdminServer) handlePhotoDelete(w http.ResponseWriter, r *http.Request, filename string) {
-	if err := s.deletePhoto(filename); err != nil {
+	if err := s.deletePhoto(r.Context(), filename); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to delete photo: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -196,7 +461,7 @@ func (s *AdminServer) handleBatchUpdate(w http.ResponseWriter, r *http.Request)
 	}
 
 	for _, filename := range req.Filenames {
-		if err := s.updatePhoto(filename, req.Updates); err != nil {
+		if err := s.updatePhoto(r.Context(), filename, req.Updates); err != nil {
 			log.Printf("Failed to update %s: %v", filename, err)
 		}
 	}
@@ -205,6 +470,106 @@ func (s *AdminServer) handleBatchUpdate(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
+// ProcessRequest represents a request to process photos
+type ProcessRequest struct {
+	Filenames []string `json:"filenames"`
+}
+
+// errRebuildRunning is returned when photos are processed while a rebuild is running
+var errRebuildRunning = errors.New("a rebuild is running")
+
+// handleProcessPhotos handles POST /api/photos/process
+func (s *AdminServer) handleProcessPhotos(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ProcessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Filenames) == 0 {
+		http.Error(w, "Filenames are required", http.StatusBadRequest)
+		return
+	}
+	s.writeProcessResponse(w, r, req.Filenames)
+}
+
+// writeProcessResponse processes photos and writes their entries and the result
+func (s *AdminServer) writeProcessResponse(w http.ResponseWriter, r *http.Request, filenames []string) {
+	photos, result, err := s.processPhotos(r.Context(), filenames)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errRebuildRunning):
+			status = http.StatusConflict
+		case errors.Is(err, os.ErrNotExist):
+			status = http.StatusNotFound
+		case result != nil && result.ManifestChanged:
+			// photos.json was written, only publishing to R2 or KV failed
+			status = http.StatusOK
+		}
+		if status != http.StatusOK {
+			http.Error(w, fmt.Sprintf("Failed to process photos: %v", err), status)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"status": "success",
+		"photos": photos,
+		"result": result,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// processPhotos processes photos by filename, looking them up in the images directory
+func (s *AdminServer) processPhotos(ctx context.Context, filenames []string) ([]photo.Photo, *photo.RebuildResult, error) {
+	paths := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		path, err := photo.LocateSource(s.imagesDir, photo.Photo{Filename: filepath.Base(filename)})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", os.ErrNotExist, err)
+		}
+		paths = append(paths, path)
+	}
+	return s.processPaths(ctx, paths)
+}
+
+// processPaths processes, uploads and merges image files into photos.json without a full rebuild
+func (s *AdminServer) processPaths(ctx context.Context, paths []string) ([]photo.Photo, *photo.RebuildResult, error) {
+	// Checked and claimed under one lock, so a rebuild cannot start in between
+	s.rebuildMutex.Lock()
+	if s.rebuildTask.Status == "running" {
+		s.rebuildMutex.Unlock()
+		return nil, nil, errRebuildRunning
+	}
+	s.processing++
+	s.rebuildMutex.Unlock()
+	defer func() {
+		s.rebuildMutex.Lock()
+		s.processing--
+		s.rebuildMutex.Unlock()
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	photos, result, err := photo.ProcessFiles(ctx, nil, paths, photo.TriggerAdmin)
+	if err != nil {
+		return photos, result, err
+	}
+	if len(photos) == 0 && result.HasFailures() {
+		return nil, result, fmt.Errorf("%s: %s", result.Failed[0].File, result.Failed[0].Error)
+	}
+	return photos, result, nil
+}
+
 // handleRebuild handles POST /api/rebuild
 func (s *AdminServer) handleRebuild(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -218,6 +583,11 @@ func (s *AdminServer) handleRebuild(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Rebuild is already running", http.StatusConflict)
 		return
 	}
+	if s.processing > 0 {
+		s.rebuildMutex.Unlock()
+		http.Error(w, "Photos are being processed", http.StatusConflict)
+		return
+	}
 
 	// Reset rebuild task
 	s.rebuildTask = &RebuildTask{
@@ -225,17 +595,49 @@ func (s *AdminServer) handleRebuild(w http.ResponseWriter, r *http.Request) {
 		Progress:  0,
 		Message:   "Starting rebuild...",
 		StartTime: time.Now(),
-		Logs:      []string{"🚀 开始重建照片库..."},
 	}
+	s.events.reset()
+	s.publishStatusLocked()
+	s.logLocked("🚀 开始重建照片库...")
+	// The rebuild outlives the request, so it gets its own context
+	ctx, cancel := context.WithCancel(context.Background())
+	s.rebuildCancel = cancel
 	s.rebuildMutex.Unlock()
 
 	// Run rebuild in background
-	go s.runRebuild()
+	go s.runRebuild(ctx, photo.UpdateOptions{
+		Full:           r.URL.Query().Get("full") == "true",
+		AllowDeletions: r.URL.Query().Get("allow_deletions") == "true",
+		Trigger:        photo.TriggerAdmin,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "started"})
 }
 
+// handleRebuildCancel handles POST /api/rebuild/cancel
+func (s *AdminServer) handleRebuildCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.rebuildMutex.Lock()
+	if s.rebuildTask.Status != "running" || s.rebuildCancel == nil {
+		s.rebuildMutex.Unlock()
+		http.Error(w, "No rebuild is running", http.StatusConflict)
+		return
+	}
+	s.rebuildCancel()
+	s.rebuildTask.Message = "Cancelling rebuild..."
+	s.logLocked("⏹ 正在取消重建...")
+	s.publishStatusLocked()
+	s.rebuildMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "cancelling"})
+}
+
 // handleRebuildStatus handles GET /api/rebuild/status
 func (s *AdminServer) handleRebuildStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -245,12 +647,207 @@ func (s *AdminServer) handleRebuildStatus(w http.ResponseWriter, r *http.Request
 
 	s.rebuildMutex.Lock()
 	task := *s.rebuildTask
+	task.Logs = append([]string{}, s.rebuildTask.Logs...) // Logs are shifted in place once full
 	s.rebuildMutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
 
+// handleRebuildJobs handles GET /api/rebuild/jobs, the job history without logs, and
+// GET /api/rebuild/jobs/:id, a single job with its logs and result
+func (s *AdminServer) handleRebuildJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	store := photo.NewJobStore(s.rootDir)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/rebuild/jobs"), "/")
+	if id == "" {
+		jobs, err := store.List()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list jobs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobs)
+		return
+	}
+
+	job, err := store.Load(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, fmt.Sprintf("Job not found: %s", id), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleManifestVersions handles GET /api/manifest/versions, the local backups and R2 snapshots
+// of photos.json with their photo counts and diff stats against the current one. The changes
+// photo by photo are listed by /api/manifest/diff.
+func (s *AdminServer) handleManifestVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions, err := photo.ListManifestVersions(r.Context(), s.rootDir, s.R2Client)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list versions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []photo.ManifestVersion{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// handleManifestDiff handles GET /api/manifest/diff?from=ID&to=ID, comparing two versions of
+// photos.json photo by photo; both default to the current photos.json
+func (s *AdminServer) handleManifestDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var contents [2][]byte
+	for i, param := range []string{"from", "to"} {
+		id := r.URL.Query().Get(param)
+		if id == "" {
+			id = photo.CurrentVersion
+		}
+		data, err := photo.LoadManifestVersion(r.Context(), s.rootDir, s.R2Client, id)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, photo.ErrVersionNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, fmt.Sprintf("Failed to load version: %v", err), status)
+			return
+		}
+		contents[i] = data
+	}
+
+	diff, err := photo.DiffManifests(contents[0], contents[1])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to compare versions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// ManifestRollbackRequest represents the body of POST /api/manifest/rollback
+type ManifestRollbackRequest struct {
+	ID string `json:"id"` // ID of a version listed by /api/manifest/versions
+}
+
+// handleManifestRollback handles POST /api/manifest/rollback, replacing photos.json locally, in
+// R2 and in KV with a previous version. The current version is backed up first, so a rollback
+// can itself be rolled back. Objects of photos hidden or shown since the version are moved to
+// match it; objects deleted since the version are not restored.
+func (s *AdminServer) handleManifestRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ManifestRollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	data, err := photo.LoadManifestVersion(r.Context(), s.rootDir, s.R2Client, req.ID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, photo.ErrVersionNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("Failed to load version: %v", err), status)
+		return
+	}
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate version: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	unlock, err := s.lockPhotos()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to lock photos: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer unlock()
+
+	// A rebuild or processing would overwrite the rolled back version when it finishes. Checked
+	// and claimed under the locks, so neither can start until the rollback is done.
+	s.rebuildMutex.Lock()
+	if s.rebuildTask.Status == "running" || s.processing > 0 {
+		s.rebuildMutex.Unlock()
+		http.Error(w, "Rebuild or processing is running", http.StatusConflict)
+		return
+	}
+	s.processing++
+	s.rebuildMutex.Unlock()
+	defer func() {
+		s.rebuildMutex.Lock()
+		s.processing--
+		s.rebuildMutex.Unlock()
+	}()
+
+	// Photos hidden or shown since the version still have their objects on the other side, where
+	// the objects are is looked up in R2 as the URLs of the version may be outdated. Objects and
+	// publishing are not cancelled with the request once they are touched.
+	ctx := context.WithoutCancel(r.Context())
+	moves, err := photo.RepairVisibility(ctx, s.R2Client, manifest.Albums)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to move objects: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Versions written with an older schema are migrated before they are written back
+	data, err = json.MarshalIndent(photo.NewManifest(manifest.Albums), "", "  ")
+	if err == nil {
+		err = photo.WriteManifest(s.rootDir, data)
+	}
+	if err != nil {
+		for _, move := range moves {
+			if err := move.Abort(ctx); err != nil {
+				log.Printf("⚠ Warning: %v", err)
+			}
+		}
+		http.Error(w, fmt.Sprintf("Failed to write photos.json: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, move := range moves {
+		if err := move.Commit(ctx); err != nil {
+			log.Printf("⚠ Warning: %v", err)
+		}
+	}
+	log.Printf("✓ Rolled back photos.json to %s, moved the objects of %d photos", req.ID, len(moves))
+
+	// Publishing is not cancelled with the request once the local file is written
+	var publishErrs []string
+	for _, err := range photo.PublishManifest(ctx, s.rootDir, s.R2Client, data, log.Printf) {
+		publishErrs = append(publishErrs, err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "rolled_back",
+		"id":             req.ID,
+		"publish_errors": publishErrs,
+	})
+}
+
 // handleImageServe handles GET /api/images/:year/:filename
 func (s *AdminServer) handleImageServe(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -321,10 +918,46 @@ func (s *AdminServer) handleProxy(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, resp.Body)
 }
 
+// handlePrivateObject handles GET /api/private?key=..., serving the display image or thumbnail of a
+// hidden photo from the private bucket, which has no public domain
+func (s *AdminServer) handlePrivateObject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.R2Client == nil {
+		http.Error(w, "R2 is not configured", http.StatusNotFound)
+		return
+	}
+
+	// Only photo renditions are served, not RAW archives, manifests or the trash
+	cfg := s.R2Client.Config
+	key := strings.TrimPrefix(r.URL.Query().Get("key"), storage.PrivateURLScheme)
+	base := cfg.PrivatePrefix + cfg.BasePrefix
+	if strings.Contains(key, "..") ||
+		!(strings.HasPrefix(key, base+cfg.OriginalPrefix) || strings.HasPrefix(key, base+cfg.ThumbnailPrefix)) {
+		http.Error(w, "Invalid key", http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.R2Client.DownloadBytes(r.Context(), key)
+	if err != nil {
+		log.Printf("❌ Failed to read %s: %v", key, err)
+		http.Error(w, fmt.Sprintf("Failed to read %s: %v", key, err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(data)
+}
+
 // updatePhoto updates a single photo's metadata in photos.json
-func (s *AdminServer) updatePhoto(filename string, req PhotoUpdateRequest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *AdminServer) updatePhoto(ctx context.Context, filename string, req PhotoUpdateRequest) error {
+	unlock, err := s.lockPhotos()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Read current photos.json
 	data, err := os.ReadFile(s.photosPath)
@@ -332,50 +965,164 @@ func (s *AdminServer) updatePhoto(filename string, req PhotoUpdateRequest) error
 		return fmt.Errorf("failed to read photos.json: %w", err)
 	}
 
-	var albums []photo.YearAlbum
-	if err := json.Unmarshal(data, &albums); err != nil {
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
 		return fmt.Errorf("failed to parse photos.json: %w", err)
 	}
+	albums := manifest.Albums
 
-	// Find and update the photo
-	found := false
+	// Find the photo and validate the request before anything is changed
+	var target *photo.Photo
 	for i := range albums {
 		for j := range albums[i].Photos {
 			if albums[i].Photos[j].Filename == filename {
-				if req.Alt != nil {
-					albums[i].Photos[j].Alt = *req.Alt
-				}
-				if req.IsHidden != nil {
-					albums[i].Photos[j].IsHidden = *req.IsHidden
-				}
-				if req.Subject != nil {
-					albums[i].Photos[j].Subject = req.Subject
-				}
-				found = true
+				target = &albums[i].Photos[j]
 				break
 			}
 		}
-		if found {
+		if target != nil {
 			break
 		}
 	}
-
-	if !found {
+	if target == nil {
 		return fmt.Errorf("photo not found: %s", filename)
 	}
+	if req.Rating != nil && (*req.Rating < 0 || *req.Rating > 5) {
+		return fmt.Errorf("rating must be between 0 and 5")
+	}
+
+	// Manual lens assignments live in the gear registry so they survive rebuilds
+	var gear *photo.GearRegistry
+	if req.Lens != nil {
+		gearPath := photo.GearRegistryPath(s.rootDir)
+		if gear, err = photo.LoadGearRegistry(gearPath); err != nil {
+			return err
+		}
+		gear.AssignLens(filename, *req.Lens)
+		if err := gear.Save(gearPath); err != nil {
+			return fmt.Errorf("failed to save gear registry: %w", err)
+		}
+	}
+
+	// Hiding or showing the photo moves its objects, the copies are removed if it fails
+	var move *photo.VisibilityMove
+	written := false
+	defer func() {
+		if !written {
+			if err := move.Abort(context.WithoutCancel(ctx)); err != nil {
+				log.Printf("⚠ Warning: %v", err)
+			}
+		}
+	}()
+
+	// Update the photo
+	before := photo.MetadataOf(*target)
+	if req.Alt != nil {
+		target.Alt = *req.Alt
+	}
+	if req.Title != nil {
+		target.Title = *req.Title
+	}
+	if req.Rating != nil {
+		target.Rating = *req.Rating
+	}
+	if req.IsHidden != nil {
+		target.IsHidden = *req.IsHidden
+		// The objects are copied now and the old ones removed once photos.json is written
+		if move, err = photo.MoveVisibility(ctx, s.R2Client, target); err != nil {
+			return fmt.Errorf("failed to move objects of %s: %w", filename, err)
+		}
+	}
+	if req.Subject != nil {
+		target.Subject = req.Subject
+	}
+	if gear != nil {
+		gear.Apply(target)
+	}
+	undoWriteback := func() {}
+	if !reflect.DeepEqual(before, photo.MetadataOf(*target)) {
+		if undoWriteback, err = s.writeBackMetadata(ctx, target); err != nil {
+			return err
+		}
+	}
 
 	// Write back to photos.json using unified function
-	if err := s.updatePhotoJson(albums); err != nil {
+	if err := s.updatePhotoJson(ctx, albums); err != nil {
+		undoWriteback()
 		return fmt.Errorf("failed to update photos.json: %w", err)
 	}
+	written = true
+	if err := move.Commit(context.WithoutCancel(ctx)); err != nil {
+		log.Printf("⚠ Warning: %v", err)
+	}
 
 	return nil
 }
 
+// writeBackMetadata writes the edited metadata into the source file or its sidecar,
+// according to PHOTO_METADATA_WRITEBACK. Embedding changes the file, so its R2 copies are
+// uploaded again and the hash is refreshed to keep the next rebuild from treating the photo as
+// modified. It returns a function that undoes the write when photos.json cannot be written.
+func (s *AdminServer) writeBackMetadata(ctx context.Context, target *photo.Photo) (func(), error) {
+	mode := photo.CurrentWritebackMode()
+	if mode == photo.WritebackOff {
+		return func() {}, nil
+	}
+
+	sourcePath, err := photo.LocateSource(s.imagesDir, *target)
+	if err != nil {
+		return nil, err
+	}
+	restore, err := photo.SnapshotMetadata(sourcePath, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to back up %s: %w", target.Filename, err)
+	}
+	if err := photo.WriteMetadata(ctx, sourcePath, photo.MetadataOf(*target), mode); err != nil {
+		return nil, fmt.Errorf("failed to write metadata to %s: %w", target.Filename, err)
+	}
+
+	uploaded := false
+	undo := func() {
+		if err := restore(); err != nil {
+			log.Printf("⚠ Warning: failed to restore %s: %v", target.Filename, err)
+			return
+		}
+		if uploaded {
+			if err := photo.UploadEmbedded(context.WithoutCancel(ctx), s.R2Client, *target, sourcePath); err != nil {
+				log.Printf("⚠ Warning: %v", err)
+			}
+		}
+		log.Printf("✓ Restored %s", target.Filename)
+	}
+
+	if mode == photo.WritebackEmbed {
+		hash, err := photo.FileHash(sourcePath)
+		if err != nil {
+			undo()
+			return nil, fmt.Errorf("failed to hash %s: %w", target.Filename, err)
+		}
+		// With the hash refreshed no update uploads the file again, so its R2 copies are replaced now
+		uploaded = true
+		if err := photo.UploadEmbedded(ctx, s.R2Client, *target, sourcePath); err != nil {
+			undo()
+			return nil, err
+		}
+		target.Hash = hash
+	}
+	if rel, err := filepath.Rel(s.imagesDir, sourcePath); err == nil {
+		target.Source = filepath.ToSlash(rel)
+	}
+	log.Printf("✓ Wrote metadata (%s) for %s\n", mode, target.Filename)
+	return undo, nil
+}
+
 // deletePhoto deletes a photo from photos.json, R2, and local filesystem
-func (s *AdminServer) deletePhoto(filename string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *AdminServer) deletePhoto(ctx context.Context, filename string) error {
+	unlock, err := s.lockPhotos()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// 1. Read current photos.json
 	data, err := os.ReadFile(s.photosPath)
@@ -383,10 +1130,11 @@ func (s *AdminServer) deletePhoto(filename string) error {
 		return fmt.Errorf("failed to read photos.json: %w", err)
 	}
 
-	var albums []photo.YearAlbum
-	if err := json.Unmarshal(data, &albums); err != nil {
+	manifest, err := photo.ParseManifest(data)
+	if err != nil {
 		return fmt.Errorf("failed to parse photos.json: %w", err)
 	}
+	albums := manifest.Albums
 
 	// 2. Find and remove the photo
 	found := false
@@ -413,114 +1161,96 @@ func (s *AdminServer) deletePhoto(filename string) error {
 	}
 
 	// 3. Update photos.json (Local + R2 + KV)
-	if err := s.updatePhotoJson(albums); err != nil {
+	if err := s.updatePhotoJson(ctx, albums); err != nil {
 		return fmt.Errorf("failed to update photos.json: %w", err)
 	}
 
-	// 4. Delete from R2
-	if s.R2Client != nil {
-		var keysToDelete []string
-
-		// Original
-		keysToDelete = append(
-			keysToDelete, fmt.Sprintf(
-				"%s%s%s",
-				s.R2Client.Config.BasePrefix,
-				s.R2Client.Config.OriginalPrefix,
-				filename,
-			),
-		)
-
-		// Thumbnail
-		filenameNoExt := strings.TrimSuffix(filename, filepath.Ext(filename))
-		keysToDelete = append(
-			keysToDelete, fmt.Sprintf(
-				"%s%s%s%s",
-				s.R2Client.Config.BasePrefix,
-				s.R2Client.Config.ThumbnailPrefix,
-				filenameNoExt,
-				photo.ExtWebP,
-			),
-		)
-
-		log.Printf("🟢 Deleting files from R2 for %s...\n", filename)
-		if err := s.R2Client.DeleteObjects(keysToDelete); err != nil {
-			log.Printf("Error deleting objects from R2: %v", err)
-		} else {
-			log.Printf("✓ Deleted files from R2")
+	// 4. Move the R2 objects, stored metadata and local files, including a paired RAW, to the
+	// trash, from where `update restore` brings the photo back
+	var localPaths []string
+	if localPath, err := photo.LocateSource(s.imagesDir, targetPhoto); err != nil {
+		log.Printf("Warning: %v, skipping local delete", err)
+	} else {
+		localPaths = append(localPaths, localPath)
+		if targetPhoto.Raw != "" && targetPhoto.Raw != targetPhoto.Filename {
+			rawPath := filepath.Join(filepath.Dir(localPath), targetPhoto.Raw)
+			if _, err := os.Stat(rawPath); err == nil {
+				localPaths = append(localPaths, rawPath)
+			}
 		}
 	}
-
-	// 5. Delete from local filesystem
-	if targetPhoto.Year != "" {
-		localPath := filepath.Join(s.imagesDir, targetPhoto.Year, filename)
-		log.Printf("Deleting local file: %s\n", localPath)
-		if err := os.Remove(localPath); err != nil {
-			log.Printf("Error deleting local file: %v", err)
-		}
+	log.Printf("🟢 Moving %s to the trash...\n", filename)
+	batch, err := photo.TrashDeleted(context.WithoutCancel(ctx), s.rootDir, s.imagesDir, s.R2Client, targetPhoto, localPaths)
+	if err != nil {
+		log.Printf("Error moving %s to the trash: %v", filename, err)
 	} else {
-		log.Printf("Warning: Photo year not found, skipping local delete for %s", filename)
+		log.Printf("✓ Moved %s to the trash, restore it with: update restore --job %s", filename, batch.ID)
 	}
 
 	return nil
 }
 
-// updatePhotoJson updates photos.json locally, in R2, and in KV
-func (s *AdminServer) updatePhotoJson(albums []photo.YearAlbum) error {
+// lockPhotos takes the server's lock and the manifest lock shared with the update command,
+// for reading, modifying and writing photos.json
+func (s *AdminServer) lockPhotos() (func(), error) {
+	s.mu.Lock()
+	unlockManifest, err := photo.LockManifest(s.rootDir)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockManifest()
+		s.mu.Unlock()
+	}, nil
+}
+
+// updatePhotoJson updates photos.json locally, in R2, and in KV. The caller holds lockPhotos.
+// Publishing is not cancelled with ctx once the local file is written, so the copies stay in sync.
+func (s *AdminServer) updatePhotoJson(ctx context.Context, albums []photo.YearAlbum) error {
+	ctx = context.WithoutCancel(ctx)
+
 	// 1. Marshal to JSON
-	jsonData, err := json.MarshalIndent(albums, "", "  ")
+	jsonData, err := json.MarshalIndent(photo.NewManifest(albums), "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	// 2. Write to local file
-	if err := os.WriteFile(s.photosPath, jsonData, 0644); err != nil {
+	// 2. Write to local file, backing up the previous version
+	if err := photo.WriteManifest(s.rootDir, jsonData); err != nil {
 		return fmt.Errorf("failed to write local photos.json: %w", err)
 	}
 
-	// 3. Upload to R2 if client is available
-	if s.R2Client != nil {
-		jsonKey := fmt.Sprintf("%sphotos.json", s.R2Client.Config.BasePrefix)
-		if err := s.R2Client.UploadBytes(
-			jsonData, jsonKey, "application/json", "no-cache",
-		); err != nil {
-			log.Printf("❌ Failed to upload photos.json to R2: %v", err)
-			// Don't fail the request if R2 upload fails, but log it
-		} else {
-			log.Printf("✓ Uploaded photos.json to R2")
-		}
-	}
-
-	// 4. Update KV if client is available
-	if storage.CFCli != nil {
-		key := fmt.Sprintf("cache:photos:%s", "jsonValue")
-		// 86400 seconds = 24 hours
-		err := storage.CfKvSetValue(key, string(jsonData), 86400)
-		if err != nil {
-			log.Printf("❌ Error setting value for KV %s: %v", key, err)
-		} else {
-			log.Printf("✓ Uploaded photos.json to KV[%s]", key)
-		}
-	}
+	// 3. Upload to R2 and update KV, failures are logged without failing the request
+	photo.PublishManifest(ctx, s.rootDir, s.R2Client, jsonData, log.Printf)
 
 	return nil
 }
 
 // runRebuild executes the rebuild process
-func (s *AdminServer) runRebuild() {
+func (s *AdminServer) runRebuild(ctx context.Context, opts photo.UpdateOptions) {
+	defer func() {
+		s.rebuildMutex.Lock()
+		if s.rebuildCancel != nil {
+			s.rebuildCancel()
+			s.rebuildCancel = nil
+		}
+		s.rebuildMutex.Unlock()
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			s.rebuildMutex.Lock()
 			s.rebuildTask.Status = "failed"
 			s.rebuildTask.Message = fmt.Sprintf("Rebuild panicked: %v", r)
 			s.rebuildTask.EndTime = time.Now()
-			s.rebuildTask.Logs = append(s.rebuildTask.Logs, fmt.Sprintf("❌ 重建失败: %v", r))
+			s.logLocked(fmt.Sprintf("❌ 重建失败: %v", r))
+			s.publishStatusLocked()
 			s.rebuildMutex.Unlock()
 		}
 	}()
 
-	s.addLog("📸 调用 photo.UpdatePhotosHandler...")
-	s.updateProgress(10, "Processing photos...")
+	s.addLog("📸 调用 photo.Rebuild...")
+	opts.OnProgress = s.reportProgress
 
 	// Create a channel for logs
 	// Buffer it slightly to avoid blocking the processor too much
@@ -537,34 +1267,88 @@ func (s *AdminServer) runRebuild() {
 	}()
 
 	// Run the update
-	photo.UpdatePhotosHandler(logChan)
+	result, err := photo.Rebuild(ctx, logChan, opts)
 	close(logChan)
 
 	// Wait for logging to finish
 	logWg.Wait()
 
 	s.rebuildMutex.Lock()
+	defer s.rebuildMutex.Unlock()
+	defer s.publishStatusLocked()
+	s.rebuildTask.Result = result
+	s.rebuildTask.EndTime = time.Now()
+	if errors.Is(err, context.Canceled) {
+		s.rebuildTask.Status = "cancelled"
+		s.rebuildTask.Message = "Rebuild cancelled, photos.json was not modified"
+		s.logLocked("⏹ 重建已取消，photos.json 未修改")
+		return
+	}
+	if err != nil {
+		s.rebuildTask.Status = "failed"
+		s.rebuildTask.Message = fmt.Sprintf("Rebuild failed: %v", err)
+		s.rebuildTask.DeletionBlocked = errors.Is(err, photo.ErrTooManyDeletions)
+		s.logLocked(fmt.Sprintf("❌ 重建失败: %v", err))
+		return
+	}
+
 	s.rebuildTask.Status = "completed"
 	s.rebuildTask.Progress = 100
 	s.rebuildTask.Message = "Rebuild completed successfully"
-	s.rebuildTask.EndTime = time.Now()
-	s.rebuildTask.Logs = append(s.rebuildTask.Logs, "✅ 重建完成！")
-	s.rebuildMutex.Unlock()
+	if result.HasFailures() {
+		s.rebuildTask.Message = fmt.Sprintf("Rebuild completed with %d failed photos", len(result.Failed))
+	}
+	s.logLocked(fmt.Sprintf("✅ 重建完成！%s", result.Summary()))
 }
 
 // addLog adds a log entry to the rebuild task
 func (s *AdminServer) addLog(message string) {
 	s.rebuildMutex.Lock()
-	s.rebuildTask.Logs = append(s.rebuildTask.Logs, message)
+	s.logLocked(message)
 	s.rebuildMutex.Unlock()
 }
 
-// updateProgress updates the rebuild progress
-func (s *AdminServer) updateProgress(progress int, message string) {
+// logLocked appends a log entry, dropping the oldest beyond maxBufferedLogs, and streams it.
+// Callers hold rebuildMutex.
+func (s *AdminServer) logLocked(message string) {
+	logs := s.rebuildTask.Logs
+	if len(logs) >= maxBufferedLogs {
+		logs = logs[:copy(logs, logs[len(logs)-maxBufferedLogs+1:])]
+	}
+	s.rebuildTask.Logs = append(logs, message)
+	s.events.publish(eventLog, map[string]string{"message": message})
+}
+
+// publishStatusLocked streams the rebuild task without its logs. Callers hold rebuildMutex.
+func (s *AdminServer) publishStatusLocked() {
+	task := *s.rebuildTask
+	task.Logs = nil
+	s.events.publish(eventStatus, task)
+}
+
+// reportProgress stores a progress event of the processor in the rebuild task
+func (s *AdminServer) reportProgress(event photo.ProgressEvent) {
+	message := fmt.Sprintf("Stage: %s", event.Stage)
+	if event.Stage == photo.StageProcess {
+		message = fmt.Sprintf("Processing %d/%d photos", event.Processed, event.Total)
+		if event.CurrentFile != "" {
+			message += ": " + event.CurrentFile
+		}
+	}
+
 	s.rebuildMutex.Lock()
-	s.rebuildTask.Progress = progress
-	s.rebuildTask.Message = message
-	s.rebuildMutex.Unlock()
+	defer s.rebuildMutex.Unlock()
+	s.rebuildTask.Detail = &event
+	if event.Stage != photo.StageDone {
+		// The final status and message are set by runRebuild
+		s.rebuildTask.Progress = event.Percent()
+		s.rebuildTask.Message = message
+	}
+	s.events.publish(eventProgress, progressPayload{
+		Progress: s.rebuildTask.Progress,
+		Message:  s.rebuildTask.Message,
+		Detail:   event,
+	})
 }
 
 // handlePhotoUpload handles POST /api/photos/upload
@@ -588,8 +1372,13 @@ func (s *AdminServer) handlePhotoUpload(w http.ResponseWriter, r *http.Request)
 	}
 	defer file.Close()
 
+	if !photo.IsSupportedImage(header.Filename) {
+		http.Error(w, fmt.Sprintf("Unsupported file type: %s", header.Filename), http.StatusBadRequest)
+		return
+	}
+
 	// Extract year from EXIF or use current year
-	year := s.extractYearFromFile(file, header.Filename)
+	year := s.extractYearFromFile(r.Context(), file, header.Filename)
 
 	// Reset file pointer
 	file.Seek(0, 0)
@@ -608,28 +1397,44 @@ func (s *AdminServer) handlePhotoUpload(w http.ResponseWriter, r *http.Request)
 		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, file); err != nil {
+	_, err = io.Copy(dst, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to save file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	response := map[string]interface{}{
+		"status":   "success",
+		"filename": header.Filename,
+		"year":     year,
+	}
+
+	// ?process=true adds the photo to photos.json right away. The upload itself succeeded
+	// either way, a processing error is reported alongside.
+	if r.URL.Query().Get("process") == "true" {
+		photos, _, err := s.processPaths(r.Context(), []string{targetPath})
+		if len(photos) > 0 {
+			response["photo"] = photos[0]
+		}
+		if err != nil {
+			log.Printf("❌ Failed to process %s: %v", header.Filename, err)
+			response["process_error"] = err.Error()
+		}
+	}
+
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(
-		map[string]string{
-			"status":   "success",
-			"filename": header.Filename,
-			"year":     year,
-		},
-	)
+	json.NewEncoder(w).Encode(response)
 }
 
 // extractYearFromFile extracts year from EXIF or filename
-func (s *AdminServer) extractYearFromFile(file io.ReadSeeker, filename string) string {
-	// Try to create a temporary file for EXIF extraction
-	tmpFile, err := os.CreateTemp("", "upload-*.jpg")
+func (s *AdminServer) extractYearFromFile(ctx context.Context, file io.ReadSeeker, filename string) string {
+	// Try to create a temporary file for EXIF extraction, keeping the extension so the
+	// extractor recognizes HEIC and RAW containers
+	tmpFile, err := os.CreateTemp("", "upload-*"+strings.ToLower(filepath.Ext(filename)))
 	if err == nil {
 		defer os.Remove(tmpFile.Name())
 		defer tmpFile.Close()
@@ -640,7 +1445,7 @@ func (s *AdminServer) extractYearFromFile(file io.ReadSeeker, filename string) s
 		tmpFile.Sync()
 
 		// Extract EXIF
-		_, _, _, dateTaken, err := photo.GetExifExtractor().Extract(tmpFile.Name())
+		_, _, _, dateTaken, err := photo.GetExifExtractor().Extract(ctx, tmpFile.Name())
 		if err == nil && !dateTaken.IsZero() {
 			return fmt.Sprintf("%04d", dateTaken.Year())
 		}
@@ -0,0 +1,308 @@
+// Package geo provides offline reverse geocoding backed by a GeoNames-style city dataset.
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxDistanceKm is how far the nearest city may be before a coordinate is considered unknown
+const DefaultMaxDistanceKm = 100
+
+const earthRadiusKm = 6371.0
+
+//go:embed data/cities.tsv data/countries.tsv
+var bundledData embed.FS
+
+// Place is the result of a reverse geocoding lookup
+type Place struct {
+	Country     string  `json:"country,omitempty"`
+	CountryCode string  `json:"country_code,omitempty"`
+	Region      string  `json:"region,omitempty"`
+	City        string  `json:"city,omitempty"`
+	DistanceKm  float64 `json:"distance_km,omitempty"` // Distance to the matched city
+}
+
+type city struct {
+	name        string
+	lat, lon    float64
+	countryCode string
+	region      string
+}
+
+// Geocoder answers nearest-city queries against an in-memory dataset
+type Geocoder struct {
+	cities        []city
+	countries     map[string]string // ISO code -> name
+	grid          map[[2]int][]int  // 1° cell -> indexes into cities
+	MaxDistanceKm float64
+}
+
+var (
+	defaultGeocoder *Geocoder
+	defaultOnce     sync.Once
+)
+
+// Default returns the shared geocoder. It uses the GeoNames files named by GEONAMES_CITIES_FILE,
+// GEONAMES_ADMIN1_FILE and GEONAMES_COUNTRIES_FILE when set, and the bundled dataset otherwise.
+func Default() *Geocoder {
+	defaultOnce.Do(
+		func() {
+			g, err := loadFromEnv()
+			if err != nil {
+				log.Printf("⚠ Warning: failed to load GeoNames dataset, using bundled data: %v\n", err)
+				g = nil
+			}
+			if g == nil {
+				g, err = loadBundled()
+				if err != nil {
+					// The bundled files are part of the binary, this only happens if they are malformed
+					log.Printf("❌ Failed to load bundled geocoding data: %v\n", err)
+					g = &Geocoder{countries: map[string]string{}, grid: map[[2]int][]int{}}
+				}
+			}
+			g.MaxDistanceKm = DefaultMaxDistanceKm
+			defaultGeocoder = g
+		},
+	)
+	return defaultGeocoder
+}
+
+func loadBundled() (*Geocoder, error) {
+	cities, err := bundledData.ReadFile("data/cities.tsv")
+	if err != nil {
+		return nil, err
+	}
+	countries, err := bundledData.ReadFile("data/countries.tsv")
+	if err != nil {
+		return nil, err
+	}
+	return NewGeocoder(bytes.NewReader(cities), nil, bytes.NewReader(countries))
+}
+
+func loadFromEnv() (*Geocoder, error) {
+	citiesPath := os.Getenv("GEONAMES_CITIES_FILE")
+	if citiesPath == "" {
+		return nil, nil
+	}
+
+	cities, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cities.Close()
+
+	var admin1, countries io.Reader
+	if path := os.Getenv("GEONAMES_ADMIN1_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		admin1 = f
+	}
+	if path := os.Getenv("GEONAMES_COUNTRIES_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		countries = f
+	} else {
+		data, err := bundledData.ReadFile("data/countries.tsv")
+		if err != nil {
+			return nil, err
+		}
+		countries = bytes.NewReader(data)
+	}
+
+	g, err := NewGeocoder(cities, admin1, countries)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("✓ Loaded %d places from GeoNames file: %s\n", len(g.cities), citiesPath)
+	return g, nil
+}
+
+// NewGeocoder builds a geocoder from tab separated data.
+//
+// Cities are either GeoNames "cities" dumps (19 columns, region given as an admin1 code resolved
+// through admin1) or the bundled short form: name, latitude, longitude, country code, region.
+// Countries are either GeoNames countryInfo.txt or "code<TAB>name" lines. admin1 may be nil.
+func NewGeocoder(cities, admin1, countries io.Reader) (*Geocoder, error) {
+	g := &Geocoder{
+		countries:     make(map[string]string),
+		grid:          make(map[[2]int][]int),
+		MaxDistanceKm: DefaultMaxDistanceKm,
+	}
+
+	if countries != nil {
+		err := readTSV(
+			countries, func(fields []string) error {
+				switch {
+				case len(fields) >= 5: // countryInfo.txt: ISO, ISO3, ISO-Numeric, fips, Country
+					g.countries[fields[0]] = fields[4]
+				case len(fields) >= 2:
+					g.countries[fields[0]] = fields[1]
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read countries: %w", err)
+		}
+	}
+
+	regions := make(map[string]string) // "CN.02" -> "Zhejiang"
+	if admin1 != nil {
+		err := readTSV(
+			admin1, func(fields []string) error {
+				if len(fields) >= 3 {
+					regions[fields[0]] = fields[2] // ASCII name
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin1 codes: %w", err)
+		}
+	}
+
+	err := readTSV(
+		cities, func(fields []string) error {
+			var c city
+			var err1, err2 error
+			switch {
+			case len(fields) >= 11: // GeoNames: name=1, lat=4, lon=5, country=8, admin1=10
+				c.name = fields[1]
+				c.lat, err1 = strconv.ParseFloat(fields[4], 64)
+				c.lon, err2 = strconv.ParseFloat(fields[5], 64)
+				c.countryCode = fields[8]
+				c.region = regions[fields[8]+"."+fields[10]]
+			case len(fields) >= 4:
+				c.name = fields[0]
+				c.lat, err1 = strconv.ParseFloat(fields[1], 64)
+				c.lon, err2 = strconv.ParseFloat(fields[2], 64)
+				c.countryCode = fields[3]
+				if len(fields) >= 5 {
+					c.region = fields[4]
+				}
+			default:
+				return fmt.Errorf("unexpected column count %d", len(fields))
+			}
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("invalid coordinates for %s", c.name)
+			}
+			g.add(c)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cities: %w", err)
+	}
+
+	return g, nil
+}
+
+func (g *Geocoder) add(c city) {
+	g.cities = append(g.cities, c)
+	cell := cellOf(c.lat, c.lon)
+	g.grid[cell] = append(g.grid[cell], len(g.cities)-1)
+}
+
+// Lookup returns the place nearest to the coordinate, or false if nothing is within MaxDistanceKm
+func (g *Geocoder) Lookup(lat, lon float64) (Place, bool) {
+	if g == nil || len(g.cities) == 0 || math.IsNaN(lat) || math.IsNaN(lon) {
+		return Place{}, false
+	}
+
+	maxDistance := g.MaxDistanceKm
+	if maxDistance <= 0 {
+		maxDistance = DefaultMaxDistanceKm
+	}
+
+	// Only visit the cells that can contain a city within range
+	latSpan := int(math.Ceil(maxDistance/111.0)) + 1
+	cosLat := math.Cos(lat * math.Pi / 180)
+	lonSpan := 180
+	if cosLat > 0.01 {
+		lonSpan = min(int(math.Ceil(maxDistance/(111.0*cosLat)))+1, 180)
+	}
+
+	center := cellOf(lat, lon)
+	best, bestDistance := -1, math.MaxFloat64
+	for dLat := -latSpan; dLat <= latSpan; dLat++ {
+		for dLon := -lonSpan; dLon <= lonSpan; dLon++ {
+			cellLon := ((center[1]+dLon+180)%360+360)%360 - 180
+			for _, i := range g.grid[[2]int{center[0] + dLat, cellLon}] {
+				d := haversineKm(lat, lon, g.cities[i].lat, g.cities[i].lon)
+				if d < bestDistance {
+					best, bestDistance = i, d
+				}
+			}
+		}
+	}
+
+	if best < 0 || bestDistance > maxDistance {
+		return Place{}, false
+	}
+
+	c := g.cities[best]
+	country := g.countries[c.countryCode]
+	if country == "" {
+		country = c.countryCode
+	}
+	return Place{
+		Country:     country,
+		CountryCode: c.countryCode,
+		Region:      c.region,
+		City:        c.name,
+		DistanceKm:  math.Round(bestDistance*10) / 10,
+	}, true
+}
+
+// cellOf returns the 1° cell of a coordinate. The longitude is wrapped into [-180,180) first, so
+// points on the antimeridian share the cells the neighbour search visits.
+func cellOf(lat, lon float64) [2]int {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon - 180))}
+}
+
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// readTSV calls fn for each non-empty, non-comment line split on tabs
+func readTSV(r io.Reader, fn func(fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := fn(strings.Split(text, "\t")); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
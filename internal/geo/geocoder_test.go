@@ -0,0 +1,47 @@
+package geo
+
+import "testing"
+
+func TestCellOf(t *testing.T) {
+	tests := []struct {
+		lat, lon float64
+		want     [2]int
+	}{
+		{lat: 52.5, lon: 13.4, want: [2]int{52, 13}},
+		{lat: -33.9, lon: -70.6, want: [2]int{-34, -71}},
+		{lat: -16.5, lon: 179.9, want: [2]int{-17, 179}},
+		{lat: -16.5, lon: 180, want: [2]int{-17, -180}},
+		{lat: -16.5, lon: -180, want: [2]int{-17, -180}},
+		{lat: -16.5, lon: 180.4, want: [2]int{-17, -180}},
+		{lat: -16.5, lon: -180.4, want: [2]int{-17, 179}},
+	}
+	for _, tt := range tests {
+		if got := cellOf(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("cellOf(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+		}
+	}
+}
+
+func TestLookupAntimeridian(t *testing.T) {
+	tests := []struct {
+		name string
+		city float64 // Longitude of the only city
+		lon  float64 // Longitude looked up
+	}{
+		{name: "city at 180", city: 180, lon: 179.9},
+		{name: "city at -180", city: -180, lon: 179.9},
+		{name: "lookup at 180", city: -179.9, lon: 180},
+		{name: "lookup at -180", city: 179.9, lon: -180},
+		{name: "city and lookup at 180", city: 180, lon: 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Geocoder{countries: map[string]string{"FJ": "Fiji"}, grid: map[[2]int][]int{}, MaxDistanceKm: 50}
+			g.add(city{name: "Antimeridian", lat: -16.5, lon: tt.city, countryCode: "FJ"})
+			place, ok := g.Lookup(-16.5, tt.lon)
+			if !ok || place.City != "Antimeridian" {
+				t.Errorf("Lookup(-16.5, %v) = %+v, %v, want the city at longitude %v", tt.lon, place, ok, tt.city)
+			}
+		})
+	}
+}
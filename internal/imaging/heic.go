@@ -0,0 +1,75 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// heicConverter is an external tool that converts HEIC/HEIF to JPEG
+type heicConverter struct {
+	name string
+	args func(in, out string) []string
+}
+
+// heicConverters are tried in order: sips ships with macOS, heif-convert with libheif,
+// magick with ImageMagick 7 built against libheif
+var heicConverters = []heicConverter{
+	{"sips", func(in, out string) []string { return []string{"-s", "format", "jpeg", in, "--out", out} }},
+	{"heif-convert", func(in, out string) []string { return []string{"-q", "92", in, out} }},
+	{"magick", func(in, out string) []string { return []string{in, "-auto-orient", "-quality", "92", out} }},
+}
+
+// ConvertHEICToJPEG decodes a HEIC/HEIF file with the first available converter and returns it as JPEG.
+// HEIF_CONVERTER selects a specific converter. The result is re-encoded so it carries no EXIF
+// orientation that would rotate the already upright pixels a second time.
+func ConvertHEICToJPEG(ctx context.Context, heicPath string) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "heic-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, strings.TrimSuffix(filepath.Base(heicPath), filepath.Ext(heicPath))+".jpg")
+
+	preferred := os.Getenv("HEIF_CONVERTER")
+	var errs []string
+	for _, converter := range heicConverters {
+		if preferred != "" && converter.name != preferred {
+			continue
+		}
+		if _, err := exec.LookPath(converter.name); err != nil {
+			continue
+		}
+		if output, err := exec.CommandContext(ctx, converter.name, converter.args(heicPath, out)...).CombinedOutput(); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v: %s", converter.name, err, strings.TrimSpace(string(output))))
+			continue
+		}
+
+		data, err := os.ReadFile(out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read converted image: %w", err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode converted image: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92}); err != nil {
+			return nil, fmt.Errorf("failed to encode converted image: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no HEIC converter found, install libheif (heif-convert) or ImageMagick")
+	}
+	return nil, fmt.Errorf("HEIC conversion failed: %s", strings.Join(errs, "; "))
+}
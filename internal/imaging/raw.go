@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// previewTags are the exiftool tags RAW formats store their embedded JPEG previews in
+var previewTags = []string{"JpgFromRaw", "PreviewImage", "OtherImage"}
+
+// ExtractRawPreview returns the largest embedded JPEG preview of a RAW file, rotated upright.
+//
+// exiftool is used when available since it knows every maker's layout, otherwise the file is
+// scanned for embedded JPEG streams.
+func ExtractRawPreview(ctx context.Context, rawPath string) ([]byte, error) {
+	preview, orientation, err := extractPreviewWithTool(ctx, rawPath)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		data, readErr := os.ReadFile(rawPath)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read raw file: %w", readErr)
+		}
+		if preview = scanLargestJPEG(data); preview == nil {
+			return nil, fmt.Errorf("no embedded JPEG preview found in %s: %w", rawPath, err)
+		}
+		orientation = 1
+	}
+
+	if orientation <= 1 {
+		return preview, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode raw preview: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: 92}); err != nil {
+		return nil, fmt.Errorf("failed to encode raw preview: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// extractPreviewWithTool extracts every preview tag with exiftool and keeps the largest
+func extractPreviewWithTool(ctx context.Context, rawPath string) ([]byte, int, error) {
+	if _, err := exec.LookPath("exiftool"); err != nil {
+		return nil, 0, err
+	}
+
+	var best []byte
+	bestPixels := 0
+	for _, tag := range previewTags {
+		data, err := exec.CommandContext(ctx, "exiftool", "-b", "-"+tag, rawPath).Output()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		if pixels := cfg.Width * cfg.Height; pixels > bestPixels {
+			best, bestPixels = data, pixels
+		}
+	}
+	if best == nil {
+		return nil, 0, fmt.Errorf("exiftool found no preview image")
+	}
+
+	orientation := 1
+	if out, err := exec.CommandContext(ctx, "exiftool", "-n", "-s3", "-Orientation", rawPath).Output(); err == nil {
+		if o, err := strconv.Atoi(strings.TrimSpace(string(out))); err == nil {
+			orientation = o
+		}
+	}
+	return best, orientation, nil
+}
+
+// scanLargestJPEG finds the embedded JPEG stream with the most pixels.
+// A stream ends at the first EOI marker after which the bytes decode as a complete image,
+// so EOI markers of thumbnails nested in APP segments are skipped.
+func scanLargestJPEG(data []byte) []byte {
+	soi := []byte{0xFF, 0xD8, 0xFF}
+	eoi := []byte{0xFF, 0xD9}
+
+	var best []byte
+	bestPixels := 0
+	for offset := 0; ; {
+		start := bytes.Index(data[offset:], soi)
+		if start < 0 {
+			break
+		}
+		start += offset
+		offset = start + len(soi)
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data[start:]))
+		if err != nil || cfg.Width*cfg.Height <= bestPixels {
+			continue
+		}
+
+		for end := start; ; {
+			next := bytes.Index(data[end+len(soi):], eoi)
+			if next < 0 {
+				break
+			}
+			end += len(soi) + next
+			candidate := data[start : end+len(eoi)]
+			if _, err := jpeg.Decode(bytes.NewReader(candidate)); err == nil {
+				best, bestPixels = candidate, cfg.Width*cfg.Height
+				offset = end + len(eoi)
+				break
+			}
+		}
+	}
+	return best
+}
+
+// applyOrientation rotates/flips an image according to its EXIF orientation (1-8)
+func applyOrientation(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	transposed := orientation >= 5
+	dstW, dstH := w, h
+	if transposed {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // Rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // Mirror horizontal and rotate 270 CW
+				dx, dy = y, x
+			case 6: // Rotate 90 CW
+				dx, dy = h-1-y, x
+			case 7: // Mirror horizontal and rotate 90 CW
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotate 270 CW
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
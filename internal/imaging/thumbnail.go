@@ -42,6 +42,21 @@ func GenerateThumbnail(imagePath string, config ThumbnailConfig) ([]byte, error)
 		return nil, fmt.Errorf("failed to decode image: %w", err)
 	}
 
+	return encodeThumbnail(img, config)
+}
+
+// GenerateThumbnailFromBytes generates a WebP thumbnail from encoded image data, e.g. a RAW preview
+func GenerateThumbnailFromBytes(data []byte, config ThumbnailConfig) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	return encodeThumbnail(img, config)
+}
+
+// encodeThumbnail resizes img to the configured width and encodes it as WebP
+func encodeThumbnail(img image.Image, config ThumbnailConfig) ([]byte, error) {
 	// Get original dimensions
 	bounds := img.Bounds()
 	width := bounds.Dx()
@@ -72,7 +87,7 @@ func GenerateThumbnail(imagePath string, config ThumbnailConfig) ([]byte, error)
 		Quality:  float32(config.Quality),
 	}
 
-	err = webp.Encode(&buf, dst, options)
+	err := webp.Encode(&buf, dst, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode WebP thumbnail: %w", err)
 	}
@@ -0,0 +1,212 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxDiffValue is the length at which values are shortened when a diff is printed
+	maxDiffValue = 60
+	// maxLoggedDiffLines is the number of changed photos a rebuild logs
+	maxLoggedDiffLines = 20
+)
+
+// FieldChange is a field of a photo that differs between two manifests
+type FieldChange struct {
+	Field string      `json:"field"`         // JSON name, nested fields joined with dots, e.g. "exif.ISO"
+	Old   interface{} `json:"old,omitempty"` // nil when the field was added
+	New   interface{} `json:"new,omitempty"` // nil when the field was removed
+}
+
+// PhotoDiff is a photo whose entry differs between two manifests
+type PhotoDiff struct {
+	Filename string        `json:"filename"`
+	Changes  []FieldChange `json:"changes"`
+}
+
+// ManifestDiff is the difference between two manifests, keyed by filename
+type ManifestDiff struct {
+	Added     []string    `json:"added"`
+	Removed   []string    `json:"removed"`
+	Changed   []PhotoDiff `json:"changed"`
+	Reordered bool        `json:"reordered,omitempty"` // The same entries in another order or album
+}
+
+// DiffManifests compares two versions of photos.json photo by photo, after migrating both to the
+// current schema. Empty content is an empty manifest.
+func DiffManifests(oldContent, newContent []byte) (*ManifestDiff, error) {
+	oldPhotos, oldOrder, err := genericEntries(oldContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse old manifest: %w", err)
+	}
+	newPhotos, newOrder, err := genericEntries(newContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse new manifest: %w", err)
+	}
+
+	diff := &ManifestDiff{Added: []string{}, Removed: []string{}, Changed: []PhotoDiff{}}
+	for filename, entry := range newPhotos {
+		previous, ok := oldPhotos[filename]
+		if !ok {
+			diff.Added = append(diff.Added, filename)
+			continue
+		}
+		var changes []FieldChange
+		diffValues("", previous, entry, &changes)
+		if len(changes) > 0 {
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+			diff.Changed = append(diff.Changed, PhotoDiff{Filename: filename, Changes: changes})
+		}
+	}
+	for filename := range oldPhotos {
+		if _, ok := newPhotos[filename]; !ok {
+			diff.Removed = append(diff.Removed, filename)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Filename < diff.Changed[j].Filename })
+	diff.Reordered = !reflect.DeepEqual(commonOrder(oldOrder, newPhotos), commonOrder(newOrder, oldPhotos))
+	return diff, nil
+}
+
+// genericEntries returns the photos of a manifest as JSON objects by filename, and the order of
+// the filenames with their album
+func genericEntries(content []byte) (map[string]map[string]interface{}, []string, error) {
+	entries := make(map[string]map[string]interface{})
+	doc, err := migrateManifest(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	var order []string
+	albums, _ := doc["albums"].([]interface{})
+	for _, album := range albums {
+		album, _ := album.(map[string]interface{})
+		year, _ := album["year"].(string)
+		photos, _ := album["photos"].([]interface{})
+		for _, entry := range photos {
+			entry, _ := entry.(map[string]interface{})
+			filename, _ := entry["filename"].(string)
+			entries[filename] = entry
+			order = append(order, year+"/"+filename)
+		}
+	}
+	return entries, order, nil
+}
+
+// commonOrder returns the entries of order whose photo is also in other
+func commonOrder(order []string, other map[string]map[string]interface{}) []string {
+	var common []string
+	for _, entry := range order {
+		if _, filename, _ := strings.Cut(entry, "/"); other[filename] != nil {
+			common = append(common, entry)
+		}
+	}
+	return common
+}
+
+// diffValues appends the differences between two JSON values. Objects are compared field by
+// field, anything else as a whole.
+func diffValues(field string, a, b interface{}, changes *[]FieldChange) {
+	objA, okA := a.(map[string]interface{})
+	objB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			*changes = append(*changes, FieldChange{Field: field, Old: a, New: b})
+		}
+		return
+	}
+	keys := make(map[string]bool, len(objA)+len(objB))
+	for key := range objA {
+		keys[key] = true
+	}
+	for key := range objB {
+		keys[key] = true
+	}
+	for key := range keys {
+		name := key
+		if field != "" {
+			name = field + "." + key
+		}
+		diffValues(name, objA[key], objB[key], changes)
+	}
+}
+
+// IsEmpty reports whether the manifests have the same entries
+func (d *ManifestDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Summary returns a one line description of the diff
+func (d *ManifestDiff) Summary() string {
+	summary := fmt.Sprintf("%d added, %d removed, %d changed", len(d.Added), len(d.Removed), len(d.Changed))
+	if d.Reordered {
+		summary += ", reordered"
+	}
+	return summary
+}
+
+// Lines returns a line per added, removed or changed photo, at most limit lines followed by a
+// count of the rest; limit 0 returns every line
+func (d *ManifestDiff) Lines(limit int) []string {
+	var lines []string
+	for _, filename := range d.Added {
+		lines = append(lines, "+ "+filename)
+	}
+	for _, filename := range d.Removed {
+		lines = append(lines, "- "+filename)
+	}
+	for _, photo := range d.Changed {
+		parts := make([]string, len(photo.Changes))
+		for i, change := range photo.Changes {
+			parts[i] = change.String()
+		}
+		lines = append(lines, fmt.Sprintf("~ %s: %s", photo.Filename, strings.Join(parts, "; ")))
+	}
+	if limit > 0 && len(lines) > limit {
+		rest := len(lines) - limit
+		lines = append(lines[:limit], fmt.Sprintf("... and %d more", rest))
+	}
+	return lines
+}
+
+// WriteText prints every difference in a human readable form
+func (d *ManifestDiff) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "photos.json: %s\n", d.Summary())
+	for _, line := range d.Lines(0) {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+}
+
+// String describes the change, e.g. "exif.ISO 100→200"
+func (c FieldChange) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s added %s", c.Field, formatDiffValue(c.New))
+	case c.New == nil:
+		return fmt.Sprintf("%s removed", c.Field)
+	}
+	old, updated := formatDiffValue(c.Old), formatDiffValue(c.New)
+	if len(old)+len(updated) > maxDiffValue {
+		return fmt.Sprintf("%s changed", c.Field)
+	}
+	return fmt.Sprintf("%s %s→%s", c.Field, old, updated)
+}
+
+// formatDiffValue formats a JSON value for a diff line, shortening long values
+func formatDiffValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	text := string(data)
+	if len([]rune(text)) > maxDiffValue {
+		text = string([]rune(text)[:maxDiffValue-1]) + "…"
+	}
+	return text
+}
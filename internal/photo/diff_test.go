@@ -0,0 +1,115 @@
+package photo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	v2 := func(albums string) string {
+		return `{"schema_version":2,"albums":` + albums + `}`
+	}
+
+	tests := []struct {
+		name          string
+		old, new      string
+		wantAdded     []string
+		wantRemoved   []string
+		wantChanged   map[string][]string // Changed fields by filename
+		wantReordered bool
+		wantErr       bool
+	}{
+		{
+			name: "identical",
+			old:  v2(`[{"year":"2024","photos":[{"filename":"a.jpg","alt":"x"}]}]`),
+			new:  v2(`[{"year":"2024","photos":[{"filename":"a.jpg","alt":"x"}]}]`),
+		},
+		{
+			name:      "from empty",
+			old:       "",
+			new:       v2(`[{"year":"2024","photos":[{"filename":"a.jpg"},{"filename":"b.jpg"}]}]`),
+			wantAdded: []string{"a.jpg", "b.jpg"},
+		},
+		{
+			name:        "added and removed",
+			old:         v2(`[{"year":"2024","photos":[{"filename":"a.jpg"},{"filename":"b.jpg"}]}]`),
+			new:         v2(`[{"year":"2024","photos":[{"filename":"b.jpg"},{"filename":"c.jpg"}]}]`),
+			wantAdded:   []string{"c.jpg"},
+			wantRemoved: []string{"a.jpg"},
+		},
+		{
+			name: "changed fields",
+			old:  v2(`[{"year":"2024","photos":[{"filename":"a.jpg","alt":"","exif":{"ISO":100,"Model":"X"}}]}]`),
+			new:  v2(`[{"year":"2024","photos":[{"filename":"a.jpg","alt":"sea","rating":4,"exif":{"ISO":200,"Model":"X"}}]}]`),
+			wantChanged: map[string][]string{
+				"a.jpg": {"alt", "exif.ISO", "rating"},
+			},
+		},
+		{
+			name:          "reordered",
+			old:           v2(`[{"year":"2024","photos":[{"filename":"a.jpg"},{"filename":"b.jpg"}]}]`),
+			new:           v2(`[{"year":"2024","photos":[{"filename":"b.jpg"},{"filename":"a.jpg"}]}]`),
+			wantReordered: true,
+		},
+		{
+			name:          "moved to another album",
+			old:           v2(`[{"year":"2024","photos":[{"filename":"a.jpg"}]}]`),
+			new:           v2(`[{"year":"2023","photos":[{"filename":"a.jpg"}]}]`),
+			wantReordered: true,
+		},
+		{
+			name:      "added photos do not reorder",
+			old:       v2(`[{"year":"2024","photos":[{"filename":"a.jpg"}]}]`),
+			new:       v2(`[{"year":"2024","photos":[{"filename":"b.jpg"},{"filename":"a.jpg"}]}]`),
+			wantAdded: []string{"b.jpg"},
+		},
+		{
+			name: "v1 migrated before comparing",
+			old:  `[{"year":"2024","photos":[{"filename":"a.jpg","Subject":["sea"]}]}]`,
+			new:  v2(`[{"year":"2024","photos":[{"filename":"a.jpg","subject":["sea"]}]}]`),
+		},
+		{
+			name:    "newer schema",
+			old:     v2(`[]`),
+			new:     `{"schema_version":3,"albums":[]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffManifests([]byte(tt.old), []byte(tt.new))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiffManifests() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if want := orEmpty(tt.wantAdded); !reflect.DeepEqual(diff.Added, want) {
+				t.Errorf("Added = %v, want %v", diff.Added, want)
+			}
+			if want := orEmpty(tt.wantRemoved); !reflect.DeepEqual(diff.Removed, want) {
+				t.Errorf("Removed = %v, want %v", diff.Removed, want)
+			}
+			changed := make(map[string][]string)
+			for _, photo := range diff.Changed {
+				for _, change := range photo.Changes {
+					changed[photo.Filename] = append(changed[photo.Filename], change.Field)
+				}
+			}
+			if want := tt.wantChanged; !reflect.DeepEqual(changed, want) && (len(changed) > 0 || len(want) > 0) {
+				t.Errorf("Changed = %v, want %v", changed, want)
+			}
+			if diff.Reordered != tt.wantReordered {
+				t.Errorf("Reordered = %v, want %v", diff.Reordered, tt.wantReordered)
+			}
+		})
+	}
+}
+
+// orEmpty returns an empty slice for nil, as DiffManifests reports no entries
+func orEmpty(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
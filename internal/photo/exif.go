@@ -1,6 +1,7 @@
 package photo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -17,7 +18,7 @@ import (
 type ExifExtractor interface {
 	// Extract 从图片文件中提取 EXIF 数据
 	// 返回: EXIF 数据映射, 宽度, 高度, 拍摄时间, 错误
-	Extract(filePath string) (map[string]interface{}, int, int, time.Time, error)
+	Extract(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error)
 }
 
 // ExifExtractorType 定义提取器类型
@@ -37,7 +38,10 @@ var (
 type GoExifExtractor struct{}
 
 // Extract 实现 ExifExtractor 接口
-func (e *GoExifExtractor) Extract(filePath string) (map[string]interface{}, int, int, time.Time, error) {
+func (e *GoExifExtractor) Extract(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, time.Time{}, err
+	}
 	return extractExifNative(filePath)
 }
 
@@ -45,8 +49,8 @@ func (e *GoExifExtractor) Extract(filePath string) (map[string]interface{}, int,
 type ExifToolExtractor struct{}
 
 // Extract 实现 ExifExtractor 接口
-func (e *ExifToolExtractor) Extract(filePath string) (map[string]interface{}, int, int, time.Time, error) {
-	return extractExifWithTool(filePath)
+func (e *ExifToolExtractor) Extract(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error) {
+	return extractExifWithTool(ctx, filePath)
 }
 
 // GetExifExtractor 根据配置返回对应的提取器
@@ -147,16 +151,16 @@ func extractExifNative(filePath string) (map[string]interface{}, int, int, time.
 		}
 	}
 
-	// Normalize EXIF data
-	exifData = normalizeExif(exifData)
+	// Normalize EXIF data and keep the fields the policy extracts
+	exifData = activeFieldPolicy.FilterExtracted(normalizeExif(exifData))
 
 	return exifData, width, height, dateTaken, nil
 }
 
 // extractExifWithTool uses exiftool command to extract EXIF data
-func extractExifWithTool(filePath string) (map[string]interface{}, int, int, time.Time, error) {
+func extractExifWithTool(ctx context.Context, filePath string) (map[string]interface{}, int, int, time.Time, error) {
 	// 执行 exiftool -json 命令
-	cmd := exec.Command("exiftool", "-json", "-charset", "utf8", filePath)
+	cmd := exec.CommandContext(ctx, "exiftool", "-json", "-charset", "utf8", filePath)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, 0, 0, time.Time{}, fmt.Errorf("exiftool command failed: %w", err)
@@ -192,46 +196,9 @@ func extractExifWithTool(filePath string) (map[string]interface{}, int, int, tim
 		dateTaken, _ = time.Parse("2006:01:02 15:04:05", dateStr)
 	}
 
-	// 定义需要保留的字段白名单
-	allowedFields := map[string]bool{
-		"Aperture":                true,
-		"CreateDate":              true,
-		"DateTimeOriginal":        true,
-		"ExposureMode":            true,
-		"ExposureProgram":         true,
-		"ExposureTime":            true,
-		"FNumber":                 true,
-		"Flash":                   true,
-		"FocalLength":             true,
-		"FocalLengthIn35mmFormat": true,
-		"ISO":                     true,
-		"Keywords":                true,
-		"Lens":                    true,
-		"LensModel":               true,
-		"Make":                    true,
-		"MeteringMode":            true,
-		"Model":                   true,
-		"OffsetTime":              true,
-		"OffsetTimeOriginal":      true,
-		"Rating":                  true,
-		"SceneCaptureType":        true,
-		"ShutterSpeed":            true,
-		"Subject":                 true,
-		"WhiteBalance":            true,
-		"GPSAltitude":             true,
-		"GPSLatitude":             true,
-		"GPSLatitudeRef":          true,
-		"GPSLongitude":            true,
-		"GPSLongitudeRef":         true,
-	}
-
-	// 过滤字段,只保留白名单中的字段
-	filteredExifData := make(map[string]interface{})
-	for key, value := range rawExifData {
-		if allowedFields[key] {
-			filteredExifData[key] = value
-		}
-	}
+	// 只保留字段策略中需要提取的字段 (config/exif_fields.json)
+	filteredExifData := activeFieldPolicy.FilterExtracted(rawExifData)
+	decodeExifEnums(filteredExifData)
 
 	return filteredExifData, width, height, dateTaken, nil
 }
@@ -268,16 +235,6 @@ func normalizeExif(raw map[string]interface{}) map[string]interface{} {
 		return ""
 	}
 
-	// Helper to get int value from "[n]" string
-	getInt := func(key string) (int, bool) {
-		s := getString(key)
-		s = strings.Trim(s, "[]")
-		if i, err := strconv.Atoi(s); err == nil {
-			return i, true
-		}
-		return 0, false
-	}
-
 	// 1. Aperture & FNumber
 	if val := getString("FNumber"); val != "" {
 		if f, err := parseRational(val); err == nil {
@@ -342,133 +299,57 @@ func normalizeExif(raw map[string]interface{}) map[string]interface{} {
 		normalized["CreateDate"] = val
 	}
 
+	// Time zone offsets, used to resolve the capture instant
+	if val := getString("OffsetTimeOriginal"); val != "" {
+		normalized["OffsetTimeOriginal"] = val
+	}
+	if val := getString("OffsetTime"); val != "" {
+		normalized["OffsetTime"] = val
+	}
+
 	// 8. GPS
+	if val := getString("GPSDateStamp"); val != "" {
+		normalized["GPSDateStamp"] = val
+	}
+	if val := getString("GPSTimeStamp"); val != "" {
+		if formatted, err := formatGPSTime(val); err == nil {
+			normalized["GPSTimeStamp"] = formatted
+		}
+	}
 	if lat := getString("GPSLatitude"); lat != "" {
 		ref := getString("GPSLatitudeRef")
 		if formatted, err := formatGPS(lat, ref); err == nil {
 			normalized["GPSLatitude"] = formatted
 			// Legacy format included Ref in the string: "30 deg 33' 44.70\" N"
-			// And also had separate Ref field
-			normalized["GPSLatitudeRef"] = mapGPSRef(ref)
+			// And also had separate Ref field with the full name: "North"
+			normalized["GPSLatitudeRef"] = gpsRefName(ref)
 		}
 	}
 	if lon := getString("GPSLongitude"); lon != "" {
 		ref := getString("GPSLongitudeRef")
 		if formatted, err := formatGPS(lon, ref); err == nil {
 			normalized["GPSLongitude"] = formatted
-			normalized["GPSLongitudeRef"] = mapGPSRef(ref)
+			normalized["GPSLongitudeRef"] = gpsRefName(ref)
 		}
 	}
 	if alt := getString("GPSAltitude"); alt != "" {
 		ref := getString("GPSAltitudeRef") // 0 = Above Sea Level, 1 = Below
 		if val, err := parseRational(alt); err == nil {
 			suffix := "Above Sea Level"
-			if ref == "1" || ref == "01" { // Check raw value
+			if strings.Trim(ref, "[]") == "1" || ref == "01" { // Check raw value
 				suffix = "Below Sea Level"
 			}
 			normalized["GPSAltitude"] = fmt.Sprintf("%.1f m %s", val, suffix)
 		}
 	}
 
-	// 9. Enums mappings
-	if i, ok := getInt("ExposureMode"); ok {
-		// 0: Auto, 1: Manual, 2: Auto Bracket
-		switch i {
-		case 0:
-			normalized["ExposureMode"] = "Auto"
-		case 1:
-			normalized["ExposureMode"] = "Manual"
-		case 2:
-			normalized["ExposureMode"] = "Auto Bracket"
-		default:
-			normalized["ExposureMode"] = getString("ExposureMode")
-		}
-	}
-
-	if i, ok := getInt("ExposureProgram"); ok {
-		// 0: Not Defined, 1: Manual, 2: Normal Program, 3: Aperture-priority AE, 4: Shutter speed priority AE
-		// 5: Creative (Slow speed), 6: Action (High speed), 7: Portrait, 8: Landscape
-		switch i {
-		case 1:
-			normalized["ExposureProgram"] = "Manual"
-		case 2:
-			normalized["ExposureProgram"] = "Normal program"
-		case 3:
-			normalized["ExposureProgram"] = "Aperture-priority AE"
-		case 4:
-			normalized["ExposureProgram"] = "Shutter speed priority AE"
-		case 5:
-			normalized["ExposureProgram"] = "Creative program"
-		case 6:
-			normalized["ExposureProgram"] = "Action program"
-		case 7:
-			normalized["ExposureProgram"] = "Portrait mode"
-		case 8:
-			normalized["ExposureProgram"] = "Landscape mode"
-		default:
-			normalized["ExposureProgram"] = getString("ExposureProgram")
-		}
-	}
-
-	if i, ok := getInt("MeteringMode"); ok {
-		// 0: Unknown, 1: Average, 2: Center-weighted average, 3: Spot, 4: Multi-spot, 5: Multi-segment (Pattern), 6: Partial
-		switch i {
-		case 1:
-			normalized["MeteringMode"] = "Average"
-		case 2:
-			normalized["MeteringMode"] = "Center-weighted average"
-		case 3:
-			normalized["MeteringMode"] = "Spot"
-		case 4:
-			normalized["MeteringMode"] = "Multi-spot"
-		case 5:
-			normalized["MeteringMode"] = "Multi-segment"
-		case 6:
-			normalized["MeteringMode"] = "Partial"
-		default:
-			normalized["MeteringMode"] = getString("MeteringMode")
-		}
-	}
-
-	if i, ok := getInt("Flash"); ok {
-		// Bitmask. 0 = No Flash.
-		if i == 0 {
-			normalized["Flash"] = "No Flash"
-		} else {
-			// Simplified mapping for common cases
-			if i&1 == 1 {
-				normalized["Flash"] = "Fired"
-			} else {
-				normalized["Flash"] = "Off, Did not fire"
-			}
-		}
-	}
-
-	if i, ok := getInt("WhiteBalance"); ok {
-		// 0: Auto, 1: Manual
-		switch i {
-		case 0:
-			normalized["WhiteBalance"] = "Auto"
-		case 1:
-			normalized["WhiteBalance"] = "Manual"
-		default:
-			normalized["WhiteBalance"] = getString("WhiteBalance")
+	// 9. Enums mappings, see exif_enums.go
+	for tag, value := range raw {
+		if !isExifEnum(tag) {
+			continue
 		}
-	}
-
-	if i, ok := getInt("SceneCaptureType"); ok {
-		// 0: Standard, 1: Landscape, 2: Portrait, 3: Night Scene
-		switch i {
-		case 0:
-			normalized["SceneCaptureType"] = "Standard"
-		case 1:
-			normalized["SceneCaptureType"] = "Landscape"
-		case 2:
-			normalized["SceneCaptureType"] = "Portrait"
-		case 3:
-			normalized["SceneCaptureType"] = "Night Scene"
-		default:
-			normalized["SceneCaptureType"] = getString("SceneCaptureType")
+		if i, ok := exifEnumValue(value); ok {
+			normalized[tag] = exifEnumName(tag, i)
 		}
 	}
 
@@ -483,6 +364,21 @@ func normalizeExif(raw map[string]interface{}) map[string]interface{} {
 		normalized["Copyright"] = val
 	}
 
+	if val := getString("ImageDescription"); val != "" {
+		normalized["ImageDescription"] = val
+	}
+
+	// Serial numbers and owner, named like exiftool. Private unless the policy publishes them.
+	if val := getString("BodySerialNumber"); val != "" {
+		normalized["SerialNumber"] = val
+	}
+	if val := getString("LensSerialNumber"); val != "" {
+		normalized["LensSerialNumber"] = val
+	}
+	if val := getString("CameraOwnerName"); val != "" {
+		normalized["OwnerName"] = val
+	}
+
 	// Windows XP Tags Mapping
 	if val := getString("XPKeywords"); val != "" {
 		normalized["Keywords"] = val
@@ -557,29 +453,45 @@ func formatGPS(raw, ref string) (string, error) {
 		return "", fmt.Errorf("error parsing gps components")
 	}
 
-	ref = mapGPSRef(ref)
-
-	return fmt.Sprintf("%.0f deg %.0f' %.2f\" %s", deg, min, sec, ref), nil
+	return fmt.Sprintf("%.0f deg %.0f' %.2f\" %s", deg, min, sec, gpsRefLetter(ref)), nil
 }
 
-func mapGPSRef(ref string) string {
-	switch ref {
-	case "N", "S", "E", "W":
-		return ref // Already correct
-	case "0":
-		return "North" // Or undefined? Usually N/S/E/W in EXIF
-	case "1":
-		return "South"
-		// Add more if needed based on raw values
+// formatGPSTime formats a GPS time stamp to "13:05:34"
+func formatGPSTime(raw string) (string, error) {
+	// Raw: "[13/1 5/1 3400/100]"
+	parts := strings.Fields(strings.Trim(raw, "[]"))
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid gps time format")
+	}
+
+	h, err1 := parseRational(parts[0])
+	m, err2 := parseRational(parts[1])
+	sec, err3 := parseRational(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", fmt.Errorf("error parsing gps time components")
 	}
-	// Sometimes ref is "N" or "S" directly
-	if ref == "North" || ref == "South" || ref == "East" || ref == "West" {
-		return ref
+
+	return fmt.Sprintf("%02d:%02d:%02d", int(h), int(m), int(sec)), nil
+}
+
+// gpsRefLetter maps a GPS reference to its single letter form ("N", "S", "E", "W")
+func gpsRefLetter(ref string) string {
+	switch strings.TrimSpace(ref) {
+	case "N", "North":
+		return "N"
+	case "S", "South":
+		return "S"
+	case "E", "East":
+		return "E"
+	case "W", "West":
+		return "W"
 	}
-	// Map single letters to full names if legacy requires full names?
-	// Legacy example: "GPSLatitudeRef": "North"
-	// Legacy example: "GPSLatitude": "30 deg ... N" (Wait, legacy example has "N" in string, but "North" in Ref field)
-	switch ref {
+	return ref
+}
+
+// gpsRefName maps a GPS reference to the full name exiftool uses ("North", "South", "East", "West")
+func gpsRefName(ref string) string {
+	switch gpsRefLetter(ref) {
 	case "N":
 		return "North"
 	case "S":
@@ -0,0 +1,255 @@
+package photo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exifEnums are the EXIF 2.32 enumerations, worded like exiftool so both extractors
+// produce the same values in photos.json
+var exifEnums = map[string]map[int]string{
+	"ExposureProgram": {
+		0: "Not Defined",
+		1: "Manual",
+		2: "Program AE",
+		3: "Aperture-priority AE",
+		4: "Shutter speed priority AE",
+		5: "Creative (Slow speed)",
+		6: "Action (High speed)",
+		7: "Portrait",
+		8: "Landscape",
+		9: "Bulb",
+	},
+	"MeteringMode": {
+		0:   "Unknown",
+		1:   "Average",
+		2:   "Center-weighted average",
+		3:   "Spot",
+		4:   "Multi-spot",
+		5:   "Multi-segment",
+		6:   "Partial",
+		255: "Other",
+	},
+	"LightSource": {
+		0:   "Unknown",
+		1:   "Daylight",
+		2:   "Fluorescent",
+		3:   "Tungsten (Incandescent)",
+		4:   "Flash",
+		9:   "Fine Weather",
+		10:  "Cloudy",
+		11:  "Shade",
+		12:  "Daylight Fluorescent",
+		13:  "Day White Fluorescent",
+		14:  "Cool White Fluorescent",
+		15:  "White Fluorescent",
+		16:  "Warm White Fluorescent",
+		17:  "Standard Light A",
+		18:  "Standard Light B",
+		19:  "Standard Light C",
+		20:  "D55",
+		21:  "D65",
+		22:  "D75",
+		23:  "D50",
+		24:  "ISO Studio Tungsten",
+		255: "Other",
+	},
+	"SensingMethod": {
+		1: "Not defined",
+		2: "One-chip color area",
+		3: "Two-chip color area",
+		4: "Three-chip color area",
+		5: "Color sequential area",
+		7: "Trilinear",
+		8: "Color sequential linear",
+	},
+	"CustomRendered": {
+		0: "Normal",
+		1: "Custom",
+		// Apple extensions
+		2: "HDR (no original saved)",
+		3: "HDR (original saved)",
+		4: "Original (for HDR)",
+		6: "Panorama",
+		7: "Portrait HDR",
+		8: "Portrait",
+	},
+	"ExposureMode": {
+		0: "Auto",
+		1: "Manual",
+		2: "Auto bracket",
+	},
+	"WhiteBalance": {
+		0: "Auto",
+		1: "Manual",
+	},
+	"SceneCaptureType": {
+		0: "Standard",
+		1: "Landscape",
+		2: "Portrait",
+		3: "Night",
+		4: "Other",
+	},
+	"GainControl": {
+		0: "None",
+		1: "Low gain up",
+		2: "High gain up",
+		3: "Low gain down",
+		4: "High gain down",
+	},
+	"Contrast": {
+		0: "Normal",
+		1: "Low",
+		2: "High",
+	},
+	"Saturation": {
+		0: "Normal",
+		1: "Low",
+		2: "High",
+	},
+	"Sharpness": {
+		0: "Normal",
+		1: "Soft",
+		2: "Hard",
+	},
+	"SubjectDistanceRange": {
+		0: "Unknown",
+		1: "Macro",
+		2: "Close",
+		3: "Distant",
+	},
+}
+
+// flashNames are exiftool's names for the Flash values cameras actually write
+var flashNames = map[int]string{
+	0x00: "No Flash",
+	0x01: "Fired",
+	0x05: "Fired, Return not detected",
+	0x07: "Fired, Return detected",
+	0x08: "On, Did not fire",
+	0x09: "On, Fired",
+	0x0d: "On, Return not detected",
+	0x0f: "On, Return detected",
+	0x10: "Off, Did not fire",
+	0x14: "Off, Did not fire, Return not detected",
+	0x18: "Auto, Did not fire",
+	0x19: "Auto, Fired",
+	0x1d: "Auto, Fired, Return not detected",
+	0x1f: "Auto, Fired, Return detected",
+	0x20: "No flash function",
+	0x30: "Off, No flash function",
+	0x41: "Fired, Red-eye reduction",
+	0x45: "Fired, Red-eye reduction, Return not detected",
+	0x47: "Fired, Red-eye reduction, Return detected",
+	0x49: "On, Red-eye reduction",
+	0x4d: "On, Red-eye reduction, Return not detected",
+	0x4f: "On, Red-eye reduction, Return detected",
+	0x50: "Off, Red-eye reduction",
+	0x58: "Auto, Did not fire, Red-eye reduction",
+	0x59: "Auto, Fired, Red-eye reduction",
+	0x5d: "Auto, Fired, Red-eye reduction, Return not detected",
+	0x5f: "Auto, Fired, Red-eye reduction, Return detected",
+}
+
+// flashInfo is the decoded Flash bitfield
+type flashInfo struct {
+	Fired      bool   // Bit 0
+	Return     string // Bits 1-2: "", "Return not detected", "Return detected"
+	Mode       string // Bits 3-4: "", "On", "Off", "Auto"
+	NoFunction bool   // Bit 5
+	RedEye     bool   // Bit 6
+}
+
+// decodeFlash splits the Flash tag into its fields
+func decodeFlash(value int) flashInfo {
+	info := flashInfo{
+		Fired:      value&0x01 != 0,
+		NoFunction: value&0x20 != 0,
+		RedEye:     value&0x40 != 0,
+	}
+	switch (value >> 1) & 0x03 {
+	case 2:
+		info.Return = "Return not detected"
+	case 3:
+		info.Return = "Return detected"
+	}
+	switch (value >> 3) & 0x03 {
+	case 1:
+		info.Mode = "On"
+	case 2:
+		info.Mode = "Off"
+	case 3:
+		info.Mode = "Auto"
+	}
+	return info
+}
+
+// String formats the fields for values missing from flashNames
+func (f flashInfo) String() string {
+	var parts []string
+	if f.Mode != "" {
+		parts = append(parts, f.Mode)
+	}
+	if f.NoFunction {
+		parts = append(parts, "No flash function")
+	} else if f.Fired {
+		parts = append(parts, "Fired")
+	} else {
+		parts = append(parts, "Did not fire")
+	}
+	if f.RedEye {
+		parts = append(parts, "Red-eye reduction")
+	}
+	if f.Return != "" {
+		parts = append(parts, f.Return)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exifEnumName returns the name of an enumerated tag value, "Unknown (n)" if it has none
+func exifEnumName(tag string, value int) string {
+	if tag == "Flash" {
+		if name, ok := flashNames[value]; ok {
+			return name
+		}
+		return decodeFlash(value).String()
+	}
+	if name, ok := exifEnums[tag][value]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%d)", value)
+}
+
+// isExifEnum reports whether a tag is decoded by exifEnumName
+func isExifEnum(tag string) bool {
+	_, ok := exifEnums[tag]
+	return ok || tag == "Flash"
+}
+
+// exifEnumValue reads a raw enumeration value: a JSON number, an int or go-exif's "[n]"
+func exifEnumValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), float64(int(v)) == v
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(strings.Trim(v, "[]")))
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// decodeExifEnums replaces numeric enumeration values with their names, in place.
+// Values already decoded by exiftool are left as they are.
+func decodeExifEnums(exifData map[string]interface{}) {
+	for tag, value := range exifData {
+		if !isExifEnum(tag) {
+			continue
+		}
+		if i, ok := exifEnumValue(value); ok {
+			exifData[tag] = exifEnumName(tag, i)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// FieldPolicyFile is the default location of the EXIF field policy
+const FieldPolicyFile = "config/exif_fields.json"
+
+// FieldWildcard in a field list matches every extracted field
+const FieldWildcard = "*"
+
+// defaultPublicFields are the fields published in photos.json when there is no policy file
+var defaultPublicFields = []string{
+	"Aperture", "Caption-Abstract", "Contrast", "CreateDate", "CustomRendered", "DateTimeOriginal",
+	"Description", "ExposureMode", "ExposureProgram", "ExposureTime", "FNumber", "Flash", "FocalLength",
+	"FocalLengthIn35mmFormat", "GainControl", "ImageDescription", "ISO", "Keywords", "Lens", "LensID",
+	"LensModel", "LightSource", "Make", "MeteringMode", "Model", "ObjectName", "OffsetTime",
+	"OffsetTimeOriginal", "Rating", "Saturation", "SceneCaptureType", "SensingMethod", "Sharpness",
+	"ShutterSpeed", "Subject", "SubjectDistanceRange", "Title", "WhiteBalance", "GPSAltitude",
+	"GPSDateStamp", "GPSDateTime", "GPSTimeStamp", "GPSLatitude", "GPSLatitudeRef", "GPSLongitude",
+	"GPSLongitudeRef",
+}
+
+// Sources of the photo fields computed from EXIF, see FieldPolicy.HideDerived
+var (
+	coordinateSources = []string{"GPSLatitude", "GPSLatitudeRef", "GPSLongitude", "GPSLongitudeRef"}
+	altitudeSources   = []string{"GPSAltitude"}
+	clockSources      = []string{"DateTimeOriginal", "CreateDate"}
+	gpsClockSources   = []string{"GPSDateTime", "GPSDateStamp", "GPSTimeStamp"}
+
+	// derivedFields are the JSON names of the computed fields, which may be listed as public
+	derivedFields = map[string]bool{
+		"latitude": true, "longitude": true, "altitude": true, "location": true, "local_time": true, "utc_time": true,
+	}
+)
+
+// FieldPolicy decides which EXIF fields are extracted, which are kept in the private
+// metadata store and which are published in photos.json
+type FieldPolicy struct {
+	Extract []string `json:"extract"`           // Fields read from the image, by either extractor
+	Private []string `json:"private,omitempty"` // Fields kept in the metadata store, "*" for all extracted
+	Public  []string `json:"public"`            // Fields published in photos.json, a subset of extract and derived fields
+
+	extract, private, public map[string]bool
+}
+
+// activeFieldPolicy is the policy used by the extractors
+var activeFieldPolicy = DefaultFieldPolicy()
+
+// DefaultFieldPolicy extracts and publishes the historical whitelist and keeps everything extracted
+func DefaultFieldPolicy() *FieldPolicy {
+	policy := &FieldPolicy{
+		Extract: defaultPublicFields,
+		Private: []string{FieldWildcard},
+		Public:  defaultPublicFields,
+	}
+	policy.index()
+	return policy
+}
+
+// SetFieldPolicy sets the policy used by the extractors, nil restores the default
+func SetFieldPolicy(policy *FieldPolicy) {
+	if policy == nil {
+		policy = DefaultFieldPolicy()
+	}
+	activeFieldPolicy = policy
+}
+
+// ActiveFieldPolicy returns the policy used by the extractors
+func ActiveFieldPolicy() *FieldPolicy {
+	return activeFieldPolicy
+}
+
+// LoadFieldPolicy loads the policy from PHOTO_EXIF_FIELDS or config/exif_fields.json.
+// A missing file results in the default policy.
+func LoadFieldPolicy(rootDir string) (*FieldPolicy, error) {
+	path := os.Getenv("PHOTO_EXIF_FIELDS")
+	if path == "" {
+		path = FieldPolicyFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DefaultFieldPolicy(), nil
+		}
+		return DefaultFieldPolicy(), fmt.Errorf("failed to read field policy: %w", err)
+	}
+
+	policy := &FieldPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return DefaultFieldPolicy(), fmt.Errorf("failed to parse field policy %s: %w", path, err)
+	}
+	if len(policy.Extract) == 0 {
+		return DefaultFieldPolicy(), fmt.Errorf("field policy %s extracts no fields", path)
+	}
+	policy.index()
+
+	for _, field := range policy.Public {
+		if field != FieldWildcard && !derivedFields[field] && !policy.extract[field] {
+			log.Printf("⚠ Warning: public field %s is not extracted, ignoring it\n", field)
+		}
+	}
+	log.Printf("✓ Loaded EXIF field policy from: %s\n", path)
+	return policy, nil
+}
+
+func (f *FieldPolicy) index() {
+	toSet := func(fields []string) map[string]bool {
+		set := make(map[string]bool, len(fields))
+		for _, field := range fields {
+			set[field] = true
+		}
+		return set
+	}
+	f.extract, f.private, f.public = toSet(f.Extract), toSet(f.Private), toSet(f.Public)
+}
+
+// Extracts reports whether a field is read from images
+func (f *FieldPolicy) Extracts(field string) bool {
+	return f.extract[FieldWildcard] || f.extract[field]
+}
+
+// FilterExtracted returns the extracted fields of exifData
+func (f *FieldPolicy) FilterExtracted(exifData map[string]interface{}) map[string]interface{} {
+	return f.filter(exifData, f.extract)
+}
+
+// PublicFields returns the fields of exifData published in photos.json
+func (f *FieldPolicy) PublicFields(exifData map[string]interface{}) map[string]interface{} {
+	return f.filter(exifData, f.public)
+}
+
+// PrivateFields returns the fields of exifData kept in the metadata store
+func (f *FieldPolicy) PrivateFields(exifData map[string]interface{}) map[string]interface{} {
+	return f.filter(exifData, f.private)
+}
+
+// filter keeps the extracted fields of exifData that are in set
+func (f *FieldPolicy) filter(exifData map[string]interface{}, set map[string]bool) map[string]interface{} {
+	if exifData == nil {
+		return nil
+	}
+	filtered := make(map[string]interface{})
+	for key, value := range exifData {
+		if f.Extracts(key) && (set[FieldWildcard] || set[key]) {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// HideDerived clears the fields of a photo computed from EXIF fields that are not public, so the
+// position or capture time does not leak through them; they are computed again from the metadata
+// store on every rebuild. A derived field named in the public list by its JSON name is published
+// regardless, e.g. "location" for the place without the coordinates.
+func (f *FieldPolicy) HideDerived(photo *Photo) {
+	coordinates := f.publishesAll(coordinateSources)
+	if !coordinates && !f.public["latitude"] {
+		photo.Latitude = nil
+	}
+	if !coordinates && !f.public["longitude"] {
+		photo.Longitude = nil
+	}
+	if !f.publishesAll(altitudeSources) && !f.public["altitude"] {
+		photo.Altitude = nil
+	}
+	if !coordinates && !f.public["location"] {
+		photo.Location = nil
+	}
+
+	// The offset of a capture time resolved from the GPS clock tells where the photo was taken
+	clock := f.publishesAll(clockSources) && (photo.TimeSource != TimeSourceGPS || f.publishesAll(gpsClockSources))
+	if !clock && !f.public["local_time"] {
+		photo.LocalTime = ""
+	}
+	if !clock && !f.public["utc_time"] {
+		photo.UTCTime = ""
+	}
+	if photo.LocalTime == "" && photo.UTCTime == "" {
+		photo.TimeSource = ""
+	}
+}
+
+// publishesAll reports whether every one of fields is published
+func (f *FieldPolicy) publishesAll(fields []string) bool {
+	if f.public[FieldWildcard] {
+		return true
+	}
+	for _, field := range fields {
+		if !f.public[field] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package photo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+// RawObjectDir is the directory RAW archives are kept in under the private prefix
+const RawObjectDir = "raw/"
+
+// displayExtensions are the formats served to the gallery as they are
+var displayExtensions = map[string]bool{
+	ExtJPG:  true,
+	ExtJPEG: true,
+	ExtPNG:  true,
+	ExtWebP: true,
+}
+
+// rawExtensions are the camera RAW formats, served through their embedded JPEG preview
+var rawExtensions = map[string]bool{
+	".arw": true, // Sony
+	".cr2": true, // Canon
+	".cr3": true, // Canon
+	".dng": true, // Adobe / Leica / phones
+	".nef": true, // Nikon
+	".nrw": true, // Nikon compacts
+	".orf": true, // Olympus / OM System
+	".pef": true, // Pentax
+	".raf": true, // Fujifilm
+	".raw": true, // Panasonic / Leica
+	".rw2": true, // Panasonic
+	".srw": true, // Samsung
+}
+
+// heicExtensions are the HEIF formats, decoded with an external converter
+var heicExtensions = map[string]bool{
+	".heic": true,
+	".heif": true,
+}
+
+// IsSupportedImage reports whether a file is ingested by the photo processor
+func IsSupportedImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return displayExtensions[ext] || rawExtensions[ext] || heicExtensions[ext]
+}
+
+// IsHEICFile reports whether a file is a HEIC/HEIF image
+func IsHEICFile(name string) bool {
+	return heicExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// ServeHEICOriginal reports whether HEIC photos are published as HEIC (PHOTO_HEIC_ORIGINAL=heic)
+// instead of a converted JPEG, which is the default since few browsers can display HEIC.
+func ServeHEICOriginal() bool {
+	return strings.EqualFold(os.Getenv("PHOTO_HEIC_ORIGINAL"), "heic")
+}
+
+// servedAsJPEG reports whether the public original of a photo is a JPEG converted from its source.
+// Processed photos are judged by their published path, so changing the config does not lose track
+// of objects uploaded under the previous setting.
+func servedAsJPEG(photo Photo) bool {
+	switch {
+	case IsRawFile(photo.Filename):
+		return true
+	case !IsHEICFile(photo.Filename):
+		return false
+	case photo.Path != "":
+		return strings.EqualFold(filepath.Ext(photo.Path), ExtJPG)
+	default:
+		return !ServeHEICOriginal()
+	}
+}
+
+// IsRawFile reports whether a file is a camera RAW file
+func IsRawFile(name string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// RawPairingEnabled reports whether RAW+JPEG files with the same basename are merged into
+// one photo (PHOTO_RAW_PAIRING=true). When disabled they are published as separate photos.
+func RawPairingEnabled() bool {
+	switch strings.ToLower(os.Getenv("PHOTO_RAW_PAIRING")) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// pairKey identifies the files of a RAW+JPEG pair: directory and case-insensitive basename
+func pairKey(path string) string {
+	return strings.ToLower(strings.TrimSuffix(path, filepath.Ext(path)))
+}
+
+// displayBase returns the basename of the derived objects of a photo.
+// RAW and HEIC files keep their extension in it ("DSC_0001_nef"), so they never
+// collide with a JPEG of the same name.
+func displayBase(filename string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	if IsRawFile(filename) || IsHEICFile(filename) {
+		return base + "_" + strings.ToLower(strings.TrimPrefix(ext, "."))
+	}
+	return base
+}
+
+// ObjectKeys are the R2 object keys belonging to a photo
+type ObjectKeys struct {
+	Original  string // Display image
+	Thumbnail string // WebP thumbnail
+	Raw       string // Private RAW archive, empty if the photo has no RAW
+}
+
+// KeysFor returns the R2 object keys of a photo. Hidden photos are kept under the private
+// prefix, whose objects are stored in the private bucket without a public domain.
+func KeysFor(cfg storage.R2Config, photo Photo) ObjectKeys {
+	base := cfg.BasePrefix
+	if photo.IsHidden {
+		base = cfg.PrivatePrefix + cfg.BasePrefix
+	}
+	keys := ObjectKeys{
+		Thumbnail: base + cfg.ThumbnailPrefix + displayBase(photo.Filename) + ExtWebP,
+	}
+	if servedAsJPEG(photo) {
+		keys.Original = base + cfg.OriginalPrefix + displayBase(photo.Filename) + ExtJPG
+	} else {
+		keys.Original = base + cfg.OriginalPrefix + photo.Filename
+	}
+	if photo.Raw != "" {
+		keys.Raw = cfg.PrivatePrefix + cfg.BasePrefix + RawObjectDir + photo.Raw
+	}
+	return keys
+}
+
+// All returns every non-empty key
+func (k ObjectKeys) All() []string {
+	var keys []string
+	for _, key := range []string{k.Original, k.Thumbnail, k.Raw} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,272 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GearRegistryFile is the default location of the camera and lens name registry
+const GearRegistryFile = "config/gear.json"
+
+// ManualLens assigns a lens to photos whose EXIF has no usable lens information,
+// e.g. adapted manual lenses without electronic contacts.
+type ManualLens struct {
+	Name        string   `json:"name"`                   // Canonical display name
+	Files       []string `json:"files,omitempty"`        // Exact filenames
+	Cameras     []string `json:"cameras,omitempty"`      // Raw or canonical camera names
+	Start       string   `json:"start,omitempty"`        // YYYY-MM-DD, inclusive
+	End         string   `json:"end,omitempty"`          // YYYY-MM-DD, inclusive
+	FocalLength string   `json:"focal_length,omitempty"` // Optional, e.g. "58 mm", for prime lenses on the same body
+}
+
+// GearRegistry maps raw maker/model/lens strings to canonical display names
+type GearRegistry struct {
+	Makers  map[string]string `json:"makers,omitempty"`   // Raw Make -> display maker
+	Cameras map[string]string `json:"cameras,omitempty"`  // Raw Model or "Make Model" -> display camera
+	Lenses  map[string]string `json:"lenses,omitempty"`   // Raw LensModel / Lens -> display lens
+	LensIDs map[string]string `json:"lens_ids,omitempty"` // Raw LensID -> display lens
+	Manual  []ManualLens      `json:"manual_lenses,omitempty"`
+}
+
+// gearPlaceholderLenses are values bodies write when no lens information is available
+var gearPlaceholderLenses = map[string]bool{
+	"":             true,
+	"----":         true,
+	"unknown":      true,
+	"unknown (0)":  true,
+	"0.0 mm f/0.0": true,
+	"0mm f/0":      true,
+	"manual lens":  true,
+	"non-cpu lens": true,
+}
+
+// GearRegistryPath returns the registry path from PHOTO_GEAR_REGISTRY or the default file
+func GearRegistryPath(rootDir string) string {
+	path := os.Getenv("PHOTO_GEAR_REGISTRY")
+	if path == "" {
+		path = GearRegistryFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+	return path
+}
+
+// LoadGearRegistry loads the registry. A missing file results in an empty registry.
+func LoadGearRegistry(path string) (*GearRegistry, error) {
+	registry := &GearRegistry{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return registry, nil
+		}
+		return registry, fmt.Errorf("failed to read gear registry: %w", err)
+	}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return &GearRegistry{}, fmt.Errorf("failed to parse gear registry %s: %w", path, err)
+	}
+	log.Printf("✓ Loaded gear registry from: %s\n", path)
+	return registry, nil
+}
+
+// Save writes the registry to path, atomically so a crash never leaves it truncated
+func (g *GearRegistry) Save(path string) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal gear registry: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create gear registry directory: %w", err)
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// Apply sets the canonical Camera and Lens names of a photo from its raw EXIF values
+func (g *GearRegistry) Apply(photo *Photo) {
+	if g == nil {
+		return
+	}
+
+	rawMake := exifString(photo.Exif, "Make")
+	rawModel := exifString(photo.Exif, "Model")
+
+	// Camera: an explicit body mapping wins, otherwise build "Maker Model" from the maker mapping
+	photo.Camera = ""
+	if rawModel != "" {
+		if name, ok := lookupName(g.Cameras, rawMake+" "+rawModel, rawModel); ok {
+			photo.Camera = name
+		} else {
+			maker := rawMake
+			if name, ok := lookupName(g.Makers, rawMake); ok {
+				maker = name
+			}
+			photo.Camera = joinMakerModel(maker, rawModel)
+		}
+	}
+
+	// Lens: LensID is the most specific, then the model strings
+	photo.Lens = ""
+	rawLensID := exifString(photo.Exif, "LensID")
+	rawLens := exifString(photo.Exif, "LensModel")
+	if rawLens == "" {
+		rawLens = exifString(photo.Exif, "Lens")
+	}
+	if name, ok := lookupName(g.LensIDs, rawLensID); ok {
+		photo.Lens = name
+	} else if name, ok := lookupName(g.Lenses, rawLens, rawLensID); ok {
+		photo.Lens = name
+	} else if !isPlaceholderLens(rawLens) {
+		photo.Lens = rawLens
+	} else if !isPlaceholderLens(rawLensID) {
+		photo.Lens = rawLensID
+	}
+
+	// Manual assignments, for unchipped glass or explicit per-file overrides
+	if manual, ok := g.matchManual(photo, rawMake, rawModel); ok {
+		if photo.Lens == "" || containsFold(manual.Files, photo.Filename) {
+			photo.Lens = manual.Name
+		}
+	}
+}
+
+// matchManual returns the first manual lens rule that matches the photo
+func (g *GearRegistry) matchManual(photo *Photo, rawMake, rawModel string) (ManualLens, bool) {
+	cameras := []string{rawModel, rawMake + " " + rawModel, photo.Camera}
+	focal := strings.ReplaceAll(exifString(photo.Exif, "FocalLength"), ".0 mm", " mm")
+	for _, rule := range g.Manual {
+		if rule.Name == "" {
+			continue
+		}
+		if len(rule.Files) > 0 {
+			if containsFold(rule.Files, photo.Filename) {
+				return rule, true
+			}
+			continue
+		}
+		if len(rule.Cameras) > 0 && !containsAnyFold(rule.Cameras, cameras) {
+			continue
+		}
+		if (rule.Start != "" && photo.Date < rule.Start) || (rule.End != "" && photo.Date > rule.End) {
+			continue
+		}
+		if rule.FocalLength != "" && !strings.EqualFold(strings.ReplaceAll(rule.FocalLength, ".0 mm", " mm"), focal) {
+			continue
+		}
+		return rule, true
+	}
+	return ManualLens{}, false
+}
+
+// lookupName looks keys up exactly first, then case-insensitively with collapsed whitespace
+func lookupName(names map[string]string, keys ...string) (string, bool) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if name, ok := names[key]; ok {
+			return name, true
+		}
+	}
+	for _, key := range keys {
+		normalized := normalizeGearKey(key)
+		if normalized == "" {
+			continue
+		}
+		for raw, name := range names {
+			if normalizeGearKey(raw) == normalized {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func normalizeGearKey(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func isPlaceholderLens(s string) bool {
+	return gearPlaceholderLenses[normalizeGearKey(s)]
+}
+
+// joinMakerModel avoids "Canon Canon EOS R5" when the model already starts with the maker's name
+func joinMakerModel(maker, model string) string {
+	words := strings.Fields(maker)
+	if len(words) == 0 || strings.HasPrefix(strings.ToLower(model), strings.ToLower(words[0])) {
+		return model
+	}
+	return maker + " " + model
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAnyFold(list []string, values []string) bool {
+	for _, value := range values {
+		if value != "" && containsFold(list, strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssignLens records a manual lens for a single file, replacing any previous per-file assignment.
+// An empty name removes the assignment.
+func (g *GearRegistry) AssignLens(filename, name string) {
+	// Drop the file from existing per-file rules, and rules left empty
+	rules := g.Manual[:0]
+	for _, rule := range g.Manual {
+		if len(rule.Files) > 0 {
+			files := rule.Files[:0]
+			for _, f := range rule.Files {
+				if !strings.EqualFold(f, filename) {
+					files = append(files, f)
+				}
+			}
+			if len(files) == 0 {
+				continue
+			}
+			rule.Files = files
+		}
+		rules = append(rules, rule)
+	}
+	g.Manual = rules
+
+	if name == "" {
+		return
+	}
+	for i := range g.Manual {
+		if g.Manual[i].Name == name && len(g.Manual[i].Files) > 0 {
+			g.Manual[i].Files = append(g.Manual[i].Files, filename)
+			return
+		}
+	}
+	g.Manual = append(g.Manual, ManualLens{Name: name, Files: []string{filename}})
+}
+
+// RenameFile moves the per-file lens assignments of a renamed photo, reporting whether any changed
+func (g *GearRegistry) RenameFile(from, to string) bool {
+	if g == nil {
+		return false
+	}
+	changed := false
+	for i := range g.Manual {
+		for j, f := range g.Manual[i].Files {
+			if strings.EqualFold(f, from) {
+				g.Manual[i].Files[j] = to
+				changed = true
+			}
+		}
+	}
+	return changed
+}
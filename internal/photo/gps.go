@@ -0,0 +1,127 @@
+package photo
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/geo"
+)
+
+var gpsNumberRegex = regexp.MustCompile(`[-+]?\d+(?:\.\d+)?(?:/\d+(?:\.\d+)?)?`)
+
+// parseGPSCoordinate converts an EXIF coordinate to decimal degrees.
+//
+// Accepted forms: a number (exiftool -n), "30 deg 33' 44.70\" N" (exiftool), "[30/1 33/1 4470/100]"
+// (go-exif) and plain decimal strings. The hemisphere is taken from the value itself or from ref.
+func parseGPSCoordinate(value interface{}, ref string) (float64, bool) {
+	var decimal float64
+	hemisphere := gpsRefLetter(ref)
+
+	switch v := value.(type) {
+	case float64:
+		decimal = v
+	case int:
+		decimal = float64(v)
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return 0, false
+		}
+		// A trailing hemisphere letter in the value wins over the ref field
+		if last := s[len(s)-1:]; strings.Contains("NSEW", last) {
+			hemisphere = last
+		}
+
+		numbers := gpsNumberRegex.FindAllString(strings.Trim(s, "[]"), -1)
+		parts := make([]float64, 0, len(numbers))
+		for _, n := range numbers {
+			f, err := parseRational(n)
+			if err != nil {
+				return 0, false
+			}
+			parts = append(parts, f)
+		}
+
+		switch len(parts) {
+		case 1:
+			decimal = parts[0]
+		case 2:
+			decimal = math.Abs(parts[0]) + parts[1]/60
+		case 3:
+			decimal = math.Abs(parts[0]) + parts[1]/60 + parts[2]/3600
+		default:
+			return 0, false
+		}
+		if len(parts) > 1 && parts[0] < 0 {
+			decimal = -decimal
+		}
+	default:
+		return 0, false
+	}
+
+	if hemisphere == "S" || hemisphere == "W" {
+		decimal = -math.Abs(decimal)
+	}
+	if math.IsNaN(decimal) || math.Abs(decimal) > 180 {
+		return 0, false
+	}
+	return roundTo(decimal, 6), true
+}
+
+// parseGPSAltitude converts an EXIF altitude ("12.3 m Above Sea Level", "[123/10]" or a number) to meters
+func parseGPSAltitude(value interface{}, ref string) (float64, bool) {
+	var altitude float64
+	below := strings.Trim(ref, "[] ") == "1" || strings.Contains(ref, "Below")
+
+	switch v := value.(type) {
+	case float64:
+		altitude = v
+	case int:
+		altitude = float64(v)
+	case string:
+		number := gpsNumberRegex.FindString(strings.Trim(v, "[]"))
+		if number == "" {
+			return 0, false
+		}
+		f, err := parseRational(number)
+		if err != nil {
+			return 0, false
+		}
+		altitude = f
+		below = below || strings.Contains(v, "Below")
+	default:
+		return 0, false
+	}
+
+	if below {
+		altitude = -math.Abs(altitude)
+	}
+	return roundTo(altitude, 1), true
+}
+
+// applyLocation fills the decimal coordinates and reverse geocoded place from the photo's EXIF data
+func applyLocation(photo *Photo) {
+	photo.Latitude, photo.Longitude, photo.Altitude, photo.Location = nil, nil, nil, nil
+
+	lat, okLat := parseGPSCoordinate(photo.Exif["GPSLatitude"], exifString(photo.Exif, "GPSLatitudeRef"))
+	lon, okLon := parseGPSCoordinate(photo.Exif["GPSLongitude"], exifString(photo.Exif, "GPSLongitudeRef"))
+	// 0,0 is what some cameras write when they have no fix
+	if !okLat || !okLon || (lat == 0 && lon == 0) || math.Abs(lat) > 90 {
+		return
+	}
+	photo.Latitude, photo.Longitude = &lat, &lon
+
+	if alt, ok := parseGPSAltitude(photo.Exif["GPSAltitude"], exifString(photo.Exif, "GPSAltitudeRef")); ok {
+		photo.Altitude = &alt
+	}
+
+	if place, ok := geo.Default().Lookup(lat, lon); ok {
+		photo.Location = &place
+	}
+}
+
+func roundTo(f float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(f*p) / p
+}
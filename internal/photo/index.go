@@ -0,0 +1,121 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileIndexVersion is bumped whenever the hash algorithm or entry layout changes
+const FileIndexVersion = 1
+
+// IndexEntry is the cached hash of a file together with the stat data it was computed for
+type IndexEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`           // Unix nanoseconds
+	Inode   uint64 `json:"inode,omitempty"` // 0 where the platform has no inodes
+	Hash    string `json:"hash"`
+}
+
+// FileIndex caches file hashes so unchanged files are not read on every rebuild.
+// It is safe for concurrent use.
+type FileIndex struct {
+	Version int                   `json:"version"`
+	Entries map[string]IndexEntry `json:"entries"` // Key: path relative to the images directory
+
+	path   string
+	mu     sync.Mutex
+	seen   map[string]bool
+	hits   int
+	misses int
+}
+
+// LoadFileIndex loads the index from the state directory. A missing, unreadable or
+// outdated index results in an empty one.
+func LoadFileIndex(rootDir string) *FileIndex {
+	idx := &FileIndex{
+		Version: FileIndexVersion,
+		Entries: make(map[string]IndexEntry),
+		path:    filepath.Join(StateDirPath(rootDir), "index.json"),
+		seen:    make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if err != nil {
+		return idx
+	}
+	var stored FileIndex
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != FileIndexVersion {
+		return idx
+	}
+	if stored.Entries != nil {
+		idx.Entries = stored.Entries
+	}
+	return idx
+}
+
+// Hash returns the hash of the file at path, stored under key. The cached hash is used when
+// size, mtime and inode are unchanged, unless full is set.
+func (idx *FileIndex) Hash(path, key string, full bool) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	stat := IndexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: fileInode(info)}
+
+	idx.mu.Lock()
+	idx.seen[key] = true
+	entry, ok := idx.Entries[key]
+	if ok && !full && entry.Hash != "" &&
+		entry.Size == stat.Size && entry.ModTime == stat.ModTime && entry.Inode == stat.Inode {
+		idx.hits++
+		idx.mu.Unlock()
+		return entry.Hash, nil
+	}
+	idx.misses++
+	idx.mu.Unlock()
+
+	hash, err := calculateFileHash(path)
+	if err != nil {
+		return "", err
+	}
+	stat.Hash = hash
+
+	idx.mu.Lock()
+	idx.Entries[key] = stat
+	idx.mu.Unlock()
+	return hash, nil
+}
+
+// Stats returns the number of cache hits and hashed files of this run
+func (idx *FileIndex) Stats() (hits, misses int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.hits, idx.misses
+}
+
+// Save writes the index. With prune set, entries of files that were not seen in this run
+// are dropped; an interrupted run keeps them since its unseen files may still exist.
+func (idx *FileIndex) Save(prune bool) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if prune {
+		for key := range idx.Entries {
+			if !idx.seen[key] {
+				delete(idx.Entries, key)
+			}
+		}
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to marshal file index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return os.WriteFile(idx.path, data, 0644)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package photo
+
+import "os"
+
+// fileInode is not available on this platform, size and mtime alone decide
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package photo
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of a file, so a file replaced by another with the same size
+// and mtime is still detected
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,212 @@
+package photo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxJobHistory is the number of rebuild jobs kept in the job store
+const MaxJobHistory = 100
+
+// JobTrigger is what started a rebuild
+type JobTrigger string
+
+const (
+	TriggerCLI   JobTrigger = "cli"
+	TriggerAdmin JobTrigger = "admin"
+	TriggerWatch JobTrigger = "watch"
+)
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// JobCounts are the per-change photo counts of a job
+type JobCounts struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+	Renamed   int `json:"renamed"`
+	Failed    int `json:"failed"`
+}
+
+// RebuildJob is the record of a single rebuild. A job that stays "running" after its process
+// ended was interrupted by a crash or kill.
+type RebuildJob struct {
+	ID        string         `json:"id"`
+	Trigger   JobTrigger     `json:"trigger"`
+	Full      bool           `json:"full,omitempty"`
+	Status    string         `json:"status"`
+	StartTime time.Time      `json:"start_time"`
+	EndTime   time.Time      `json:"end_time,omitempty"`
+	Counts    JobCounts      `json:"counts"`
+	Error     string         `json:"error,omitempty"`
+	Result    *RebuildResult `json:"result,omitempty"`
+	Logs      []string       `json:"logs,omitempty"`
+}
+
+// newRebuildJob creates a running job with a sortable, unique ID
+func newRebuildJob(trigger JobTrigger, full bool) *RebuildJob {
+	start := time.Now()
+	return &RebuildJob{
+		ID:        newJobID(start),
+		Trigger:   trigger,
+		Full:      full,
+		Status:    JobRunning,
+		StartTime: start,
+	}
+}
+
+// newJobID returns a sortable, unique ID for a job or trash batch started at start
+func newJobID(start time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return start.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// finish records the outcome of the job
+func (j *RebuildJob) finish(result *RebuildResult, err error) {
+	j.EndTime = time.Now()
+	j.Result = result
+	j.Counts = JobCounts{
+		Added:     len(result.Added),
+		Updated:   len(result.Updated),
+		Unchanged: len(result.Unchanged),
+		Deleted:   len(result.Deleted),
+		Renamed:   len(result.Renamed),
+		Failed:    len(result.Failed),
+	}
+	switch {
+	case result.Cancelled:
+		j.Status = JobCancelled
+	case err != nil:
+		j.Status = JobFailed
+	default:
+		j.Status = JobCompleted
+	}
+	if err != nil {
+		j.Error = err.Error()
+	}
+}
+
+// Summary returns the job without its logs and file lists
+func (j *RebuildJob) Summary() RebuildJob {
+	return RebuildJob{
+		ID:        j.ID,
+		Trigger:   j.Trigger,
+		Full:      j.Full,
+		Status:    j.Status,
+		StartTime: j.StartTime,
+		EndTime:   j.EndTime,
+		Counts:    j.Counts,
+		Error:     j.Error,
+	}
+}
+
+// JobStore keeps the history of rebuild jobs, one JSON file per job
+type JobStore struct {
+	Dir string
+}
+
+// NewJobStore returns the store in the state directory
+func NewJobStore(rootDir string) *JobStore {
+	return &JobStore{Dir: filepath.Join(StateDirPath(rootDir), "jobs")}
+}
+
+func (s *JobStore) path(id string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+".json")
+}
+
+// Save stores a job and drops the oldest jobs beyond MaxJobHistory
+func (s *JobStore) Save(job *RebuildJob) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create job store: %w", err)
+	}
+	if err := os.WriteFile(s.path(job.ID), data, 0644); err != nil {
+		return fmt.Errorf("failed to write job %s: %w", job.ID, err)
+	}
+	return s.prune()
+}
+
+// Load returns a job, nil if there is none with that ID
+func (s *JobStore) Load(id string) (*RebuildJob, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read job: %w", err)
+	}
+	var job RebuildJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to parse job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// List returns the summaries of all jobs, newest first
+func (s *JobStore) List() ([]RebuildJob, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]RebuildJob, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		job, err := s.Load(ids[i])
+		if err != nil || job == nil {
+			continue // Skip unreadable records instead of hiding the whole history
+		}
+		jobs = append(jobs, job.Summary())
+	}
+	return jobs, nil
+}
+
+// ids returns the stored job IDs, oldest first since IDs start with the start time
+func (s *JobStore) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read job store: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// prune removes the oldest jobs beyond MaxJobHistory
+func (s *JobStore) prune() error {
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+	for len(ids) > MaxJobHistory {
+		if err := os.Remove(s.path(ids[0])); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove job %s: %w", ids[0], err)
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
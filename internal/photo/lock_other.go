@@ -0,0 +1,15 @@
+//go:build !unix
+
+package photo
+
+import "os"
+
+// lockFile is not available on this platform, only writers within a process are serialized
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return nil
+}
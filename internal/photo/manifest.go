@@ -0,0 +1,305 @@
+package photo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBackupKeep is the number of most recent photos.json backups that are always kept
+	DefaultBackupKeep = 20
+	// DefaultBackupDays is the number of days for which the last backup of each day is kept
+	DefaultBackupDays = 30
+
+	// backupTimeFormat is the timestamp in backup filenames, sortable and unique per write
+	backupTimeFormat = "20060102-150405.000"
+	// legacyBackupTimeFormat is the timestamp of the .bak files once written next to photos.json
+	legacyBackupTimeFormat = "20060102_150405"
+)
+
+// manifestMu serializes writers within a process, the file lock serializes processes
+var manifestMu sync.Mutex
+
+// ManifestPath returns the path of photos.json
+func ManifestPath(rootDir string) string {
+	return filepath.Join(rootDir, OutputFile)
+}
+
+// BackupDirPath returns the directory of the photos.json backups
+func BackupDirPath(rootDir string) string {
+	return filepath.Join(StateDirPath(rootDir), "backups")
+}
+
+// LockManifest takes the advisory lock on photos.json shared by the update command and the admin
+// server, waiting until it is available. Callers hold it from reading photos.json until the new
+// version is written and published, and must not take it again before calling unlock.
+func LockManifest(rootDir string) (unlock func(), err error) {
+	manifestMu.Lock()
+	path := filepath.Join(StateDirPath(rootDir), "photos.lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		manifestMu.Unlock()
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		manifestMu.Unlock()
+		return nil, fmt.Errorf("failed to open manifest lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		manifestMu.Unlock()
+		return nil, fmt.Errorf("failed to lock manifest: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		manifestMu.Unlock()
+	}, nil
+}
+
+// WriteManifest replaces photos.json with data, which must have the current schema. The current
+// version is backed up first, and the new one is written to a temporary file and renamed, so
+// readers never see a partial file. The caller holds the manifest lock.
+func WriteManifest(rootDir string, data []byte) error {
+	// Only manifests of the current schema are written, and never over a newer one
+	if version, err := ManifestSchemaOf(data); err != nil {
+		return err
+	} else if version != ManifestSchemaVersion {
+		return fmt.Errorf("refusing to write %s with schema version %d, expected %d", OutputFile, version, ManifestSchemaVersion)
+	}
+	path := ManifestPath(rootDir)
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", OutputFile, err)
+	}
+	if version, err := ManifestSchemaOf(current); err == nil && version > ManifestSchemaVersion {
+		return fmt.Errorf("%w: version %d, this program understands up to %d", ErrManifestTooNew, version, ManifestSchemaVersion)
+	}
+	if len(current) > 0 && !bytes.Equal(current, data) {
+		if err := writeBackup(rootDir, current, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", OutputFile, err)
+	}
+	return pruneBackups(rootDir, time.Now())
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// ManifestBackup is a previous version of photos.json
+type ManifestBackup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// writeBackup stores a version of photos.json in the backup directory
+func writeBackup(rootDir string, data []byte, now time.Time) error {
+	dir := BackupDirPath(rootDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	name := "photos." + now.Format(backupTimeFormat) + ".json"
+	if err := writeFileAtomic(filepath.Join(dir, name), data, 0644); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", OutputFile, err)
+	}
+	return nil
+}
+
+// ListBackups returns the backups of photos.json, newest first. Backups written next to
+// photos.json by earlier versions are moved into the backup directory first.
+func ListBackups(rootDir string) ([]ManifestBackup, error) {
+	dir := BackupDirPath(rootDir)
+	migrateLegacyBackups(rootDir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+	var backups []ManifestBackup
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), "photos.")
+		if stamp, ok = strings.CutSuffix(stamp, ".json"); !ok || entry.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, ManifestBackup{Name: entry.Name(), Time: t, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// migrateLegacyBackups moves photos.json.<time>.bak files into the backup directory
+func migrateLegacyBackups(rootDir string) {
+	output := ManifestPath(rootDir)
+	matches, _ := filepath.Glob(output + ".*.bak")
+	if len(matches) == 0 {
+		return
+	}
+	dir := BackupDirPath(rootDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(path, output+"."), ".bak")
+		t, err := time.ParseInLocation(legacyBackupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		os.Rename(path, filepath.Join(dir, "photos."+t.Format(backupTimeFormat)+".json"))
+	}
+}
+
+// backupRetention reads PHOTO_BACKUP_KEEP and PHOTO_BACKUP_DAYS, falling back to the defaults
+func backupRetention() (keep, days int) {
+	keep, days = DefaultBackupKeep, DefaultBackupDays
+	if value, err := strconv.Atoi(os.Getenv("PHOTO_BACKUP_KEEP")); err == nil && value >= 0 {
+		keep = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("PHOTO_BACKUP_DAYS")); err == nil && value >= 0 {
+		days = value
+	}
+	return keep, days
+}
+
+// pruneBackups keeps the newest backups and the last backup of each recent day, and removes
+// the rest
+func pruneBackups(rootDir string, now time.Time) error {
+	backups, err := ListBackups(rootDir)
+	if err != nil {
+		return err
+	}
+	for _, backup := range expiredBackups(backups, now) {
+		if err := os.Remove(filepath.Join(BackupDirPath(rootDir), backup.Name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove backup %s: %w", backup.Name, err)
+		}
+	}
+	return nil
+}
+
+// expiredBackups returns the backups outside the retention, backups are ordered newest first
+func expiredBackups(backups []ManifestBackup, now time.Time) []ManifestBackup {
+	keep, days := backupRetention()
+	cutoff := now.AddDate(0, 0, -days)
+	seenDays := make(map[string]bool)
+	var expired []ManifestBackup
+	for i, backup := range backups {
+		day := backup.Time.Format(time.DateOnly)
+		daily := !seenDays[day] && backup.Time.After(cutoff)
+		seenDays[day] = true
+		if i < keep || daily {
+			continue
+		}
+		expired = append(expired, backup)
+	}
+	return expired
+}
+
+// manifestStats compares two manifests, counting the entries after adds, removes or changes
+// compared to before. Entries are compared as published, after a JSON round trip.
+func manifestStats(before, after []byte) ManifestStats {
+	current, planned := manifestEntries(before), manifestEntries(after)
+	stats := ManifestStats{
+		PhotosBefore: len(current),
+		PhotosAfter:  len(planned),
+		BytesBefore:  int64(len(before)),
+		BytesAfter:   int64(len(after)),
+	}
+	for filename, photo := range planned {
+		if previous, ok := current[filename]; !ok {
+			stats.Added++
+		} else if !reflect.DeepEqual(previous, photo) {
+			stats.Changed++
+		}
+	}
+	for filename := range current {
+		if _, ok := planned[filename]; !ok {
+			stats.Removed++
+		}
+	}
+	return stats
+}
+
+// mergeConcurrentEdits keeps the edits made to photos.json while a rebuild ran. base is the
+// manifest the rebuild started from and current the one on disk now; photos whose editable fields
+// changed in between take them from current.
+func mergeConcurrentEdits(base, current []byte, photos []Photo) []Photo {
+	before, now := manifestEntries(base), manifestEntries(current)
+	for i, photo := range photos {
+		b, ok := before[photo.Filename]
+		c, ok2 := now[photo.Filename]
+		if !ok || !ok2 || editableEqual(b, c) {
+			continue
+		}
+		photos[i].Alt, photos[i].Title, photos[i].Rating = c.Alt, c.Title, c.Rating
+		photos[i].IsHidden, photos[i].Subject = c.IsHidden, c.Subject
+		if b.IsHidden != c.IsHidden {
+			// The admin panel moved the objects when it hid or showed the photo
+			photos[i].Path, photos[i].Thumbnail = c.Path, c.Thumbnail
+		}
+	}
+	return photos
+}
+
+// editableEqual reports whether the fields edited in the admin panel are the same
+func editableEqual(a, b Photo) bool {
+	return a.Alt == b.Alt && a.Title == b.Title && a.Rating == b.Rating && a.IsHidden == b.IsHidden &&
+		strings.Join(a.Subject, "\x00") == strings.Join(b.Subject, "\x00")
+}
+
+// manifestEntries returns the photos of a manifest by filename
+func manifestEntries(content []byte) map[string]Photo {
+	entries := make(map[string]Photo)
+	if manifest, err := ParseManifest(content); err == nil {
+		for _, album := range manifest.Albums {
+			for _, photo := range album.Photos {
+				entries[photo.Filename] = photo
+			}
+		}
+	}
+	return entries
+}
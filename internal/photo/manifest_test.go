@@ -0,0 +1,85 @@
+package photo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiredBackups(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	at := func(days, hours int) time.Time {
+		return now.AddDate(0, 0, -days).Add(-time.Duration(hours) * time.Hour)
+	}
+
+	tests := []struct {
+		name  string
+		keep  string // PHOTO_BACKUP_KEEP, the default when empty
+		days  string // PHOTO_BACKUP_DAYS, the default when empty
+		times []time.Time
+		want  []int // Indexes of the expired backups
+	}{
+		{
+			name:  "keep the newest",
+			keep:  "2",
+			days:  "0",
+			times: []time.Time{at(0, 1), at(0, 2), at(0, 3), at(0, 4)},
+			want:  []int{2, 3},
+		},
+		{
+			name:  "keep everything",
+			keep:  "10",
+			days:  "0",
+			times: []time.Time{at(0, 1), at(1, 0), at(40, 0)},
+		},
+		{
+			name:  "last backup of each day",
+			keep:  "0",
+			days:  "3",
+			times: []time.Time{at(0, 1), at(0, 2), at(1, 0), at(1, 2), at(2, 0)},
+			want:  []int{1, 3},
+		},
+		{
+			name:  "days outside the retention",
+			keep:  "0",
+			days:  "3",
+			times: []time.Time{at(0, 1), at(3, 1), at(5, 0)},
+			want:  []int{1, 2},
+		},
+		{
+			name:  "newest and daily combined",
+			keep:  "2",
+			days:  "2",
+			times: []time.Time{at(0, 1), at(0, 2), at(0, 3), at(1, 0), at(1, 1), at(4, 0)},
+			want:  []int{2, 4, 5},
+		},
+		{
+			name:  "keep nothing",
+			keep:  "0",
+			days:  "0",
+			times: []time.Time{at(0, 1), at(1, 0)},
+			want:  []int{0, 1},
+		},
+		{
+			name:  "defaults",
+			times: []time.Time{at(0, 1), at(DefaultBackupDays+1, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PHOTO_BACKUP_KEEP", tt.keep)
+			t.Setenv("PHOTO_BACKUP_DAYS", tt.days)
+			var backups, want []ManifestBackup
+			for _, backupTime := range tt.times {
+				name := "photos." + backupTime.Format(backupTimeFormat) + ".json"
+				backups = append(backups, ManifestBackup{Name: name, Time: backupTime})
+			}
+			for _, i := range tt.want {
+				want = append(want, backups[i])
+			}
+			if got := expiredBackups(backups, now); !reflect.DeepEqual(got, want) {
+				t.Errorf("expiredBackups() = %v, want %v", got, want)
+			}
+		})
+	}
+}
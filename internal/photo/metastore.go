@@ -0,0 +1,74 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateDir is the default directory for local runtime state, it is never published
+const StateDir = ".photo-state"
+
+// StateDirPath returns the state directory from PHOTO_STATE_DIR or the default
+func StateDirPath(rootDir string) string {
+	path := os.Getenv("PHOTO_STATE_DIR")
+	if path == "" {
+		path = StateDir
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+	return path
+}
+
+// MetadataStore keeps the full extracted metadata of every photo, one JSON file per photo,
+// including the private fields that are not published in photos.json
+type MetadataStore struct {
+	Dir string
+}
+
+// NewMetadataStore returns the store in the state directory
+func NewMetadataStore(rootDir string) *MetadataStore {
+	return &MetadataStore{Dir: filepath.Join(StateDirPath(rootDir), "metadata")}
+}
+
+func (m *MetadataStore) path(filename string) string {
+	return filepath.Join(m.Dir, filepath.Base(filename)+".json")
+}
+
+// Save stores the metadata of a photo
+func (m *MetadataStore) Save(filename string, fields map[string]interface{}) error {
+	data, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create metadata store: %w", err)
+	}
+	return os.WriteFile(m.path(filename), data, 0644)
+}
+
+// Load returns the stored metadata of a photo, nil if there is none
+func (m *MetadataStore) Load(filename string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(m.path(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata of %s: %w", filename, err)
+	}
+	return fields, nil
+}
+
+// Delete removes the stored metadata of a photo
+func (m *MetadataStore) Delete(filename string) error {
+	if err := os.Remove(m.path(filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package photo
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PlanVersion is bumped whenever the plan layout changes
+const PlanVersion = 1
+
+// PlannedUpload is an object a rebuild would upload to R2
+type PlannedUpload struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"` // Before compression of large images
+	File  string `json:"file"`  // Photo the object belongs to
+}
+
+// PlannedCopy is an object a rebuild would copy within R2 for a renamed photo
+type PlannedCopy struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	File string `json:"file"`
+}
+
+// PlannedDelete is an object a rebuild would delete from R2
+type PlannedDelete struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"` // 0 when the object is missing or its size unknown
+}
+
+// ManifestStats compares two versions of photos.json, e.g. the current one with the planned one
+type ManifestStats struct {
+	PhotosBefore int   `json:"photos_before"`
+	PhotosAfter  int   `json:"photos_after"`
+	Added        int   `json:"added"`   // Entries only in the planned manifest
+	Removed      int   `json:"removed"` // Entries only in the current manifest
+	Changed      int   `json:"changed"` // Entries in both that differ
+	BytesBefore  int64 `json:"bytes_before"`
+	BytesAfter   int64 `json:"bytes_after"`
+}
+
+// Plan is what a rebuild would do, computed by a dry run without uploading, deleting or writing
+// photos.json. A saved plan can be applied later as long as nothing changed in between.
+type Plan struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Full      bool      `json:"full,omitempty"`
+
+	Added   []string     `json:"added"`
+	Updated []string     `json:"updated"`
+	Renamed []FileRename `json:"renamed"`
+	Deleted []string     `json:"deleted"`
+	Failed  []FileError  `json:"failed"`
+
+	Uploads     []PlannedUpload `json:"uploads"`
+	Copies      []PlannedCopy   `json:"copies"`
+	Deletes     []PlannedDelete `json:"deletes"`
+	UploadBytes int64           `json:"upload_bytes"`
+	DeleteBytes int64           `json:"delete_bytes"`
+
+	Manifest     ManifestStats `json:"manifest"`
+	BaseHash     string        `json:"base_hash"`     // Hash of the photos.json the plan was computed against
+	ManifestHash string        `json:"manifest_hash"` // Hash of the photos.json the plan produces
+}
+
+// ErrStalePlan is returned when applying a plan whose photos or manifest changed since it was made
+var ErrStalePlan = errors.New("plan is out of date, run --dry-run again")
+
+// IsEmpty reports whether applying the plan would change nothing
+func (p *Plan) IsEmpty() bool {
+	return p.BaseHash == p.ManifestHash && len(p.Uploads) == 0 && len(p.Copies) == 0 && len(p.Deletes) == 0
+}
+
+// recordUpload adds an upload to the plan
+func (p *PhotoProcessor) recordUpload(key, file string, size int64) {
+	p.planMu.Lock()
+	defer p.planMu.Unlock()
+	p.plan.Uploads = append(p.plan.Uploads, PlannedUpload{Key: key, Bytes: size, File: file})
+	p.plan.UploadBytes += size
+}
+
+// recordCopy adds a server-side copy to the plan
+func (p *PhotoProcessor) recordCopy(from, to, file string) {
+	p.planMu.Lock()
+	defer p.planMu.Unlock()
+	p.plan.Copies = append(p.plan.Copies, PlannedCopy{From: from, To: to, File: file})
+}
+
+// uploadBytes uploads data to R2, or records the upload in a dry run
+func (p *PhotoProcessor) uploadBytes(ctx context.Context, data []byte, key, contentType, cacheControl, file string) error {
+	if p.Options.DryRun {
+		p.recordUpload(key, file, int64(len(data)))
+		return nil
+	}
+	return p.R2Client.UploadBytes(ctx, data, key, contentType, cacheControl)
+}
+
+// uploadFile uploads a file to R2 and returns the bytes sent, or records the upload in a dry run
+func (p *PhotoProcessor) uploadFile(ctx context.Context, localPath, key, cacheControl, file string) (int64, error) {
+	if p.Options.DryRun {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return 0, err
+		}
+		p.recordUpload(key, file, info.Size())
+		return 0, nil
+	}
+	return p.R2Client.UploadFile(ctx, localPath, key, cacheControl)
+}
+
+// copyObject copies an object within R2, or records the copy in a dry run
+func (p *PhotoProcessor) copyObject(ctx context.Context, from, to, file string) error {
+	if p.Options.DryRun {
+		p.recordCopy(from, to, file)
+		return nil
+	}
+	return p.R2Client.CopyObject(ctx, from, to)
+}
+
+// planDeletes adds the objects a rebuild would delete, with their sizes where R2 reports them
+func (p *PhotoProcessor) planDeletes(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		size, _ := p.R2Client.ObjectSize(ctx, key)
+		p.plan.Deletes = append(p.plan.Deletes, PlannedDelete{Key: key, Bytes: size})
+		p.plan.DeleteBytes += size
+	}
+}
+
+// finishPlan fills in the photo lists and compares the manifests
+func (p *PhotoProcessor) finishPlan(result *RebuildResult, existingContent, jsonData []byte) {
+	plan := p.plan
+	plan.Added, plan.Updated, plan.Renamed = result.Added, result.Updated, result.Renamed
+	plan.Deleted, plan.Failed = result.Deleted, result.Failed
+	sort.Slice(plan.Uploads, func(i, j int) bool { return plan.Uploads[i].Key < plan.Uploads[j].Key })
+	sort.Slice(plan.Copies, func(i, j int) bool { return plan.Copies[i].To < plan.Copies[j].To })
+	sort.Slice(plan.Deletes, func(i, j int) bool { return plan.Deletes[i].Key < plan.Deletes[j].Key })
+
+	plan.Manifest = manifestStats(existingContent, jsonData)
+	plan.BaseHash = manifestHash(existingContent)
+	plan.ManifestHash = manifestHash(jsonData)
+	result.Plan = plan
+}
+
+// manifestHash returns the hash of the albums of a manifest, ignoring formatting, the schema and
+// when it was generated
+func manifestHash(content []byte) string {
+	if len(content) == 0 {
+		return ""
+	}
+	if doc, err := migrateManifest(content); err == nil {
+		if normalized, err := json.Marshal(doc["albums"]); err == nil {
+			content = normalized
+		}
+	}
+	return fmt.Sprintf("%x", md5.Sum(content))
+}
+
+// SavePlan writes a plan as JSON
+func SavePlan(plan *Plan, path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan saved by SavePlan
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("plan %s has version %d, expected %d", path, plan.Version, PlanVersion)
+	}
+	return &plan, nil
+}
+
+// ApplyPlan runs the rebuild a saved plan describes. The plan is computed again first and the
+// rebuild refused with ErrStalePlan if the photos or photos.json changed since it was saved;
+// changes made while the rebuild runs are not detected.
+func ApplyPlan(ctx context.Context, logChan chan<- string, plan *Plan, opts UpdateOptions) (*RebuildResult, error) {
+	opts.Full = plan.Full
+	check := opts
+	check.DryRun, check.OnProgress = true, nil
+	current, err := Rebuild(ctx, nil, check)
+	if err != nil {
+		return current, fmt.Errorf("failed to check plan: %w", err)
+	}
+	if current.Plan.BaseHash != plan.BaseHash || current.Plan.ManifestHash != plan.ManifestHash ||
+		!reflect.DeepEqual(current.Plan.Uploads, plan.Uploads) || !reflect.DeepEqual(current.Plan.Copies, plan.Copies) {
+		return current, ErrStalePlan
+	}
+
+	opts.DryRun = false
+	return Rebuild(ctx, logChan, opts)
+}
+
+// WriteText prints a plan in a human readable form
+func (p *Plan) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Plan created %s\n", p.CreatedAt.Format(time.DateTime))
+	fmt.Fprintf(
+		w, "Photos: %d new, %d changed, %d renamed, %d deleted, %d failed\n",
+		len(p.Added), len(p.Updated), len(p.Renamed), len(p.Deleted), len(p.Failed),
+	)
+	for _, filename := range p.Added {
+		fmt.Fprintf(w, "  + %s\n", filename)
+	}
+	for _, filename := range p.Updated {
+		fmt.Fprintf(w, "  ~ %s\n", filename)
+	}
+	for _, rename := range p.Renamed {
+		fmt.Fprintf(w, "  > %s -> %s\n", rename.From, rename.To)
+	}
+	for _, filename := range p.Deleted {
+		fmt.Fprintf(w, "  - %s\n", filename)
+	}
+	for _, failure := range p.Failed {
+		fmt.Fprintf(w, "  ! %s: %s\n", failure.File, failure.Error)
+	}
+
+	fmt.Fprintf(
+		w, "R2: %d uploads (%s), %d copies, %d deletes (%s)\n",
+		len(p.Uploads), formatBytes(p.UploadBytes), len(p.Copies), len(p.Deletes), formatBytes(p.DeleteBytes),
+	)
+	for _, upload := range p.Uploads {
+		fmt.Fprintf(w, "  upload %s (%s)\n", upload.Key, formatBytes(upload.Bytes))
+	}
+	for _, copy := range p.Copies {
+		fmt.Fprintf(w, "  copy   %s -> %s\n", copy.From, copy.To)
+	}
+	for _, del := range p.Deletes {
+		fmt.Fprintf(w, "  delete %s (%s)\n", del.Key, formatBytes(del.Bytes))
+	}
+
+	m := p.Manifest
+	fmt.Fprintf(
+		w, "photos.json: %d -> %d photos, %d added, %d changed, %d removed entries, %s -> %s\n",
+		m.PhotosBefore, m.PhotosAfter, m.Added, m.Changed, m.Removed,
+		formatBytes(m.BytesBefore), formatBytes(m.BytesAfter),
+	)
+	if p.IsEmpty() {
+		fmt.Fprintln(w, "Nothing to do.")
+	}
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %s", value, strings.Split("KB MB GB TB", " ")[exp])
+}
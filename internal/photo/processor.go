@@ -2,9 +2,12 @@ package photo
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
 	"io"
 	"io/fs"
 	"log"
@@ -16,6 +19,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/vincentchyu/vincentchyu.github.io/internal/geo"
 	"github.com/vincentchyu/vincentchyu.github.io/internal/imaging"
 	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
 )
@@ -47,20 +51,34 @@ const (
 
 // Photo represents a single photo entry
 type Photo struct {
-	Filename  string                 `json:"filename"`
-	Path      string                 `json:"path"`
-	Thumbnail string                 `json:"thumbnail"`
-	Alt       string                 `json:"alt"`
-	Year      string                 `json:"year"`
-	Month     string                 `json:"month"`
-	Date      string                 `json:"date"` // YYYY-MM-DD for sorting
-	Width     int                    `json:"width,omitempty"`
-	Height    int                    `json:"height,omitempty"`
-	Exif      map[string]interface{} `json:"exif,omitempty"`    // Complete EXIF data
-	Hash      string                 `json:"hash,omitempty"`    // File hash for caching
-	Timestamp int64                  `json:"-"`                 // Timestamp for sorting
-	IsHidden  bool                   `json:"is_hidden"`         // is_hidden
-	Subject   []string               `json:"Subject,omitempty"` // Custom tags
+	Filename   string                 `json:"filename"`
+	Path       string                 `json:"path"`
+	Thumbnail  string                 `json:"thumbnail"`
+	Alt        string                 `json:"alt"`
+	Title      string                 `json:"title,omitempty"`
+	Rating     int                    `json:"rating,omitempty"` // 0-5 stars, XMP Rating
+	Year       string                 `json:"year"`
+	Month      string                 `json:"month"`
+	Date       string                 `json:"date"`                  // YYYY-MM-DD for sorting
+	LocalTime  string                 `json:"local_time,omitempty"`  // Capture time where the photo was taken, RFC3339 with offset
+	UTCTime    string                 `json:"utc_time,omitempty"`    // Capture instant, RFC3339 in UTC
+	TimeSource string                 `json:"time_source,omitempty"` // How the capture zone was resolved
+	Width      int                    `json:"width,omitempty"`
+	Height     int                    `json:"height,omitempty"`
+	Exif       map[string]interface{} `json:"exif,omitempty"`      // Complete EXIF data
+	Latitude   *float64               `json:"latitude,omitempty"`  // Decimal degrees, south is negative
+	Longitude  *float64               `json:"longitude,omitempty"` // Decimal degrees, west is negative
+	Altitude   *float64               `json:"altitude,omitempty"`  // Meters, below sea level is negative
+	Location   *geo.Place             `json:"location,omitempty"`  // Offline reverse geocoded place
+	Camera     string                 `json:"camera,omitempty"`    // Canonical camera name from the gear registry
+	Lens       string                 `json:"lens,omitempty"`      // Canonical lens name from the gear registry
+	Hash       string                 `json:"hash,omitempty"`      // File hash for caching
+	Source     string                 `json:"source,omitempty"`    // Source path relative to the images directory
+	Raw        string                 `json:"raw,omitempty"`       // RAW filename archived under the private prefix
+	RawHash    string                 `json:"raw_hash,omitempty"`  // Hash of the paired RAW file
+	Timestamp  int64                  `json:"-"`                   // Timestamp for sorting
+	IsHidden   bool                   `json:"is_hidden"`           // is_hidden
+	Subject    []string               `json:"subject,omitempty"`   // Custom tags
 }
 
 // YearAlbum represents a collection of photos for a specific year
@@ -79,6 +97,40 @@ type PhotoProcessor struct {
 	NewPhotos      []Photo
 	Mutex          sync.Mutex
 	DateRegex      *regexp.Regexp
+	TimeZones      *TimezoneConfig
+	Gear           *GearRegistry
+	Fields         *FieldPolicy
+	Metadata       *MetadataStore
+	Index          *FileIndex
+	Options        UpdateOptions
+
+	progress *progressTracker // nil when no progress is reported
+	renames  map[string]Photo // Previous entries of renamed photos, key: new filename
+	plan     *Plan            // What the rebuild would do, collected in a dry run
+	planMu   sync.Mutex
+}
+
+// UpdateOptions controls a rebuild
+type UpdateOptions struct {
+	Full    bool       // Rehash every file instead of trusting the index cache
+	Trigger JobTrigger // Recorded in the job history, empty means TriggerCLI
+	DryRun  bool       // Compute a Plan without uploading, deleting or writing anything but the index cache
+
+	// AllowDeletions skips the DeleteThreshold check, for intentionally removing many photos
+	AllowDeletions bool
+
+	// Paths limits processing to these files, e.g. the changes seen by a watcher. Photos of
+	// other files keep their entries unread; removed files are detected either way.
+	Paths []string
+
+	// UpsertOnly only adds or updates the entries of Paths, for processing single files: the
+	// entries of all other photos are kept even when their files are gone, renames are not
+	// detected, the visibility of other photos is not synced and the trash is not purged.
+	UpsertOnly bool
+
+	// OnProgress receives a ProgressEvent whenever the rebuild advances. It is called from
+	// worker goroutines while the progress state is locked and must return quickly.
+	OnProgress func(ProgressEvent)
 }
 
 // NewPhotoProcessor creates a new PhotoProcessor
@@ -111,6 +163,22 @@ func NewPhotoProcessor() (*PhotoProcessor, error) {
 		}
 	}
 
+	timeZones, err := LoadTimezoneConfig(rootDir)
+	if err != nil {
+		log.Printf("⚠ Warning: %v\n", err)
+	}
+
+	gear, err := LoadGearRegistry(GearRegistryPath(rootDir))
+	if err != nil {
+		log.Printf("⚠ Warning: %v\n", err)
+	}
+
+	fields, err := LoadFieldPolicy(rootDir)
+	if err != nil {
+		log.Printf("⚠ Warning: %v\n", err)
+	}
+	SetFieldPolicy(fields)
+
 	return &PhotoProcessor{
 		RootDir:        rootDir,
 		ImgDirPath:     filepath.Join(rootDir, ImgDir),
@@ -118,6 +186,11 @@ func NewPhotoProcessor() (*PhotoProcessor, error) {
 		ThumbnailBase:  thumbnailBase,
 		ExistingPhotos: make(map[string]Photo),
 		DateRegex:      regexp.MustCompile(`DSC_(\d{4})-(\d{2})-(\d{2})`),
+		TimeZones:      timeZones,
+		Gear:           gear,
+		Fields:         fields,
+		Metadata:       NewMetadataStore(rootDir),
+		Index:          LoadFileIndex(rootDir),
 	}, nil
 }
 
@@ -131,17 +204,18 @@ func (p *PhotoProcessor) LoadExistingMetadata() ([]byte, error) {
 			return nil, err
 		}
 
-		var albums []YearAlbum
-		if err := json.Unmarshal(content, &albums); err == nil {
-			for _, album := range albums {
+		manifest, err := ParseManifest(content)
+		if errors.Is(err, ErrManifestTooNew) {
+			return nil, err
+		}
+		if err == nil {
+			for _, album := range manifest.Albums {
 				for _, photo := range album.Photos {
-					// Restore Timestamp from Exif if available
-					if val, ok := photo.Exif["DateTimeOriginal"]; ok {
-						if dateStr, ok := val.(string); ok {
-							if t, err := time.Parse("2006:01:02 15:04:05", dateStr); err == nil {
-								photo.Timestamp = t.Unix()
-							}
-						}
+					// Restore Timestamp from the stored UTC time, or from Exif for legacy entries
+					if t, err := time.Parse(time.RFC3339, photo.UTCTime); err == nil {
+						photo.Timestamp = t.Unix()
+					} else if captured, ok := p.TimeZones.ResolveCaptureTime(photo.Exif, time.Time{}); ok {
+						photo.Timestamp = captured.UTC().Unix()
 					}
 					p.ExistingPhotos[photo.Filename] = photo
 				}
@@ -152,6 +226,11 @@ func (p *PhotoProcessor) LoadExistingMetadata() ([]byte, error) {
 	return content, nil
 }
 
+// FileHash returns the hash used to detect changed photos
+func FileHash(filePath string) (string, error) {
+	return calculateFileHash(filePath)
+}
+
 // calculateFileHash calculates MD5 hash of a file
 func calculateFileHash(filePath string) (string, error) {
 	file, err := os.Open(filePath)
@@ -173,102 +252,203 @@ func calculateFileHash(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
-// processPhoto processes a single photo
-func (p *PhotoProcessor) processPhoto(path string, yearDirName string) (Photo, error) {
+// processPhoto processes a single photo.
+// rawPath is the RAW file paired with a JPEG at path, empty if there is none.
+func (p *PhotoProcessor) processPhoto(ctx context.Context, path, rawPath string, yearDirName string) (Photo, PhotoChange, error) {
 	filename := filepath.Base(path)
-	filenameNoExt := strings.TrimSuffix(filename, filepath.Ext(filename))
+	isRaw := IsRawFile(filename)
+	source := p.sourceKey(path)
 
-	// Calculate hash
-	hash, err := calculateFileHash(path)
+	// Calculate hash, unchanged files are answered from the index without reading them
+	hash, err := p.Index.Hash(path, source, p.Options.Full)
 	if err != nil {
-		return Photo{}, fmt.Errorf("failed to calculate hash: %w", err)
+		return Photo{}, "", fmt.Errorf("failed to calculate hash: %w", err)
+	}
+
+	// The RAW archive: the paired RAW, or the file itself when it is a RAW
+	var rawName, rawHash string
+	rawSource := rawPath
+	if rawPath != "" {
+		rawName = filepath.Base(rawPath)
+		rawKey := filepath.ToSlash(strings.TrimSuffix(source, filepath.Base(source)) + rawName)
+		if rawHash, err = p.Index.Hash(rawPath, rawKey, p.Options.Full); err != nil {
+			return Photo{}, "", fmt.Errorf("failed to calculate hash: %w", err)
+		}
+	} else if isRaw {
+		rawName, rawSource = filename, path
+	}
+	if rawName != "" && p.R2Client != nil && !p.R2Client.Config.HasPrivateBucket() {
+		// Not archived without a private bucket, the photo is processed again once there is one
+		rawName = ""
 	}
 
 	// Check if photo exists and hash matches
 	if existing, ok := p.ExistingPhotos[filename]; ok {
-		if existing.Hash == hash {
+		sameRendition := servedAsJPEG(existing) == servedAsJPEG(Photo{Filename: filename})
+		if existing.Hash == hash && existing.RawHash == rawHash && existing.Raw == rawName && sameRendition {
 			// Photo hasn't changed, return existing data with all custom fields preserved
 			// fmt.Printf("Skipping unchanged photo: %s\n", filename)
-			return existing, nil
+			// Derive from the full metadata, the published EXIF may lack private fields
+			stored := p.fullExif(&existing)
+			// Re-resolve the capture time so zone configuration changes apply to existing entries
+			if captured, ok := p.TimeZones.ResolveCaptureTime(existing.Exif, time.Time{}); ok {
+				applyCaptureTime(&existing, captured)
+			}
+			applyLocation(&existing)
+			p.Gear.Apply(&existing)
+			existing.Source = source
+			p.publishExif(&existing, !stored)
+			return existing, ChangeUnchanged, nil
 		}
 	}
 
-	// New or modified photo
-	log.Printf("🟢 Processing %s...\n", filename)
-
 	relPath, _ := filepath.Rel(p.RootDir, path)
 	webPath := strings.ReplaceAll(relPath, "\\", "/")
 	if after, ok := strings.CutPrefix(webPath, WebPhotographyPrefix); ok {
 		webPath = after
 	}
+	localThumbnail := p.ThumbnailBase + displayBase(filename) + ExtWebP
+
+	// A renamed or moved photo keeps its entry, its objects are copied instead of uploaded again
+	previous, renamed := p.renames[filename]
+	if renamed {
+		sameRendition := servedAsJPEG(previous) == servedAsJPEG(Photo{Filename: filename})
+		if previous.Hash == hash && previous.RawHash == rawHash && (previous.Raw == "") == (rawName == "") && sameRendition {
+			photo := previous
+			p.fullExif(&photo) // Still stored under the old filename
+			photo.Filename, photo.Source, photo.Raw = filename, source, rawName
+			photo.Path, photo.Thumbnail = webPath, localThumbnail
+			var err error
+			if p.R2Client != nil {
+				var keys ObjectKeys
+				if keys, err = p.copyObjects(ctx, previous, photo); err == nil {
+					photo.Path, photo.Thumbnail = p.R2Client.ObjectURL(keys.Original), p.R2Client.ObjectURL(keys.Thumbnail)
+				}
+			}
+			if err == nil {
+				if captured, ok := p.TimeZones.ResolveCaptureTime(photo.Exif, time.Time{}); ok {
+					applyCaptureTime(&photo, captured)
+				}
+				applyLocation(&photo)
+				p.Gear.Apply(&photo)
+				p.publishExif(&photo, true)
+				log.Printf("✓ Renamed %s → %s\n", previous.Filename, filename)
+				return photo, ChangeRenamed, nil
+			}
+			log.Printf("⚠ Warning: failed to copy objects of %s, uploading again: %v\n", previous.Filename, err)
+		}
+	}
+
+	// New or modified photo
+	log.Printf("🟢 Processing %s...\n", filename)
+
+	// RAW files are displayed through their largest embedded JPEG preview, HEIC through a conversion
+	var preview []byte
+	if isRaw {
+		if preview, err = imaging.ExtractRawPreview(ctx, path); err != nil {
+			return Photo{}, "", fmt.Errorf("failed to extract preview from %s: %w", filename, err)
+		}
+	} else if IsHEICFile(filename) {
+		if preview, err = imaging.ConvertHEICToJPEG(ctx, path); err != nil {
+			return Photo{}, "", fmt.Errorf("failed to decode %s: %w", filename, err)
+		}
+	}
 
 	var finalPath, finalThumbnail string
 
 	// R2 Upload Logic
 	if p.R2Client != nil {
+		// Hidden photos stay under the private prefix when they are replaced
+		target := Photo{Filename: filename, Raw: rawName, IsHidden: p.ExistingPhotos[filename].IsHidden}
+		if renamed {
+			target.IsHidden = previous.IsHidden
+		}
+		if !p.R2Client.Config.HasPrivateBucket() {
+			// Without a private bucket hidden photos stay unlisted in the public bucket
+			target.IsHidden = false
+		}
+		keys := KeysFor(p.R2Client.Config, target)
+
 		// 1. Upload Original
-		originalKey := fmt.Sprintf("%s%s%s", p.R2Client.Config.BasePrefix, p.R2Client.Config.OriginalPrefix, filename)
 		// We could check existence, but since hash changed or it's new, we should probably upload
 		// Or we can check if it exists to avoid re-uploading if only local metadata changed?
 		// For simplicity/safety, if hash changed, we upload.
-
-		if err := p.R2Client.UploadFile(path, originalKey, "public, max-age=31536000"); err != nil {
+		var err error
+		var sent int64
+		if servedAsJPEG(target) {
+			err = p.uploadBytes(ctx, preview, keys.Original, "image/jpeg", "public, max-age=31536000", filename)
+			sent = int64(len(preview))
+		} else {
+			sent, err = p.uploadFile(ctx, path, keys.Original, "public, max-age=31536000", filename)
+		}
+		if err != nil {
 			log.Printf("❌ Failed to upload original %s: %v\n", filename, err)
 			finalPath = webPath
-			return Photo{}, fmt.Errorf("failed to upload original %s: %w", filename, err)
+			return Photo{}, "", fmt.Errorf("failed to upload original %s: %w", filename, err)
 		} else {
-			finalPath = p.R2Client.GetCDNUrl(originalKey)
+			finalPath = p.R2Client.ObjectURL(keys.Original)
+			p.progress.uploaded(sent)
 		}
 
 		// 2. Upload Thumbnail
-		thumbnailKey := fmt.Sprintf(
-			"%s%s%s%s", p.R2Client.Config.BasePrefix, p.R2Client.Config.ThumbnailPrefix, filenameNoExt, ExtWebP,
-		)
-		thumbnailData, err := imaging.GenerateThumbnail(path, imaging.DefaultThumbnailConfig())
+		var thumbnailData []byte
+		if preview != nil {
+			thumbnailData, err = imaging.GenerateThumbnailFromBytes(preview, imaging.DefaultThumbnailConfig())
+		} else {
+			thumbnailData, err = imaging.GenerateThumbnail(path, imaging.DefaultThumbnailConfig())
+		}
 		if err != nil {
 			log.Printf("❌ Failed to generate thumbnail for %s: %v\n", filename, err)
-			finalThumbnail = p.ThumbnailBase + filenameNoExt + ".webp"
-			return Photo{}, fmt.Errorf("failed to upload thumbnail %s: %w", filename, err)
+			finalThumbnail = localThumbnail
+			return Photo{}, "", fmt.Errorf("failed to upload thumbnail %s: %w", filename, err)
 		} else {
-			if err := p.R2Client.UploadBytes(
-				thumbnailData, thumbnailKey, "image/webp", "public, max-age=31536000",
+			if err := p.uploadBytes(
+				ctx, thumbnailData, keys.Thumbnail, "image/webp", "public, max-age=31536000", filename,
 			); err != nil {
 				log.Printf("❌ Failed to upload thumbnail for %s: %v\n", filename, err)
-				finalThumbnail = p.ThumbnailBase + filenameNoExt + ".webp"
+				finalThumbnail = localThumbnail
 			} else {
-				finalThumbnail = p.R2Client.GetCDNUrl(thumbnailKey)
+				finalThumbnail = p.R2Client.ObjectURL(keys.Thumbnail)
+				p.progress.uploaded(int64(len(thumbnailData)))
+			}
+		}
+
+		// 3. Archive the RAW under the private prefix, it is never linked from photos.json
+		if keys.Raw != "" {
+			sent, err := p.uploadFile(ctx, rawSource, keys.Raw, "private, no-store", filename)
+			if err != nil {
+				return Photo{}, "", fmt.Errorf("failed to archive raw %s: %w", rawName, err)
 			}
+			p.progress.uploaded(sent)
+			log.Printf("✓ Archived %s\n", rawName)
 		}
 	} else {
 		finalPath = webPath
-		finalThumbnail = p.ThumbnailBase + filenameNoExt + ".webp"
+		finalThumbnail = localThumbnail
 	}
 
-	// Extract EXIF using configured extractor
-	exifData, width, height, dateTaken, err := GetExifExtractor().Extract(path)
-
-	var photoYear, month, dateStr string
-	var timestamp int64
-
-	if err == nil && !dateTaken.IsZero() {
-		photoYear = fmt.Sprintf("%04d", dateTaken.Year())
-		month = fmt.Sprintf("%02d", dateTaken.Month())
-		dateStr = dateTaken.Format("2006-01-02")
-		timestamp = dateTaken.Unix()
-	} else {
-		// Fallback to filename
-		matches := p.DateRegex.FindStringSubmatch(filename)
-		if len(matches) >= 4 {
-			photoYear = matches[1]
-			month = matches[2]
-			dateStr = fmt.Sprintf(DateFormatYMD, matches[1], matches[2], matches[3])
-		} else {
-			photoYear = yearDirName
-			month = DefaultMonth
-			dateStr = fmt.Sprintf(DateFormatDefault, yearDirName)
+	// Extract EXIF using configured extractor, the RAW itself is read for unpaired RAW files
+	exifData, width, height, dateTaken, err := GetExifExtractor().Extract(ctx, path)
+	if rawPath != "" {
+		// Exported JPEGs may have lost tags the camera wrote into the RAW
+		if rawExif, _, _, rawDate, rawErr := GetExifExtractor().Extract(ctx, rawPath); rawErr == nil {
+			if exifData == nil {
+				exifData = make(map[string]interface{})
+			}
+			for key, value := range rawExif {
+				if _, ok := exifData[key]; !ok {
+					exifData[key] = value
+				}
+			}
+			if err != nil || dateTaken.IsZero() {
+				dateTaken, err = rawDate, nil
+			}
 		}
-		if err != nil {
-			log.Printf("⚠ EXIF extraction failed for %s: %v\n", filename, err)
+	}
+	if preview != nil {
+		// The sensor size or stored orientation differs from the served rendition
+		if cfg, _, decodeErr := image.DecodeConfig(bytes.NewReader(preview)); decodeErr == nil {
+			width, height = cfg.Width, cfg.Height
 		}
 	}
 
@@ -278,74 +458,202 @@ func (p *PhotoProcessor) processPhoto(path string, yearDirName string) (Photo, e
 		Path:      finalPath,
 		Thumbnail: finalThumbnail,
 		Alt:       "", // Preserve alt if exists?
-		Year:      photoYear,
-		Month:     month,
-		Date:      dateStr,
 		Width:     width,
 		Height:    height,
 		Exif:      exifData,
 		Hash:      hash,
-		Timestamp: timestamp,
+		Source:    source,
+		Raw:       rawName,
+		RawHash:   rawHash,
 	}
 
-	// Extract tags from EXIF Subject if available
-	if subj, ok := exifData["Subject"].([]interface{}); ok {
-		for _, s := range subj {
-			if str, ok := s.(string); ok {
-				photo.Subject = append(photo.Subject, str)
-			}
+	var captured CaptureTime
+	var hasCaptureTime bool
+	if err == nil {
+		captured, hasCaptureTime = p.TimeZones.ResolveCaptureTime(exifData, dateTaken)
+	}
+
+	if hasCaptureTime {
+		applyCaptureTime(&photo, captured)
+	} else {
+		// Fallback to filename
+		matches := p.DateRegex.FindStringSubmatch(filename)
+		if len(matches) >= 4 {
+			photo.Year = matches[1]
+			photo.Month = matches[2]
+			photo.Date = fmt.Sprintf(DateFormatYMD, matches[1], matches[2], matches[3])
+		} else {
+			photo.Year = yearDirName
+			photo.Month = DefaultMonth
+			photo.Date = fmt.Sprintf(DateFormatDefault, yearDirName)
 		}
-	} else if subj, ok := exifData["Subject"].(string); ok {
-		// Sometimes it's a single string
-		photo.Subject = []string{subj}
-	} else if kw, ok := exifData["Keywords"].([]interface{}); ok {
-		for _, k := range kw {
-			if str, ok := k.(string); ok {
-				photo.Subject = append(photo.Subject, str)
-			}
+		if err != nil {
+			log.Printf("⚠ EXIF extraction failed for %s: %v\n", filename, err)
 		}
-	} else if kw, ok := exifData["Keywords"].(string); ok {
-		photo.Subject = []string{kw}
 	}
 
-	// Preserve custom fields from existing photo if available
-	if existing, ok := p.ExistingPhotos[filename]; ok {
-		photo.Alt = existing.Alt
+	applyLocation(&photo)
+	p.Gear.Apply(&photo)
+
+	// Editable metadata embedded in the file, an XMP sidecar overrides it
+	meta := metadataFromExif(exifData)
+	if sidecar, ok := ReadSidecar(ctx, path); ok {
+		meta = mergeMetadata(meta, sidecar)
+	}
+	photo.Title, photo.Alt, photo.Subject, photo.Rating = meta.Title, meta.Caption, meta.Keywords, meta.Rating
+
+	// Preserve custom fields from existing photo if available, or from the photo it was renamed from
+	existing, ok := p.ExistingPhotos[filename]
+	if !ok {
+		existing, ok = previous, renamed
+	}
+	if ok {
 		photo.IsHidden = existing.IsHidden
+		if CurrentWritebackMode() == WritebackOff {
+			// photos.json is the source of truth for edited metadata
+			photo.Alt = existing.Alt
+			if existing.Title != "" {
+				photo.Title = existing.Title
+			}
+			if existing.Rating != 0 {
+				photo.Rating = existing.Rating
+			}
+		} else {
+			// Edits were written into the file, so the file wins and the manifest only fills gaps
+			photo = mergeIntoPhoto(photo, MetadataOf(existing))
+		}
 		// If photo has no tags from EXIF, preserve existing tags
 		if len(photo.Subject) == 0 {
 			photo.Subject = existing.Subject
 		}
 	}
 
-	return photo, nil
+	p.publishExif(&photo, true)
+
+	change := ChangeAdded
+	if _, ok := p.ExistingPhotos[filename]; ok {
+		change = ChangeUpdated
+	} else if renamed {
+		change = ChangeRenamed
+	}
+	return photo, change, nil
 }
 
-// UpdatePhotosHandler processes all photos
-func UpdatePhotosHandler(logChan chan<- string) {
-	// Helper for logging
+// fullExif merges the stored metadata of a photo into its EXIF, reporting whether the store had any
+func (p *PhotoProcessor) fullExif(photo *Photo) bool {
+	stored, err := p.Metadata.Load(photo.Filename)
+	if err != nil {
+		log.Printf("⚠ Warning: %v\n", err)
+	}
+	if stored == nil {
+		return false
+	}
+	full := make(map[string]interface{}, len(stored)+len(photo.Exif))
+	for key, value := range photo.Exif {
+		full[key] = value
+	}
+	for key, value := range stored {
+		full[key] = value
+	}
+	photo.Exif = full
+	return true
+}
+
+// publishExif keeps the private fields of a photo's EXIF in the metadata store (when save is set)
+// and only the public ones in the photo, together with the fields computed from them
+func (p *PhotoProcessor) publishExif(photo *Photo, save bool) {
+	if save && !p.Options.DryRun {
+		if err := p.Metadata.Save(photo.Filename, p.Fields.PrivateFields(photo.Exif)); err != nil {
+			log.Printf("⚠ Warning: failed to store metadata of %s: %v\n", photo.Filename, err)
+		}
+	}
+	photo.Exif = p.Fields.PublicFields(photo.Exif)
+	p.Fields.HideDerived(photo)
+}
+
+// Rebuild processes all photos, publishes photos.json and reports what changed.
+// Failures of single files are collected in the result, the error is only set when the
+// rebuild as a whole failed.
+func Rebuild(ctx context.Context, logChan chan<- string, opts UpdateOptions) (result *RebuildResult, err error) {
+	if opts.Trigger == "" {
+		opts.Trigger = TriggerCLI
+	}
+	job := newRebuildJob(opts.Trigger, opts.Full)
+	result = &RebuildResult{JobID: job.ID, StartTime: job.StartTime}
+
+	// Record the job in the history, it is saved once at the start and again with its outcome.
+	// Dry runs change nothing and are not recorded.
+	var history *JobStore
+	if rootDir, wdErr := os.Getwd(); wdErr == nil && !opts.DryRun {
+		history = NewJobStore(rootDir)
+		if saveErr := history.Save(job); saveErr != nil {
+			log.Printf("⚠ Warning: failed to record job %s: %v\n", job.ID, saveErr)
+		}
+	}
+	defer func() {
+		job.finish(result, err)
+		if history != nil {
+			if saveErr := history.Save(job); saveErr != nil {
+				log.Printf("⚠ Warning: failed to record job %s: %v\n", job.ID, saveErr)
+			}
+		}
+	}()
+	defer func() {
+		result.EndTime = time.Now()
+		result.sortLists()
+	}()
+
+	// Helper for logging, workers log concurrently
+	var logMu sync.Mutex
 	logMsg := func(format string, v ...interface{}) {
 		msg := fmt.Sprintf(format, v...)
 		log.Println(msg) // Keep stdout logging
+		logMu.Lock()
+		job.Logs = append(job.Logs, msg)
+		logMu.Unlock()
 		if logChan != nil {
 			logChan <- msg
 		}
 	}
+	logMsg("🟢 Job %s (%s)", job.ID, job.Trigger)
 
 	processor, err := NewPhotoProcessor()
 	if err != nil {
 		logMsg("Error initializing processor: %v", err)
-		os.Exit(1)
+		return result, fmt.Errorf("error initializing processor: %w", err)
+	}
+	processor.Options = opts
+	if opts.DryRun {
+		processor.plan = &Plan{Version: PlanVersion, CreatedAt: job.StartTime, Full: opts.Full}
+		logMsg("🟢 Dry run, nothing is uploaded, deleted or written")
+	}
+	processor.progress = newProgressTracker(opts.OnProgress)
+	processor.progress.stage(StageScan)
+	defer processor.progress.stage(StageDone)
+	if opts.Full {
+		logMsg("🟢 Full rebuild, rehashing every file")
 	}
 	var existingContent []byte
 
 	if existingContent, err = processor.LoadExistingMetadata(); err != nil {
+		if errors.Is(err, ErrManifestTooNew) {
+			logMsg("❌ %v", err)
+			return result, err
+		}
 		logMsg("Warning: Failed to load existing metadata: %v", err)
 	}
+	if processor.R2Client != nil && !opts.DryRun {
+		if moved, err := processor.R2Client.MigratePrivateObjects(ctx); err != nil {
+			logMsg("⚠ Warning: failed to move private objects to the private bucket: %v", err)
+		} else if moved > 0 {
+			logMsg("✓ Moved %d private objects from the public to the private bucket", moved)
+		}
+	}
 
 	// Collect all image files
 	type Job struct {
 		Path    string
+		RawPath string // RAW paired with the JPEG at Path
 		YearDir string
 	}
 	var jobs []Job
@@ -353,23 +661,27 @@ func UpdatePhotosHandler(logChan chan<- string) {
 	entries, err := os.ReadDir(processor.ImgDirPath)
 	if err != nil {
 		logMsg("Error reading image directory: %v", err)
-		os.Exit(1)
+		return result, fmt.Errorf("error reading image directory: %w", err)
 	}
 
+	pairRaw := RawPairingEnabled()
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
 		yearDir := filepath.Join(processor.ImgDirPath, entry.Name())
+		if opts.UpsertOnly && !containsPath(opts.Paths, yearDir) {
+			continue
+		}
 
+		var paths []string
 		err := filepath.WalkDir(
 			yearDir, func(path string, d fs.DirEntry, err error) error {
 				if err != nil || d.IsDir() {
 					return err
 				}
-				ext := strings.ToLower(filepath.Ext(d.Name()))
-				if ext == ExtJPG || ext == ExtJPEG || ext == ExtPNG || ext == ExtWebP {
-					jobs = append(jobs, Job{Path: path, YearDir: entry.Name()})
+				if IsSupportedImage(d.Name()) {
+					paths = append(paths, path)
 				}
 				return nil
 			},
@@ -377,11 +689,117 @@ func UpdatePhotosHandler(logChan chan<- string) {
 		if err != nil {
 			logMsg("Error walking directory %s: %v", yearDir, err)
 		}
+
+		if !pairRaw {
+			for _, path := range paths {
+				jobs = append(jobs, Job{Path: path, YearDir: entry.Name()})
+			}
+			continue
+		}
+
+		// Pair RAW+JPEG with the same basename in the same directory into one photo
+		rawByBase := make(map[string]string)
+		for _, path := range paths {
+			if IsRawFile(path) {
+				rawByBase[pairKey(path)] = path
+			}
+		}
+		paired := make(map[string]bool)
+		for _, path := range paths {
+			if IsRawFile(path) {
+				continue
+			}
+			job := Job{Path: path, YearDir: entry.Name()}
+			if rawPath, ok := rawByBase[pairKey(path)]; ok && !paired[rawPath] {
+				job.RawPath = rawPath
+				paired[rawPath] = true
+			}
+			jobs = append(jobs, job)
+		}
+		for _, rawPath := range rawByBase {
+			if !paired[rawPath] {
+				jobs = append(jobs, Job{Path: rawPath, YearDir: entry.Name()})
+			}
+		}
+	}
+
+	// Match new files to photos whose file is gone, before any worker reads the gear registry
+	paths := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		paths = append(paths, job.Path)
+	}
+	if !opts.UpsertOnly {
+		processor.renames = processor.matchRenames(paths)
+	}
+	if len(processor.renames) > 0 {
+		logMsg("🟢 Detected %d renamed or moved photos", len(processor.renames))
+		gearChanged := false
+		for filename, previous := range processor.renames {
+			if processor.Gear.RenameFile(previous.Filename, filename) {
+				gearChanged = true
+			}
+		}
+		if gearChanged && !opts.DryRun {
+			if err := processor.Gear.Save(GearRegistryPath(processor.RootDir)); err != nil {
+				logMsg("Warning: failed to save gear registry: %v", err)
+			} else {
+				logMsg("✓ Moved lens assignments of renamed photos")
+			}
+		}
+	}
+	recordChange := func(photo Photo, change PhotoChange) {
+		if change == ChangeRenamed {
+			result.Renamed = append(result.Renamed, FileRename{From: processor.renames[photo.Filename].Filename, To: photo.Filename})
+			return
+		}
+		result.record(photo.Filename, change)
+	}
+
+	// Incremental runs keep the entries of unaffected files as they are
+	var keptPhotos []Photo
+	if len(opts.Paths) > 0 {
+		affected := make(map[string]bool, len(opts.Paths))
+		for _, path := range opts.Paths {
+			affected[filepath.Clean(path)] = true
+		}
+		selected := jobs[:0]
+		for _, job := range jobs {
+			existing, ok := processor.ExistingPhotos[filepath.Base(job.Path)]
+			isAffected := affected[filepath.Clean(job.Path)] || (job.RawPath != "" && affected[filepath.Clean(job.RawPath)])
+			if !isAffected && (ok || opts.UpsertOnly) {
+				if ok {
+					keptPhotos = append(keptPhotos, existing)
+				}
+				continue
+			}
+			selected = append(selected, job)
+		}
+		if opts.UpsertOnly {
+			// Photos in other directories or whose files are gone keep their entries too
+			walked := make(map[string]bool, len(paths))
+			for _, path := range paths {
+				walked[filepath.Base(path)] = true
+			}
+			for filename, existing := range processor.ExistingPhotos {
+				if !walked[filename] {
+					keptPhotos = append(keptPhotos, existing)
+				}
+			}
+		}
+		logMsg("🟢 Incremental run: %d of %d photos affected", len(selected), len(selected)+len(keptPhotos))
+		jobs = selected
+		result.Skipped = len(keptPhotos)
 	}
 
 	// Worker Pool
+	type JobResult struct {
+		Photo  Photo
+		Change PhotoChange
+		File   string
+		Err    error
+	}
 	jobsChan := make(chan Job, len(jobs))
-	resultsChan := make(chan Photo, len(jobs))
+	resultsChan := make(chan JobResult, len(jobs))
 	var wg sync.WaitGroup
 
 	// Start workers
@@ -391,23 +809,25 @@ func UpdatePhotosHandler(logChan chan<- string) {
 	}
 
 	logMsg("🟢 Starting %d workers for %d photos...", numWorkers, len(jobs))
+	processor.progress.setTotal(len(jobs))
+	processor.progress.stage(StageProcess)
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for job := range jobsChan {
-				photo, err := processor.processPhoto(job.Path, job.YearDir)
-				if err != nil {
-					logMsg("Error processing %s: %v", filepath.Base(job.Path), err)
+				// Drain remaining jobs once cancelled so the pool stops quickly
+				if ctx.Err() != nil {
 					continue
 				}
-
-				// 全部清空
-				if false {
-				} else {
-					resultsChan <- photo
+				processor.progress.started(filepath.Base(job.Path))
+				photo, change, err := processor.processPhoto(ctx, job.Path, job.RawPath, job.YearDir)
+				if err != nil {
+					logMsg("Error processing %s: %v", filepath.Base(job.Path), err)
 				}
+				processor.progress.finished(change, err)
+				resultsChan <- JobResult{Photo: photo, Change: change, File: filepath.Base(job.Path), Err: err}
 			}
 		}()
 	}
@@ -424,166 +844,308 @@ func UpdatePhotosHandler(logChan chan<- string) {
 	logMsg("✓ 任务已经结束")
 	close(resultsChan)
 
-	// Collect results
-	var allPhotos []Photo
-	for photo := range resultsChan {
-		allPhotos = append(allPhotos, photo)
+	result.CacheHits, result.Hashed = processor.Index.Stats()
+	logMsg("✓ Index cache: %d hits, %d files hashed", result.CacheHits, result.Hashed)
+	// Only a complete run has seen every file and may prune the index
+	if err := processor.Index.Save(ctx.Err() == nil && len(opts.Paths) == 0); err != nil {
+		logMsg("Warning: failed to save file index: %v", err)
 	}
 
-	// Organize into albums
-	albumsMap := make(map[string][]Photo)
-	for _, p := range allPhotos {
-		albumsMap[p.Year] = append(albumsMap[p.Year], p)
+	// A cancelled run leaves photos.json, R2 and KV untouched so the manifest stays consistent
+	// with the objects it references. Photos uploaded so far are picked up by the next run.
+	if ctx.Err() != nil {
+		result.Cancelled = true
+		for jobResult := range resultsChan {
+			if jobResult.Err == nil {
+				recordChange(jobResult.Photo, jobResult.Change)
+			}
+		}
+		logMsg("⚠ Rebuild cancelled, photos.json was not modified (%d photos processed)",
+			len(result.Added)+len(result.Updated)+len(result.Unchanged))
+		return result, fmt.Errorf("rebuild cancelled: %w", ctx.Err())
 	}
+	// Deletions and publishing run to completion once started
+	ctx = context.WithoutCancel(ctx)
 
-	var newAlbums []YearAlbum
-	for year, photos := range albumsMap {
-		// Sort photos by date desc, then timestamp desc, then filename desc
-		sort.Slice(
-			photos, func(i, j int) bool {
-				if photos[i].Date != photos[j].Date {
-					return photos[i].Date > photos[j].Date
-				}
-				if photos[i].Timestamp != photos[j].Timestamp {
-					return photos[i].Timestamp > photos[j].Timestamp
+	// Collect results
+	allPhotos := keptPhotos
+	for jobResult := range resultsChan {
+		if jobResult.Err != nil {
+			result.Failed = append(result.Failed, FileError{File: jobResult.File, Error: jobResult.Err.Error()})
+			// Keep the previous entry of a photo that failed to update, instead of deleting it
+			if existing, ok := processor.ExistingPhotos[jobResult.File]; ok {
+				allPhotos = append(allPhotos, existing)
+			} else if previous, ok := processor.renames[jobResult.File]; ok {
+				allPhotos = append(allPhotos, previous) // Still under its old name, retried next run
+			}
+			continue
+		}
+		recordChange(jobResult.Photo, jobResult.Change)
+		result.processed = append(result.processed, jobResult.Photo)
+		allPhotos = append(allPhotos, jobResult.Photo)
+	}
+
+	// Hidden photos are kept in the private bucket, shown photos in the public one. The old
+	// objects are removed once photos.json references the copies.
+	var moves []*VisibilityMove
+	manifestWritten := false
+	if processor.R2Client != nil {
+		synced := allPhotos
+		if opts.UpsertOnly {
+			synced = allPhotos[len(keptPhotos):] // The processed photos
+		}
+		for i := range synced {
+			move, err := processor.moveVisibility(ctx, &synced[i], nil)
+			if err != nil {
+				logMsg("⚠ Warning: %s: %v", synced[i].Filename, err)
+			} else if move != nil {
+				moves = append(moves, move)
+			}
+		}
+		if len(moves) > 0 {
+			logMsg("✓ Moved the objects of %d hidden or shown photos", len(moves))
+		}
+		if !opts.DryRun {
+			defer func() {
+				if err := finishMoves(ctx, moves, manifestWritten, allPhotos); err != nil {
+					logMsg("⚠ Warning: %v", err)
 				}
-				return photos[i].Filename > photos[j].Filename
-			},
-		)
-		newAlbums = append(newAlbums, YearAlbum{Year: year, Photos: photos})
+			}()
+		}
 	}
 
-	// Sort albums by year desc
-	sort.Slice(
-		newAlbums, func(i, j int) bool {
-			return newAlbums[i].Year > newAlbums[j].Year
-		},
-	)
+	newAlbums := organizeAlbums(allPhotos)
 
 	// Identify deleted photos
-	if processor.R2Client != nil {
-		newPhotosMap := make(map[string]bool)
-		for _, p := range allPhotos {
-			newPhotosMap[p.Filename] = true
+	processor.progress.stage(StageCleanup)
+	newPhotosMap := make(map[string]bool)
+	for _, p := range allPhotos {
+		newPhotosMap[p.Filename] = true
+	}
+	renamedFrom := make(map[string]bool, len(result.Renamed))
+	for _, rename := range result.Renamed {
+		renamedFrom[rename.From] = true
+	}
+	for filename := range processor.ExistingPhotos {
+		if !newPhotosMap[filename] && !renamedFrom[filename] {
+			result.Deleted = append(result.Deleted, filename)
 		}
+	}
 
+	// A missing disk or emptied year folder must not wipe the gallery
+	if err := checkDeletions(len(result.Deleted), len(processor.ExistingPhotos)); err != nil {
+		if opts.DryRun {
+			logMsg("⚠ Warning: %v", err)
+		} else if !opts.AllowDeletions {
+			logMsg("❌ %v", err)
+			return result, err
+		}
+	}
+
+	// Deleted photos go to the trash with their metadata, the metadata of a renamed photo
+	// was already stored under its new name
+	trash := NewTrashStore(processor.RootDir)
+	batch := &TrashBatch{ID: job.ID, CreatedAt: job.StartTime}
+	for filename, existing := range processor.ExistingPhotos {
+		if newPhotosMap[filename] || opts.DryRun {
+			continue
+		}
+		if !renamedFrom[filename] {
+			processor.trashPhoto(batch, existing)
+		}
+		if err := processor.Metadata.Delete(filename); err != nil {
+			logMsg("Warning: failed to delete metadata of %s: %v", filename, err)
+		}
+	}
+
+	if processor.R2Client != nil {
 		var keysToDelete []string
-		for filename := range processor.ExistingPhotos {
+		for filename, existing := range processor.ExistingPhotos {
 			if !newPhotosMap[filename] {
-				logMsg("Marking for deletion: %s", filename)
-				// Add original and thumbnail to delete list
-				keysToDelete = append(
-					keysToDelete,
-					fmt.Sprintf(
-						"%s%s%s", processor.R2Client.Config.BasePrefix, processor.R2Client.Config.OriginalPrefix,
-						filename,
-					),
-					fmt.Sprintf(
-						"%s%s%s%s", processor.R2Client.Config.BasePrefix, processor.R2Client.Config.ThumbnailPrefix,
-						strings.TrimSuffix(filename, filepath.Ext(filename)), ExtWebP,
-					),
-				)
-			}
-		}
-
-		if len(keysToDelete) > 0 {
-			logMsg("🟢 Deleting %d orphaned files from R2...", len(keysToDelete))
-			if err := processor.R2Client.DeleteObjects(keysToDelete); err != nil {
-				logMsg("Error deleting objects: %v", err)
-			} else {
-				logMsg("✓ Successfully deleted orphaned files.")
+				if renamedFrom[filename] {
+					logMsg("Removing objects of renamed photo: %s", filename)
+				} else {
+					logMsg("Marking for deletion: %s", filename)
+				}
+				// Add original, thumbnail and RAW archive to delete list
+				keysToDelete = append(keysToDelete, StoredKeys(processor.R2Client.Config, existing).All()...)
+			}
+		}
+		// Objects of kept photos that moved, e.g. RAW archives of photos that lost their pairing
+		// or HEIC originals now served as JPEG
+		for _, p := range allPhotos {
+			existing, ok := processor.ExistingPhotos[p.Filename]
+			if !ok {
+				continue
+			}
+			current := make(map[string]bool)
+			for _, key := range KeysFor(processor.R2Client.Config, p).All() {
+				current[key] = true
+			}
+			for _, key := range KeysFor(processor.R2Client.Config, existing).All() {
+				if !current[key] {
+					keysToDelete = append(keysToDelete, key)
+				}
 			}
 		}
-	}
 
-	// Write output
-	jsonData, err := json.Marshal(newAlbums)
-	if err != nil {
-		logMsg("Error marshaling JSON: %v", err)
-		os.Exit(1)
+		// A rename may keep a key, e.g. when only the case of the extension changed
+		referenced := make(map[string]bool)
+		for _, p := range allPhotos {
+			for _, key := range KeysFor(processor.R2Client.Config, p).All() {
+				referenced[key] = true
+			}
+		}
+		orphans := keysToDelete[:0]
+		for _, key := range keysToDelete {
+			if !referenced[key] {
+				orphans = append(orphans, key)
+			}
+		}
+		keysToDelete = orphans
+
+		if opts.DryRun {
+			processor.planDeletes(ctx, keysToDelete)
+		} else if len(keysToDelete) > 0 {
+			logMsg("🟢 Moving %d orphaned files to the R2 trash...", len(keysToDelete))
+			if err := processor.moveToTrash(ctx, batch, keysToDelete); err != nil {
+				logMsg("Error moving objects to trash: %v", err)
+			} else if len(batch.Objects) > 0 {
+				logMsg("✓ Successfully moved orphaned files to %s%s/", processor.R2Client.Config.TrashPrefix, batch.ID)
+			}
+		}
 	}
 
-	outputFilePath := filepath.Join(processor.RootDir, OutputFile)
-
-	// Check if content changed (ignoring order if possible, but simple byte check is fast)
-	// Since we re-generated everything, byte comparison might fail if order changed slightly or timestamps
-	// But we should write anyway if we processed updates.
+	if !opts.DryRun {
+		sort.Slice(batch.Photos, func(i, j int) bool { return batch.Photos[i].Photo.Filename < batch.Photos[j].Photo.Filename })
+		if err := trash.Save(batch); err != nil {
+			logMsg("Warning: failed to record trash batch: %v", err)
+		} else if len(batch.Photos) > 0 {
+			logMsg("✓ %d deleted photos can be restored with: update restore --job %s", len(batch.Photos), batch.ID)
+		}
+		if !opts.UpsertOnly {
+			if purged, err := processor.purgeTrash(ctx, trash); err != nil {
+				logMsg("Warning: %v", err)
+			} else if purged > 0 {
+				logMsg("✓ Purged %d trash batches older than %s", purged, TrashRetention())
+			}
+		}
+	}
 
-	err = os.WriteFile(outputFilePath, jsonData, 0644)
+	// Write output
+	processor.progress.stage(StagePublish)
+	if !opts.DryRun {
+		unlock, err := LockManifest(processor.RootDir)
+		if err != nil {
+			logMsg("Error locking %s: %v", OutputFile, err)
+			return result, err
+		}
+		defer unlock()
+
+		// The admin server may have edited photos.json while the photos were processed
+		if current, err := os.ReadFile(ManifestPath(processor.RootDir)); err == nil && !bytes.Equal(current, existingContent) {
+			allPhotos = mergeConcurrentEdits(existingContent, current, allPhotos)
+			newAlbums = organizeAlbums(allPhotos)
+			existingContent = current
+			logMsg("🟢 photos.json was edited during the rebuild, keeping those edits")
+		}
+	}
+	jsonData, err := MarshalManifest(newAlbums)
 	if err != nil {
-		logMsg("Error writing output file: %v", err)
-		os.Exit(1)
+		logMsg("Error marshaling JSON: %v", err)
+		return result, err
 	}
 
-	// Create backup of existing file if it exists
-	if len(existingContent) > 0 {
-		backupPath := outputFilePath + "." + time.Now().Format("20060102_150405") + ".bak"
-		if err := os.WriteFile(backupPath, existingContent, 0644); err != nil {
-			logMsg("Warning: Could not create backup of %s: %v", outputFilePath, err)
-		} else {
-			logMsg("✓ Backup created: %s", backupPath)
-		}
+	if opts.DryRun {
+		finishMoves(ctx, moves, true, allPhotos)
+		result.sortLists()
+		processor.finishPlan(result, existingContent, jsonData)
+		result.ManifestChanged = result.Plan.BaseHash != result.Plan.ManifestHash
+		logMsg("✓ Dry run: %s", result.Summary())
+		return result, nil
 	}
 
-	// Check if content has changed
-	if JSONEqual(existingContent, jsonData) {
+	// Check if content has changed, a manifest of an older schema is rewritten regardless
+	schema, _ := ManifestSchemaOf(existingContent)
+	if schema == ManifestSchemaVersion && manifestHash(existingContent) == manifestHash(jsonData) {
+		manifestWritten = true
 		logMsg("✓ photos.json has not changed. Skipping backup, file write, and R2 upload.")
-		return
+		logMsg("✓ %s", result.Summary())
+		return result, nil
 	}
-
-	// Upload photos.json to R2
-	if processor.R2Client != nil {
-		jsonKey := fmt.Sprintf("%sphotos.json", processor.R2Client.Config.BasePrefix)
-		if err := processor.R2Client.UploadBytes(
-			jsonData, jsonKey, "application/json", "no-cache",
-		); err != nil {
-			logMsg("❌ Failed to upload photos.json: %v", err)
-		} else {
-			logMsg("✓ Uploaded photos.json to R2")
+	result.ManifestChanged = true
+	if schema > 0 && schema < ManifestSchemaVersion {
+		logMsg("🟢 Migrating photos.json from schema version %d to %d", schema, ManifestSchemaVersion)
+	}
+	if diff, err := DiffManifests(existingContent, jsonData); err == nil {
+		logMsg("🟢 photos.json: %s", diff.Summary())
+		for _, line := range diff.Lines(maxLoggedDiffLines) {
+			logMsg("  %s", line)
 		}
 	}
 
-	if storage.CFCli != nil {
-		key := fmt.Sprintf("cache:photos:%s", "jsonValue")
-		err := storage.CfKvSetValue(fmt.Sprintf("cache:photos:%s", "jsonValue"), string(jsonData), 86400)
-		if err != nil {
-			logMsg("❌Error setting value for %s: %v", outputFilePath, err)
-		} else {
-			logMsg("✓ Uploaded photos.json to KV[%s]", key)
-		}
+	// The previous version is backed up before photos.json is replaced
+	if err := WriteManifest(processor.RootDir, jsonData); err != nil {
+		logMsg("Error writing output file: %v", err)
+		return result, fmt.Errorf("error writing output file: %w", err)
 	}
+	manifestWritten = true
+
+	publishErrs := PublishManifest(ctx, processor.RootDir, processor.R2Client, jsonData, logMsg)
 
 	logMsg("Successfully updated photos.json with %d photos.", len(allPhotos))
+	logMsg("✓ %s", result.Summary())
+	return result, errors.Join(publishErrs...)
 }
 
-// JSONEqual compares two JSON byte slices for equality, ignoring whitespace and key order
-func JSONEqual(a, b []byte) bool {
-	var j1, j2 interface{}
-	if err := json.Unmarshal(a, &j1); err != nil {
-		return false
-	}
-	if err := json.Unmarshal(b, &j2); err != nil {
-		return false
+// organizeAlbums groups photos into year albums, newest year and newest photo first
+func organizeAlbums(allPhotos []Photo) []YearAlbum {
+	albumsMap := make(map[string][]Photo)
+	for _, p := range allPhotos {
+		albumsMap[p.Year] = append(albumsMap[p.Year], p)
 	}
 
-	// Re-marshal to ensure consistent formatting (e.g. sorted keys, no whitespace)
-	// Use MarshalIndent for readability in diffs
-	m1, err := json.MarshalIndent(j1, "", "  ")
-	if err != nil {
-		return false
-	}
-	m2, err := json.MarshalIndent(j2, "", "  ")
-	if err != nil {
-		return false
+	var newAlbums []YearAlbum
+	for year, photos := range albumsMap {
+		// Sort photos by capture instant desc, then filename desc
+		sort.Slice(
+			photos, func(i, j int) bool {
+				ti, tj := sortInstant(photos[i]), sortInstant(photos[j])
+				if ti != tj {
+					return ti > tj
+				}
+				return photos[i].Filename > photos[j].Filename
+			},
+		)
+		newAlbums = append(newAlbums, YearAlbum{Year: year, Photos: photos})
 	}
 
-	if !bytes.Equal(m1, m2) {
-		log.Println("⚠️ JSON content differs. Writing to files for comparison...")
-		_ = os.WriteFile("photos_old.json", m1, 0644)
-		_ = os.WriteFile("photos_new.json", m2, 0644)
-		log.Println("👉 Please compare 'photos_old.json' and 'photos_new.json' to see the differences.")
-		return false
+	// Sort albums by year desc
+	sort.Slice(
+		newAlbums, func(i, j int) bool {
+			return newAlbums[i].Year > newAlbums[j].Year
+		},
+	)
+	return newAlbums
+}
+
+// ProcessFiles processes the given image files and merges them into photos.json without touching
+// the other photos, which keep their entries even when their files are gone. It returns the resulting entries of the files; files that failed
+// are listed in the result.
+func ProcessFiles(ctx context.Context, logChan chan<- string, paths []string, trigger JobTrigger) ([]Photo, *RebuildResult, error) {
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no files to process")
 	}
+	result, err := Rebuild(ctx, logChan, UpdateOptions{Paths: paths, Trigger: trigger, UpsertOnly: true})
+	return result.processed, result, err
+}
 
-	return true
+// containsPath reports whether one of paths is in dir
+func containsPath(paths []string, dir string) bool {
+	for _, path := range paths {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,200 @@
+package photo
+
+import (
+	"sync"
+	"time"
+)
+
+// RebuildStage is a phase of a rebuild
+type RebuildStage string
+
+const (
+	StageScan    RebuildStage = "scan"    // Collecting image files
+	StageProcess RebuildStage = "process" // Hashing, converting and uploading photos
+	StageCleanup RebuildStage = "cleanup" // Removing deleted photos and orphaned objects
+	StagePublish RebuildStage = "publish" // Writing and uploading photos.json
+	StageDone    RebuildStage = "done"
+)
+
+// stageWeights is the share of the overall progress each stage accounts for, in percent
+var stageWeights = []struct {
+	stage  RebuildStage
+	weight int
+}{
+	{StageScan, 5},
+	{StageProcess, 85},
+	{StageCleanup, 5},
+	{StagePublish, 5},
+}
+
+// StageTiming is how long a finished or running stage took
+type StageTiming struct {
+	Stage    RebuildStage `json:"stage"`
+	Duration float64      `json:"duration_seconds"`
+}
+
+// ProgressEvent is a snapshot of a running rebuild
+type ProgressEvent struct {
+	Stage           RebuildStage  `json:"stage"`
+	Total           int           `json:"total"`     // Photos to process
+	Processed       int           `json:"processed"` // Finished photos, including skipped and failed ones
+	Skipped         int           `json:"skipped"`   // Unchanged photos
+	Failed          int           `json:"failed"`
+	UploadedBytes   int64         `json:"uploaded_bytes"`
+	CurrentFile     string        `json:"current_file,omitempty"` // Most recently started photo
+	ElapsedSeconds  float64       `json:"elapsed_seconds"`
+	ETASeconds      float64       `json:"eta_seconds"`       // Estimated remaining time of the process stage, 0 if unknown
+	PhotosPerSecond float64       `json:"photos_per_second"` // Throughput of the process stage
+	BytesPerSecond  float64       `json:"bytes_per_second"`  // Upload throughput of the process stage
+	Stages          []StageTiming `json:"stages"`
+}
+
+// Percent maps the event to an overall progress between 0 and 100
+func (e ProgressEvent) Percent() int {
+	if e.Stage == StageDone {
+		return 100
+	}
+	percent := 0
+	for _, w := range stageWeights {
+		if w.stage != e.Stage {
+			percent += w.weight
+			continue
+		}
+		if e.Stage == StageProcess && e.Total > 0 {
+			percent += w.weight * e.Processed / e.Total
+		}
+		break
+	}
+	return percent
+}
+
+// progressTracker accumulates progress and reports every change to a callback. A nil tracker ignores
+// every call, so processing code does not need to check whether progress is wanted.
+type progressTracker struct {
+	mu         sync.Mutex
+	event      ProgressEvent
+	start      time.Time
+	stageStart time.Time
+	processAt  time.Time // Start of the process stage, the base of throughput and ETA
+	report     func(ProgressEvent)
+}
+
+// newProgressTracker returns a tracker reporting to report, or nil if report is nil
+func newProgressTracker(report func(ProgressEvent)) *progressTracker {
+	if report == nil {
+		return nil
+	}
+	now := time.Now()
+	return &progressTracker{start: now, stageStart: now, report: report}
+}
+
+// stage finishes the current stage and starts the next one
+func (t *progressTracker) stage(stage RebuildStage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.closeStage(now)
+	t.event.Stage = stage
+	t.event.CurrentFile = ""
+	if stage == StageProcess {
+		t.processAt = now
+	}
+	if stage != StageDone {
+		t.stageStart = now
+		t.event.Stages = append(t.event.Stages, StageTiming{Stage: stage})
+	}
+	t.emit(now)
+}
+
+// setTotal sets the number of photos to process
+func (t *progressTracker) setTotal(total int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.Total = total
+	t.emit(time.Now())
+}
+
+// started records that a worker picked up file
+func (t *progressTracker) started(file string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.CurrentFile = file
+	t.emit(time.Now())
+}
+
+// finished records the outcome of a photo
+func (t *progressTracker) finished(change PhotoChange, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.Processed++
+	if err != nil {
+		t.event.Failed++
+	} else if change == ChangeUnchanged {
+		t.event.Skipped++
+	}
+	t.emit(time.Now())
+}
+
+// uploaded adds n bytes sent to R2
+func (t *progressTracker) uploaded(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.event.UploadedBytes += n
+	t.mu.Unlock()
+}
+
+// closeStage stores the duration of the running stage. Callers hold t.mu.
+func (t *progressTracker) closeStage(now time.Time) {
+	if n := len(t.event.Stages); n > 0 {
+		t.event.Stages[n-1].Duration = now.Sub(t.stageStart).Seconds()
+	}
+}
+
+// emit updates the derived fields and reports a copy of the event. Callers hold t.mu so
+// events are reported in order; the callback must not block.
+func (t *progressTracker) emit(now time.Time) {
+	t.closeStage(now)
+	t.event.ElapsedSeconds = now.Sub(t.start).Seconds()
+	if !t.processAt.IsZero() && t.event.Processed > 0 {
+		elapsed := now.Sub(t.processAt).Seconds()
+		if t.event.Stage != StageProcess {
+			elapsed = processDuration(t.event.Stages)
+		}
+		if elapsed > 0 {
+			t.event.PhotosPerSecond = float64(t.event.Processed) / elapsed
+			t.event.BytesPerSecond = float64(t.event.UploadedBytes) / elapsed
+		}
+		t.event.ETASeconds = 0
+		if t.event.Stage == StageProcess && t.event.PhotosPerSecond > 0 {
+			t.event.ETASeconds = float64(t.event.Total-t.event.Processed) / t.event.PhotosPerSecond
+		}
+	}
+
+	event := t.event
+	event.Stages = append([]StageTiming(nil), t.event.Stages...)
+	t.report(event)
+}
+
+// processDuration returns the recorded duration of the process stage
+func processDuration(stages []StageTiming) float64 {
+	for _, s := range stages {
+		if s.Stage == StageProcess {
+			return s.Duration
+		}
+	}
+	return 0
+}
@@ -0,0 +1,333 @@
+package photo
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+const (
+	// ShardDir is the directory of the published index and year shards, below the base prefix
+	ShardDir = "manifest/"
+	// ShardIndexFile is the name of the published index in ShardDir
+	ShardIndexFile = "index.json"
+
+	// KVIndexKey is the KV key of the published index
+	KVIndexKey = "cache:photos:index"
+	// KVShardPrefix is followed by the year in the KV keys of the shards
+	KVShardPrefix = "cache:photos:shard:"
+	// kvLegacyKey is the KV key of the unsharded manifest of earlier versions
+	kvLegacyKey = "cache:photos:jsonValue"
+
+	// shardCacheControl lets clients cache shards for good, a changed shard gets a new name
+	shardCacheControl = "public, max-age=31536000, immutable"
+)
+
+// PrivateManifestKey returns the R2 key of the complete photos.json, including hidden photos,
+// which is not reachable through the CDN
+func PrivateManifestKey(cfg storage.R2Config) string {
+	return cfg.PrivatePrefix + cfg.BasePrefix + "photos.json"
+}
+
+// legacyManifestKey returns the R2 key of the public photos.json of earlier versions
+func legacyManifestKey(cfg storage.R2Config) string {
+	return cfg.BasePrefix + "photos.json"
+}
+
+// ShardCover is the photo shown for a year in the index
+type ShardCover struct {
+	Filename  string `json:"filename"`
+	Thumbnail string `json:"thumbnail"`
+	Alt       string `json:"alt"`
+}
+
+// ShardEntry is a year in the index and the shard with its photos
+type ShardEntry struct {
+	Year   string      `json:"year"`
+	Photos int         `json:"photos"`
+	Cover  *ShardCover `json:"cover,omitempty"` // Best rated visible photo, newest first
+	Shard  string      `json:"shard"`           // Shard filename, relative to the index
+	Hash   string      `json:"hash"`            // MD5 of the shard content
+}
+
+// ShardIndex is the published index of photos.json, the photos are in one shard per year
+type ShardIndex struct {
+	SchemaVersion int            `json:"schema_version"`
+	GeneratedAt   time.Time      `json:"generated_at"`
+	Generator     string         `json:"generator"`
+	Counts        ManifestCounts `json:"counts"`
+	Years         []ShardEntry   `json:"years"`
+}
+
+// Shard is the published content of a year
+type Shard struct {
+	SchemaVersion int     `json:"schema_version"`
+	Year          string  `json:"year"`
+	Photos        []Photo `json:"photos"`
+}
+
+// BuildShards splits the visible photos of a manifest into its index and a shard per year,
+// returning the shard contents by shard filename. Hidden photos and years without visible photos
+// are left out. A shard's name and hash only change with its photos.
+func BuildShards(manifest *Manifest) (*ShardIndex, map[string][]byte, error) {
+	index := &ShardIndex{
+		SchemaVersion: manifest.SchemaVersion,
+		GeneratedAt:   manifest.GeneratedAt,
+		Generator:     manifest.Generator,
+		Years:         []ShardEntry{},
+	}
+	shards := make(map[string][]byte, len(manifest.Albums))
+	for _, album := range manifest.Albums {
+		var visible []Photo
+		for _, photo := range album.Photos {
+			if !photo.IsHidden {
+				visible = append(visible, photo)
+			}
+		}
+		if len(visible) == 0 {
+			continue
+		}
+		index.Counts.Albums++
+		index.Counts.Photos += len(visible)
+
+		data, err := json.Marshal(Shard{SchemaVersion: manifest.SchemaVersion, Year: album.Year, Photos: visible})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal shard %s: %w", album.Year, err)
+		}
+		hash := fmt.Sprintf("%x", md5.Sum(data))
+		entry := ShardEntry{
+			Year:   album.Year,
+			Photos: len(visible),
+			Shard:  fmt.Sprintf("%s.%s.json", album.Year, hash[:12]),
+			Hash:   hash,
+		}
+		coverRating := -1
+		for _, photo := range visible {
+			if photo.Rating > coverRating {
+				coverRating = photo.Rating
+				entry.Cover = &ShardCover{Filename: photo.Filename, Thumbnail: photo.Thumbnail, Alt: photo.Alt}
+			}
+		}
+		index.Years = append(index.Years, entry)
+		shards[entry.Shard] = data
+	}
+	return index, shards, nil
+}
+
+// publishState records what was last published, so unchanged shards are not uploaded again.
+// Removing the file republishes every shard.
+type publishState struct {
+	R2 map[string]string `json:"r2"` // Shard filename by year
+	KV map[string]string `json:"kv"` // Shard hash by year
+
+	// The unsharded manifest of earlier versions, which still listed hidden photos, was deleted.
+	// The R2 record was renamed when a wrong key was removed, so that the removal is retried.
+	R2LegacyRemoved bool `json:"r2_legacy_manifest_removed"`
+	KVLegacyRemoved bool `json:"kv_legacy_removed"`
+}
+
+// publishStatePath returns the path of the publish record
+func publishStatePath(rootDir string) string {
+	return filepath.Join(StateDirPath(rootDir), "published.json")
+}
+
+// loadPublishState reads the publish record, empty when there is none
+func loadPublishState(rootDir string) *publishState {
+	state := &publishState{}
+	if data, err := os.ReadFile(publishStatePath(rootDir)); err == nil {
+		json.Unmarshal(data, state)
+	}
+	if state.R2 == nil {
+		state.R2 = make(map[string]string)
+	}
+	if state.KV == nil {
+		state.KV = make(map[string]string)
+	}
+	return state
+}
+
+// save writes the publish record
+func (s *publishState) save(rootDir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(StateDirPath(rootDir), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(publishStatePath(rootDir), data, 0644)
+}
+
+// PublishManifest publishes the visible photos of photos.json to R2 and the KV cache as an index
+// and a shard per year, uploading only the shards that changed since the last publish. The
+// complete manifest goes to the private prefix, with a snapshot to roll back to. It returns what
+// failed; r2Client is nil in local mode.
+func PublishManifest(ctx context.Context, rootDir string, r2Client *storage.R2Client, jsonData []byte, logMsg func(string, ...interface{})) []error {
+	manifest, err := ParseManifest(jsonData)
+	if err != nil {
+		return []error{err}
+	}
+	index, shards, err := BuildShards(manifest)
+	if err != nil {
+		return []error{err}
+	}
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return []error{fmt.Errorf("failed to marshal shard index: %w", err)}
+	}
+
+	state := loadPublishState(rootDir)
+	var publishErrs []error
+	if r2Client != nil {
+		if err := publishShardsR2(ctx, r2Client, index, indexData, shards, state, logMsg); err != nil {
+			logMsg("❌ Failed to upload photos to R2: %v", err)
+			publishErrs = append(publishErrs, err)
+		} else if err := publishPrivateManifest(ctx, r2Client, jsonData, state, logMsg); err != nil {
+			logMsg("❌ Failed to upload photos to R2: %v", err)
+			publishErrs = append(publishErrs, err)
+		} else if err := uploadSnapshot(ctx, r2Client, jsonData, time.Now()); err != nil {
+			// A missing snapshot only limits the rollback, it does not fail the publish
+			logMsg("⚠ Warning: %v", err)
+		}
+	}
+
+	if storage.CFCli != nil {
+		if err := publishShardsKV(ctx, index, indexData, shards, state, logMsg); err != nil {
+			logMsg("❌ Failed to update KV: %v", err)
+			publishErrs = append(publishErrs, err)
+		}
+	}
+
+	if err := state.save(rootDir); err != nil {
+		logMsg("⚠ Warning: failed to record published shards: %v", err)
+	}
+	return publishErrs
+}
+
+// publishShardsR2 uploads the changed shards and then the index, and removes the shards that
+// neither the new nor the previous index references. Clients that loaded the previous index can
+// still fetch its shards.
+func publishShardsR2(ctx context.Context, r2Client *storage.R2Client, index *ShardIndex, indexData []byte, shards map[string][]byte, state *publishState, logMsg func(string, ...interface{})) error {
+	prefix := r2Client.Config.BasePrefix + ShardDir
+	uploaded := 0
+	for _, entry := range index.Years {
+		if state.R2[entry.Year] == entry.Shard {
+			continue
+		}
+		if err := r2Client.UploadBytes(ctx, shards[entry.Shard], prefix+entry.Shard, "application/json", shardCacheControl); err != nil {
+			return fmt.Errorf("failed to upload shard %s: %w", entry.Shard, err)
+		}
+		uploaded++
+	}
+	if err := r2Client.UploadBytes(ctx, indexData, prefix+ShardIndexFile, "application/json", "no-cache"); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", ShardIndexFile, err)
+	}
+	logMsg("✓ Uploaded photos index and %d of %d shards to R2", uploaded, len(index.Years))
+
+	keep := map[string]bool{ShardIndexFile: true}
+	for _, name := range state.R2 {
+		keep[name] = true
+	}
+	state.R2 = make(map[string]string, len(index.Years))
+	for _, entry := range index.Years {
+		state.R2[entry.Year] = entry.Shard
+		keep[entry.Shard] = true
+	}
+
+	objects, err := r2Client.ListObjects(ctx, prefix)
+	if err != nil {
+		logMsg("⚠ Warning: failed to list old shards: %v", err)
+		return nil
+	}
+	var stale []string
+	for _, object := range objects {
+		if !keep[object.Key[len(prefix):]] {
+			stale = append(stale, object.Key)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		if err := r2Client.DeleteObjects(ctx, stale); err != nil {
+			logMsg("⚠ Warning: failed to remove old shards: %v", err)
+		} else {
+			logMsg("✓ Removed %d old shards from R2", len(stale))
+		}
+	}
+	return nil
+}
+
+// publishPrivateManifest uploads the complete photos.json below the private prefix, and removes
+// the public photos.json of earlier versions that still listed hidden photos
+func publishPrivateManifest(ctx context.Context, r2Client *storage.R2Client, jsonData []byte, state *publishState, logMsg func(string, ...interface{})) error {
+	key := PrivateManifestKey(r2Client.Config)
+	if !r2Client.Config.HasPrivateBucket() {
+		logMsg("⚠ Warning: %v, %s is not uploaded", storage.ErrNoPrivateBucket, key)
+	} else if err := r2Client.UploadBytes(ctx, jsonData, key, "application/json", "private, no-store"); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	} else {
+		logMsg("✓ Uploaded the private %s to R2", key)
+	}
+
+	if !state.R2LegacyRemoved {
+		// Deleting a missing key succeeds, so the removal is only recorded once the key is gone
+		legacy := legacyManifestKey(r2Client.Config)
+		if err := r2Client.DeleteObjects(ctx, []string{legacy}); err != nil {
+			logMsg("⚠ Warning: failed to remove %s: %v", legacy, err)
+		} else if r2Client.CheckFileExists(ctx, legacy) {
+			logMsg("⚠ Warning: %s is still in R2 after removing it", legacy)
+		} else {
+			state.R2LegacyRemoved = true
+			logMsg("✓ Removed the public %s from R2", legacy)
+		}
+	}
+	return nil
+}
+
+// publishShardsKV stores the changed shards and the index in KV without expiry, and removes the
+// shards of years that no longer have photos
+func publishShardsKV(ctx context.Context, index *ShardIndex, indexData []byte, shards map[string][]byte, state *publishState, logMsg func(string, ...interface{})) error {
+	years := make(map[string]bool, len(index.Years))
+	updated := 0
+	for _, entry := range index.Years {
+		years[entry.Year] = true
+		if state.KV[entry.Year] == entry.Hash {
+			continue
+		}
+		if err := storage.CfKvSetValue(ctx, KVShardPrefix+entry.Year, string(shards[entry.Shard]), 0); err != nil {
+			return fmt.Errorf("failed to update KV %s%s: %w", KVShardPrefix, entry.Year, err)
+		}
+		state.KV[entry.Year] = entry.Hash
+		updated++
+	}
+	if err := storage.CfKvSetValue(ctx, KVIndexKey, string(indexData), 0); err != nil {
+		return fmt.Errorf("failed to update KV %s: %w", KVIndexKey, err)
+	}
+	logMsg("✓ Uploaded photos index and %d of %d shards to KV", updated, len(index.Years))
+
+	if !state.KVLegacyRemoved {
+		if err := storage.CfKvDeleteValue(ctx, kvLegacyKey); err != nil {
+			logMsg("⚠ Warning: failed to remove KV %s: %v", kvLegacyKey, err)
+		} else {
+			state.KVLegacyRemoved = true
+		}
+	}
+
+	for year := range state.KV {
+		if years[year] {
+			continue
+		}
+		if err := storage.CfKvDeleteValue(ctx, KVShardPrefix+year); err != nil {
+			logMsg("⚠ Warning: failed to remove KV %s%s: %v", KVShardPrefix, year, err)
+			continue
+		}
+		delete(state.KV, year)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package photo
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildShards(t *testing.T) {
+	tests := []struct {
+		name       string
+		albums     []YearAlbum
+		wantYears  map[string][]string // Published filenames by year
+		wantCounts ManifestCounts
+		wantCovers map[string]string // Cover filename by year
+	}{
+		{
+			name:       "empty",
+			wantYears:  map[string][]string{},
+			wantCounts: ManifestCounts{},
+			wantCovers: map[string]string{},
+		},
+		{
+			name: "hidden photos left out",
+			albums: []YearAlbum{
+				{Year: "2024", Photos: []Photo{{Filename: "a.jpg"}, {Filename: "b.jpg", IsHidden: true}, {Filename: "c.jpg"}}},
+			},
+			wantYears:  map[string][]string{"2024": {"a.jpg", "c.jpg"}},
+			wantCounts: ManifestCounts{Albums: 1, Photos: 2},
+			wantCovers: map[string]string{"2024": "a.jpg"},
+		},
+		{
+			name: "year with only hidden photos left out",
+			albums: []YearAlbum{
+				{Year: "2024", Photos: []Photo{{Filename: "a.jpg"}}},
+				{Year: "2023", Photos: []Photo{{Filename: "b.jpg", IsHidden: true}}},
+				{Year: "2022", Photos: []Photo{}},
+			},
+			wantYears:  map[string][]string{"2024": {"a.jpg"}},
+			wantCounts: ManifestCounts{Albums: 1, Photos: 1},
+			wantCovers: map[string]string{"2024": "a.jpg"},
+		},
+		{
+			name: "cover is the best rated visible photo",
+			albums: []YearAlbum{
+				{Year: "2024", Photos: []Photo{
+					{Filename: "a.jpg", Rating: 3},
+					{Filename: "b.jpg", Rating: 5, IsHidden: true},
+					{Filename: "c.jpg", Rating: 4},
+					{Filename: "d.jpg", Rating: 4},
+				}},
+			},
+			wantYears:  map[string][]string{"2024": {"a.jpg", "c.jpg", "d.jpg"}},
+			wantCounts: ManifestCounts{Albums: 1, Photos: 3},
+			wantCovers: map[string]string{"2024": "c.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, shards, err := BuildShards(NewManifest(tt.albums))
+			if err != nil {
+				t.Fatalf("BuildShards() error = %v", err)
+			}
+			if index.Counts != tt.wantCounts {
+				t.Errorf("Counts = %+v, want %+v", index.Counts, tt.wantCounts)
+			}
+			if len(shards) != len(index.Years) {
+				t.Errorf("%d shards for %d years", len(shards), len(index.Years))
+			}
+
+			years := make(map[string][]string)
+			covers := make(map[string]string)
+			for _, entry := range index.Years {
+				var shard Shard
+				if err := json.Unmarshal(shards[entry.Shard], &shard); err != nil {
+					t.Fatalf("shard %s: %v", entry.Shard, err)
+				}
+				if shard.Year != entry.Year || len(shard.Photos) != entry.Photos {
+					t.Errorf("shard %s has %d photos of %s, index lists %d of %s",
+						entry.Shard, len(shard.Photos), shard.Year, entry.Photos, entry.Year)
+				}
+				for _, photo := range shard.Photos {
+					years[entry.Year] = append(years[entry.Year], photo.Filename)
+				}
+				if entry.Cover != nil {
+					covers[entry.Year] = entry.Cover.Filename
+				}
+			}
+			if !reflect.DeepEqual(years, tt.wantYears) {
+				t.Errorf("published photos = %v, want %v", years, tt.wantYears)
+			}
+			if !reflect.DeepEqual(covers, tt.wantCovers) {
+				t.Errorf("covers = %v, want %v", covers, tt.wantCovers)
+			}
+		})
+	}
+}
+
+func TestBuildShardsNames(t *testing.T) {
+	albums := []YearAlbum{{Year: "2024", Photos: []Photo{{Filename: "a.jpg"}, {Filename: "b.jpg", IsHidden: true}}}}
+	shardName := func(manifest *Manifest) string {
+		index, _, err := BuildShards(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return index.Years[0].Shard
+	}
+
+	base := NewManifest(albums)
+	tests := []struct {
+		name     string
+		manifest func() *Manifest
+		wantSame bool
+	}{
+		{
+			name: "generated later",
+			manifest: func() *Manifest {
+				m := NewManifest(albums)
+				m.GeneratedAt = base.GeneratedAt.Add(time.Hour)
+				return m
+			},
+			wantSame: true,
+		},
+		{
+			name: "hidden photo edited",
+			manifest: func() *Manifest {
+				edited := []YearAlbum{{Year: "2024", Photos: []Photo{{Filename: "a.jpg"}, {Filename: "b.jpg", IsHidden: true, Alt: "x"}}}}
+				return NewManifest(edited)
+			},
+			wantSame: true,
+		},
+		{
+			name: "visible photo edited",
+			manifest: func() *Manifest {
+				edited := []YearAlbum{{Year: "2024", Photos: []Photo{{Filename: "a.jpg", Alt: "x"}, {Filename: "b.jpg", IsHidden: true}}}}
+				return NewManifest(edited)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := shardName(tt.manifest()) == shardName(base); same != tt.wantSame {
+				t.Errorf("same shard name = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestPublishPrivateManifest(t *testing.T) {
+	tests := []struct {
+		name        string
+		removed     bool // The legacy manifest was removed before
+		stored      bool // The legacy manifest is in R2
+		kept        bool // Deleting the legacy manifest reports success but keeps it
+		noPrivate   bool // No private bucket is configured
+		wantRemoved bool
+		wantStored  bool
+	}{
+		{name: "legacy manifest removed", stored: true, wantRemoved: true},
+		{name: "no legacy manifest", wantRemoved: true},
+		{name: "legacy manifest still there", stored: true, kept: true, wantStored: true},
+		{name: "removed before", removed: true, stored: true, wantRemoved: true, wantStored: true},
+		{name: "no private bucket", stored: true, noPrivate: true, wantRemoved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeR2(t)
+			legacy := "photos/photos.json"
+			if tt.stored {
+				fake.put(testBucket, legacy)
+			}
+			fake.keep[legacy] = tt.kept
+			if tt.noPrivate {
+				client.Config.PrivateBucket = ""
+			}
+			state := &publishState{R2LegacyRemoved: tt.removed}
+			logMsg := func(string, ...interface{}) {}
+
+			if err := publishPrivateManifest(context.Background(), client, []byte("{}"), state, logMsg); err != nil {
+				t.Fatalf("publishPrivateManifest() error = %v", err)
+			}
+			if got := fake.has(testPrivateBucket, "private/photos/photos.json"); got == tt.noPrivate {
+				t.Errorf("private/photos/photos.json in the private bucket = %v, want %v", got, !tt.noPrivate)
+			}
+			if fake.has(testBucket, "private/photos/photos.json") {
+				t.Error("private/photos/photos.json was uploaded to the public bucket")
+			}
+			if got := fake.has(testBucket, legacy); got != tt.wantStored {
+				t.Errorf("%s exists = %v, want %v", legacy, got, tt.wantStored)
+			}
+			if state.R2LegacyRemoved != tt.wantRemoved {
+				t.Errorf("R2LegacyRemoved = %v, want %v", state.R2LegacyRemoved, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestPublishStateRetriesLegacyRemoval(t *testing.T) {
+	// Earlier versions recorded the removal of a wrong key
+	var state publishState
+	if err := json.Unmarshal([]byte(`{"r2_legacy_removed": true, "kv_legacy_removed": true}`), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.R2LegacyRemoved || !state.KVLegacyRemoved {
+		t.Errorf("R2LegacyRemoved = %v, KVLegacyRemoved = %v, want false and true", state.R2LegacyRemoved, state.KVLegacyRemoved)
+	}
+}
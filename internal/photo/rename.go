@@ -0,0 +1,74 @@
+package photo
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// FileRename is a photo whose file was renamed or moved to another name
+type FileRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// sourceKey returns the path of a file relative to the images directory, as stored in Source
+func (p *PhotoProcessor) sourceKey(path string) string {
+	if rel, err := filepath.Rel(p.ImgDirPath, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.Base(path)
+}
+
+// matchRenames pairs files without an entry with photos whose file is gone and that had the same
+// content, keyed by the new filename. Each vanished photo is claimed once, so of two copies of a
+// renamed file only the first keeps its entry. paths are all image files of the run.
+func (p *PhotoProcessor) matchRenames(paths []string) map[string]Photo {
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[filepath.Base(path)] = true
+	}
+	vanished := make(map[string]Photo)
+	for filename, existing := range p.ExistingPhotos {
+		if !present[filename] && existing.Hash != "" {
+			vanished[existing.Hash] = existing
+		}
+	}
+	if len(vanished) == 0 {
+		return nil
+	}
+
+	renames := make(map[string]Photo)
+	for _, path := range paths {
+		filename := filepath.Base(path)
+		if _, ok := p.ExistingPhotos[filename]; ok {
+			continue
+		}
+		// The hash lands in the index, so processing the file does not read it again
+		hash, err := p.Index.Hash(path, p.sourceKey(path), false)
+		if err != nil {
+			continue // Reported when the file is processed
+		}
+		if previous, ok := vanished[hash]; ok {
+			renames[filename] = previous
+			delete(vanished, hash)
+		}
+	}
+	return renames
+}
+
+// copyObjects copies the R2 objects of a renamed photo to the keys of its new name. The old
+// objects are removed with the other orphans once the manifest no longer references them.
+func (p *PhotoProcessor) copyObjects(ctx context.Context, previous, renamed Photo) (ObjectKeys, error) {
+	from := StoredKeys(p.R2Client.Config, previous)
+	to := KeysFor(p.R2Client.Config, renamed)
+	pairs := [][2]string{{from.Original, to.Original}, {from.Thumbnail, to.Thumbnail}, {from.Raw, to.Raw}}
+	for _, pair := range pairs {
+		if pair[0] == "" || pair[0] == pair[1] {
+			continue
+		}
+		if err := p.copyObject(ctx, pair[0], pair[1], renamed.Filename); err != nil {
+			return to, err
+		}
+	}
+	return to, nil
+}
@@ -0,0 +1,85 @@
+package photo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PhotoChange is what a rebuild did to a single photo
+type PhotoChange string
+
+const (
+	ChangeAdded     PhotoChange = "added"
+	ChangeUpdated   PhotoChange = "updated"
+	ChangeUnchanged PhotoChange = "unchanged"
+	ChangeRenamed   PhotoChange = "renamed"
+)
+
+// FileError is a file that could not be processed
+type FileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// RebuildResult summarizes a rebuild
+type RebuildResult struct {
+	JobID           string       `json:"job_id"`
+	Added           []string     `json:"added"`
+	Updated         []string     `json:"updated"`
+	Unchanged       []string     `json:"unchanged"`
+	Deleted         []string     `json:"deleted"`
+	Renamed         []FileRename `json:"renamed"` // Photos matched to their previous entry by content
+	Failed          []FileError  `json:"failed"`
+	Skipped         int          `json:"skipped,omitempty"` // Photos outside the paths of an incremental run
+	CacheHits       int          `json:"cache_hits"`        // Files whose hash came from the index
+	Hashed          int          `json:"hashed"`            // Files that were read and hashed
+	ManifestChanged bool         `json:"manifest_changed"`  // photos.json differs from the previous one
+	Cancelled       bool         `json:"cancelled"`         // The rebuild was cancelled before photos.json was written
+	Plan            *Plan        `json:"plan,omitempty"`    // What a dry run would do
+	StartTime       time.Time    `json:"start_time"`
+	EndTime         time.Time    `json:"end_time"`
+
+	processed []Photo // Entries of the photos that were processed, for ProcessFiles
+}
+
+// record adds a processed photo to the result
+func (r *RebuildResult) record(filename string, change PhotoChange) {
+	switch change {
+	case ChangeAdded:
+		r.Added = append(r.Added, filename)
+	case ChangeUpdated:
+		r.Updated = append(r.Updated, filename)
+	default:
+		r.Unchanged = append(r.Unchanged, filename)
+	}
+}
+
+// sortLists orders every list by filename so results are stable between runs
+func (r *RebuildResult) sortLists() {
+	for _, list := range [][]string{r.Added, r.Updated, r.Unchanged, r.Deleted} {
+		sort.Strings(list)
+	}
+	sort.Slice(r.Failed, func(i, j int) bool { return r.Failed[i].File < r.Failed[j].File })
+	sort.Slice(r.Renamed, func(i, j int) bool { return r.Renamed[i].To < r.Renamed[j].To })
+}
+
+// HasFailures reports whether any file failed to process
+func (r *RebuildResult) HasFailures() bool {
+	return len(r.Failed) > 0
+}
+
+// Summary returns a one line description of the result
+func (r *RebuildResult) Summary() string {
+	summary := fmt.Sprintf(
+		"%d added, %d updated, %d unchanged, %d deleted, %d failed",
+		len(r.Added), len(r.Updated), len(r.Unchanged), len(r.Deleted), len(r.Failed),
+	)
+	if len(r.Renamed) > 0 {
+		summary += fmt.Sprintf(", %d renamed", len(r.Renamed))
+	}
+	if r.Skipped > 0 {
+		summary += fmt.Sprintf(", %d skipped", r.Skipped)
+	}
+	return summary
+}
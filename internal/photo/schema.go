@@ -0,0 +1,200 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// ManifestSchemaVersion is the photos.json schema written by this code. Bump it together with a
+// migration from the previous version.
+const ManifestSchemaVersion = 2
+
+// ErrManifestTooNew is returned for a photos.json written with a newer schema than this code
+// understands; it is never overwritten, so fields this code does not know are not lost
+var ErrManifestTooNew = errors.New("photos.json has a newer schema version, update this program first")
+
+// ManifestCounts summarizes the photos of a manifest
+type ManifestCounts struct {
+	Albums int `json:"albums"`
+	Photos int `json:"photos"`
+	Hidden int `json:"hidden"`
+}
+
+// Manifest is the content of photos.json
+type Manifest struct {
+	SchemaVersion int            `json:"schema_version"`
+	GeneratedAt   time.Time      `json:"generated_at"`
+	Generator     string         `json:"generator"` // Program and build that wrote the manifest
+	Counts        ManifestCounts `json:"counts"`
+	Albums        []YearAlbum    `json:"albums"`
+}
+
+// manifestMigrations upgrade a manifest document by one schema version, keyed by the version
+// they upgrade from
+var manifestMigrations = map[int]func(doc map[string]interface{}) error{
+	1: migrateManifestV1,
+}
+
+// NewManifest returns a manifest of the current schema with the given albums
+func NewManifest(albums []YearAlbum) *Manifest {
+	if albums == nil {
+		albums = []YearAlbum{}
+	}
+	counts := ManifestCounts{Albums: len(albums)}
+	for _, album := range albums {
+		counts.Photos += len(album.Photos)
+		for _, photo := range album.Photos {
+			if photo.IsHidden {
+				counts.Hidden++
+			}
+		}
+	}
+	return &Manifest{
+		SchemaVersion: ManifestSchemaVersion,
+		GeneratedAt:   time.Now().UTC().Truncate(time.Second),
+		Generator:     generator(),
+		Counts:        counts,
+		Albums:        albums,
+	}
+}
+
+// MarshalManifest returns photos.json for the given albums
+func MarshalManifest(albums []YearAlbum) ([]byte, error) {
+	data, err := json.Marshal(NewManifest(albums))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", OutputFile, err)
+	}
+	return data, nil
+}
+
+// ParseManifest parses photos.json of any schema up to the current one, migrating older
+// manifests. Empty content is an empty manifest.
+func ParseManifest(content []byte) (*Manifest, error) {
+	doc, err := migrateManifest(content)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", OutputFile, err)
+	}
+	if manifest.Albums == nil {
+		manifest.Albums = []YearAlbum{}
+	}
+	return &manifest, nil
+}
+
+// ManifestSchemaOf returns the schema version of photos.json, 1 for the bare album array that
+// predates versioning and 0 for empty content
+func ManifestSchemaOf(content []byte) (int, error) {
+	content = bytes.TrimSpace(content)
+	switch {
+	case len(content) == 0:
+		return 0, nil
+	case content[0] == '[':
+		return 1, nil
+	}
+	var envelope struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", OutputFile, err)
+	}
+	if envelope.SchemaVersion < 2 {
+		return 0, fmt.Errorf("%s has an invalid schema version %d", OutputFile, envelope.SchemaVersion)
+	}
+	return envelope.SchemaVersion, nil
+}
+
+// migrateManifest returns a manifest as a JSON document of the current schema
+func migrateManifest(content []byte) (map[string]interface{}, error) {
+	version, err := ManifestSchemaOf(content)
+	if err != nil {
+		return nil, err
+	}
+	if version > ManifestSchemaVersion {
+		return nil, fmt.Errorf("%w: version %d, this program understands up to %d", ErrManifestTooNew, version, ManifestSchemaVersion)
+	}
+
+	var doc map[string]interface{}
+	switch version {
+	case 0:
+		return map[string]interface{}{"schema_version": ManifestSchemaVersion, "albums": []interface{}{}}, nil
+	case 1:
+		var albums []interface{}
+		if err := json.Unmarshal(content, &albums); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", OutputFile, err)
+		}
+		doc = map[string]interface{}{"schema_version": 1, "albums": albums}
+	default:
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", OutputFile, err)
+		}
+	}
+
+	for ; version < ManifestSchemaVersion; version++ {
+		migrate, ok := manifestMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration of %s from schema version %d", OutputFile, version)
+		}
+		if err := migrate(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate %s from schema version %d: %w", OutputFile, version, err)
+		}
+		doc["schema_version"] = version + 1
+	}
+	return doc, nil
+}
+
+// manifestDocPhotos returns the photo objects of a manifest document
+func manifestDocPhotos(doc map[string]interface{}) []map[string]interface{} {
+	var photos []map[string]interface{}
+	albums, _ := doc["albums"].([]interface{})
+	for _, album := range albums {
+		album, _ := album.(map[string]interface{})
+		entries, _ := album["photos"].([]interface{})
+		for _, entry := range entries {
+			if photo, ok := entry.(map[string]interface{}); ok {
+				photos = append(photos, photo)
+			}
+		}
+	}
+	return photos
+}
+
+// migrateManifestV1 renames Subject to subject, matching the casing of the other fields. The
+// bare album array was already wrapped in the envelope when it was read.
+func migrateManifestV1(doc map[string]interface{}) error {
+	for _, photo := range manifestDocPhotos(doc) {
+		if subject, ok := photo["Subject"]; ok {
+			photo["subject"] = subject
+			delete(photo, "Subject")
+		}
+	}
+	return nil
+}
+
+// generator identifies the program and build writing a manifest, e.g. "update-photos 6ec9c16"
+func generator() string {
+	name, version := filepath.Base(os.Args[0]), "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+				version = setting.Value[:7]
+			}
+		}
+	}
+	return name + " " + version
+}
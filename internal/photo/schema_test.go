@@ -0,0 +1,177 @@
+package photo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantErr  error // Matched with errors.Is, nil for any error when wantFail is set
+		wantFail bool
+		want     []YearAlbum
+	}{
+		{
+			name:    "empty",
+			content: "  \n",
+			want:    []YearAlbum{},
+		},
+		{
+			name:    "v1 bare array",
+			content: `[{"year":"2024","photos":[{"filename":"a.jpg","Subject":["sea","sky"],"rating":3}]}]`,
+			want: []YearAlbum{
+				{Year: "2024", Photos: []Photo{{Filename: "a.jpg", Subject: []string{"sea", "sky"}, Rating: 3}}},
+			},
+		},
+		{
+			name:    "v1 without Subject",
+			content: `[{"year":"2023","photos":[{"filename":"b.jpg"}]},{"year":"2022","photos":[]}]`,
+			want: []YearAlbum{
+				{Year: "2023", Photos: []Photo{{Filename: "b.jpg"}}},
+				{Year: "2022", Photos: []Photo{}},
+			},
+		},
+		{
+			name:    "current schema",
+			content: `{"schema_version":2,"albums":[{"year":"2025","photos":[{"filename":"c.jpg","subject":["city"],"is_hidden":true}]}]}`,
+			want: []YearAlbum{
+				{Year: "2025", Photos: []Photo{{Filename: "c.jpg", Subject: []string{"city"}, IsHidden: true}}},
+			},
+		},
+		{
+			name:    "newer schema",
+			content: `{"schema_version":3,"albums":[]}`,
+			wantErr: ErrManifestTooNew,
+		},
+		{
+			name:     "envelope without schema version",
+			content:  `{"albums":[]}`,
+			wantFail: true,
+		},
+		{
+			name:     "invalid JSON",
+			content:  `{"schema_version":`,
+			wantFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := ParseManifest([]byte(tt.content))
+			if tt.wantErr != nil || tt.wantFail {
+				if err == nil {
+					t.Fatalf("ParseManifest() succeeded, want an error")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseManifest() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseManifest() error = %v", err)
+			}
+			if manifest.SchemaVersion != ManifestSchemaVersion {
+				t.Errorf("SchemaVersion = %d, want %d", manifest.SchemaVersion, ManifestSchemaVersion)
+			}
+			if !reflect.DeepEqual(manifest.Albums, tt.want) {
+				t.Errorf("Albums = %+v, want %+v", manifest.Albums, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateManifestV1(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []map[string]interface{} // Photo objects after the migration
+	}{
+		{
+			name:    "Subject renamed",
+			content: `[{"year":"2024","photos":[{"filename":"a.jpg","Subject":["sea"]}]}]`,
+			want:    []map[string]interface{}{{"filename": "a.jpg", "subject": []interface{}{"sea"}}},
+		},
+		{
+			name:    "no Subject",
+			content: `[{"year":"2024","photos":[{"filename":"a.jpg","alt":"x"}]}]`,
+			want:    []map[string]interface{}{{"filename": "a.jpg", "alt": "x"}},
+		},
+		{
+			name:    "empty album",
+			content: `[{"year":"2024","photos":[]}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := migrateManifest([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("migrateManifest() error = %v", err)
+			}
+			if doc["schema_version"] != ManifestSchemaVersion {
+				t.Errorf("schema_version = %v, want %d", doc["schema_version"], ManifestSchemaVersion)
+			}
+			if got := manifestDocPhotos(doc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("photos = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteManifestSchema(t *testing.T) {
+	current, err := MarshalManifest([]YearAlbum{{Year: "2024", Photos: []Photo{{Filename: "a.jpg"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		existing string // Current photos.json, none when empty
+		data     string
+		wantErr  error
+		wantFail bool
+	}{
+		{name: "new file", data: string(current)},
+		{name: "over an older schema", existing: `[]`, data: string(current)},
+		{name: "over a newer schema", existing: `{"schema_version":3,"albums":[]}`, data: string(current), wantErr: ErrManifestTooNew},
+		{name: "older schema", data: `[]`, wantFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PHOTO_STATE_DIR", "")
+			rootDir := t.TempDir()
+			path := ManifestPath(rootDir)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := WriteManifest(rootDir, []byte(tt.data))
+			if tt.wantErr == nil && !tt.wantFail {
+				if err != nil {
+					t.Fatalf("WriteManifest() error = %v", err)
+				}
+				if written, _ := os.ReadFile(path); string(written) != tt.data {
+					t.Errorf("photos.json = %s, want %s", written, tt.data)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("WriteManifest() succeeded, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WriteManifest() error = %v, want %v", err, tt.wantErr)
+			}
+			// The refused file is left as it was
+			if written, _ := os.ReadFile(path); string(written) != tt.existing {
+				t.Errorf("photos.json = %s, want %s", written, tt.existing)
+			}
+		})
+	}
+}
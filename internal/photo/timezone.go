@@ -0,0 +1,338 @@
+package photo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exifDateLayout is the layout EXIF uses for DateTimeOriginal / CreateDate
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// TimezoneConfigFile is the default location of the capture time zone configuration
+const TimezoneConfigFile = "config/timezones.json"
+
+// Capture time sources, from most to least reliable
+const (
+	TimeSourceOffset  = "offset"  // OffsetTimeOriginal written by the camera
+	TimeSourceGPS     = "gps"     // Derived from the GPS UTC timestamp
+	TimeSourceTrip    = "trip"    // Configured trip zone
+	TimeSourceCamera  = "camera"  // Configured per-camera zone
+	TimeSourceDefault = "default" // Configured default zone
+	TimeSourceUTC     = "utc"     // Nothing known, camera clock treated as UTC
+)
+
+// TimezoneTrip assigns a zone to photos taken within a date range
+type TimezoneTrip struct {
+	Name  string `json:"name"`
+	Start string `json:"start"` // YYYY-MM-DD, inclusive, camera clock date
+	End   string `json:"end"`   // YYYY-MM-DD, inclusive, camera clock date
+	// Zone is where the photos were taken (IANA name, e.g. "Asia/Tokyo")
+	Zone string `json:"zone"`
+	// ClockZone is the zone the camera clock was set to, if it was not adjusted for the trip
+	ClockZone string   `json:"clock_zone,omitempty"`
+	Cameras   []string `json:"cameras,omitempty"` // Optional, limits the trip to these models
+}
+
+// TimezoneConfig describes which zone camera clocks were set to
+type TimezoneConfig struct {
+	Default string            `json:"default,omitempty"` // IANA name used when nothing else matches
+	Cameras map[string]string `json:"cameras,omitempty"` // Model (or "Make Model") -> IANA name
+	Trips   []TimezoneTrip    `json:"trips,omitempty"`
+}
+
+// CaptureTime is the resolved capture time of a photo
+type CaptureTime struct {
+	Local  time.Time // Wall clock time where the photo was taken, carrying its zone
+	Source string
+}
+
+// UTC returns the capture instant in UTC
+func (c CaptureTime) UTC() time.Time {
+	return c.Local.UTC()
+}
+
+// LoadTimezoneConfig loads the zone configuration from PHOTO_TIMEZONE_CONFIG or the default file.
+// A missing file is not an error and results in an empty configuration.
+func LoadTimezoneConfig(rootDir string) (*TimezoneConfig, error) {
+	path := os.Getenv("PHOTO_TIMEZONE_CONFIG")
+	if path == "" {
+		path = TimezoneConfigFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+
+	config := &TimezoneConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read timezone config: %w", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return &TimezoneConfig{}, fmt.Errorf("failed to parse timezone config %s: %w", path, err)
+	}
+
+	// Validate zone names early so a typo does not silently fall back to UTC
+	zones := []string{config.Default}
+	for _, zone := range config.Cameras {
+		zones = append(zones, zone)
+	}
+	for _, trip := range config.Trips {
+		zones = append(zones, trip.Zone, trip.ClockZone)
+	}
+	for _, zone := range zones {
+		if zone == "" {
+			continue
+		}
+		if _, err := time.LoadLocation(zone); err != nil {
+			return config, fmt.Errorf("invalid time zone %q in %s: %w", zone, path, err)
+		}
+	}
+
+	log.Printf("✓ Loaded timezone config from: %s\n", path)
+	return config, nil
+}
+
+// ResolveCaptureTime determines when a photo was taken.
+//
+// The camera clock (DateTimeOriginal, falling back to CreateDate or the extractor's date) is
+// interpreted using, in order: OffsetTimeOriginal, the GPS UTC timestamp, a matching trip,
+// the camera's configured zone, and the configured default zone. If none apply the clock is
+// treated as UTC, which matches the legacy behaviour.
+func (c *TimezoneConfig) ResolveCaptureTime(exifData map[string]interface{}, fallback time.Time) (CaptureTime, bool) {
+	clock, ok := parseExifClock(exifData, fallback)
+	if !ok {
+		return CaptureTime{}, false
+	}
+
+	// 1. Offset written by the camera
+	if offset, ok := parseExifOffset(exifString(exifData, "OffsetTimeOriginal")); ok {
+		return CaptureTime{Local: clockIn(clock, offset), Source: TimeSourceOffset}, true
+	}
+	if offset, ok := parseExifOffset(exifString(exifData, "OffsetTime")); ok {
+		return CaptureTime{Local: clockIn(clock, offset), Source: TimeSourceOffset}, true
+	}
+
+	var trip *TimezoneTrip
+	if c != nil {
+		trip = c.matchTrip(clock, cameraNames(exifData))
+	}
+
+	// 2. GPS clock, which is always UTC
+	if gpsTime, ok := parseGPSDateTime(exifData); ok {
+		// Camera and GPS clocks are recorded independently, round the difference to 15 minutes
+		diff := clock.Sub(gpsTime).Round(15 * time.Minute)
+		if diff >= -14*time.Hour && diff <= 14*time.Hour {
+			offset := time.FixedZone(formatOffset(diff), int(diff.Seconds()))
+			local := clockIn(clock, offset)
+			if trip != nil {
+				local = inZone(local, trip.Zone)
+			}
+			return CaptureTime{Local: local, Source: TimeSourceGPS}, true
+		}
+	}
+
+	if c == nil {
+		return CaptureTime{Local: clock, Source: TimeSourceUTC}, true
+	}
+
+	// 3. Trip
+	if trip != nil {
+		clockZone := trip.ClockZone
+		if clockZone == "" {
+			clockZone = trip.Zone
+		}
+		if loc, err := time.LoadLocation(clockZone); err == nil {
+			return CaptureTime{Local: inZone(clockIn(clock, loc), trip.Zone), Source: TimeSourceTrip}, true
+		}
+	}
+
+	// 4. Camera
+	for _, name := range cameraNames(exifData) {
+		if zone, ok := c.Cameras[name]; ok {
+			if loc, err := time.LoadLocation(zone); err == nil {
+				return CaptureTime{Local: clockIn(clock, loc), Source: TimeSourceCamera}, true
+			}
+		}
+	}
+
+	// 5. Default
+	if c.Default != "" {
+		if loc, err := time.LoadLocation(c.Default); err == nil {
+			return CaptureTime{Local: clockIn(clock, loc), Source: TimeSourceDefault}, true
+		}
+	}
+
+	return CaptureTime{Local: clock, Source: TimeSourceUTC}, true
+}
+
+// matchTrip returns the first trip covering the clock date and camera, if any
+func (c *TimezoneConfig) matchTrip(clock time.Time, cameras []string) *TimezoneTrip {
+	date := clock.Format("2006-01-02")
+	for i := range c.Trips {
+		trip := &c.Trips[i]
+		if (trip.Start != "" && date < trip.Start) || (trip.End != "" && date > trip.End) {
+			continue
+		}
+		if len(trip.Cameras) > 0 && !containsAny(trip.Cameras, cameras) {
+			continue
+		}
+		return trip
+	}
+	return nil
+}
+
+// applyCaptureTime fills the date related fields of a photo from a resolved capture time
+func applyCaptureTime(photo *Photo, captured CaptureTime) {
+	photo.Year = fmt.Sprintf("%04d", captured.Local.Year())
+	photo.Month = fmt.Sprintf("%02d", captured.Local.Month())
+	photo.Date = captured.Local.Format("2006-01-02")
+	photo.LocalTime = captured.Local.Format(time.RFC3339)
+	photo.UTCTime = captured.UTC().Format(time.RFC3339)
+	photo.TimeSource = captured.Source
+	photo.Timestamp = captured.UTC().Unix()
+}
+
+// sortInstant returns the instant used to order photos, falling back to the date for legacy entries
+func sortInstant(photo Photo) int64 {
+	if photo.Timestamp != 0 {
+		return photo.Timestamp
+	}
+	if t, err := time.Parse("2006-01-02", photo.Date); err == nil {
+		return t.Unix()
+	}
+	return 0
+}
+
+// parseExifClock parses the camera clock as a zone-less time (returned in UTC)
+func parseExifClock(exifData map[string]interface{}, fallback time.Time) (time.Time, bool) {
+	for _, key := range []string{"DateTimeOriginal", "CreateDate"} {
+		value := exifString(exifData, key)
+		if len(value) < len(exifDateLayout) {
+			continue
+		}
+		// exiftool may append sub-seconds or a zone ("2025:11:09 22:05:34.12+08:00"), ignore them here
+		if t, err := time.Parse(exifDateLayout, value[:len(exifDateLayout)]); err == nil {
+			return t, true
+		}
+	}
+	if !fallback.IsZero() {
+		return time.Date(
+			fallback.Year(), fallback.Month(), fallback.Day(),
+			fallback.Hour(), fallback.Minute(), fallback.Second(), 0, time.UTC,
+		), true
+	}
+	return time.Time{}, false
+}
+
+// parseExifOffset parses an EXIF offset such as "+08:00" or "-05:30"
+func parseExifOffset(value string) (*time.Location, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) != 6 || (value[0] != '+' && value[0] != '-') || value[3] != ':' {
+		return nil, false
+	}
+	t, err := time.Parse("-07:00", value)
+	if err != nil {
+		return nil, false
+	}
+	_, seconds := t.Zone()
+	return time.FixedZone(value, seconds), true
+}
+
+// parseGPSDateTime reads the GPS UTC time from either the exiftool composite tag or the raw tags
+func parseGPSDateTime(exifData map[string]interface{}) (time.Time, bool) {
+	if value := exifString(exifData, "GPSDateTime"); value != "" {
+		value = strings.TrimSuffix(value, "Z")
+		if len(value) >= len(exifDateLayout) {
+			if t, err := time.Parse(exifDateLayout, value[:len(exifDateLayout)]); err == nil {
+				return t, true
+			}
+		}
+	}
+	date := exifString(exifData, "GPSDateStamp")
+	clock := exifString(exifData, "GPSTimeStamp")
+	if date == "" || clock == "" {
+		return time.Time{}, false
+	}
+	// Fractional seconds are common ("13:05:34.5"), drop them
+	if i := strings.Index(clock, "."); i >= 0 {
+		clock = clock[:i]
+	}
+	t, err := time.Parse(exifDateLayout, date+" "+clock)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// clockIn reinterprets a zone-less clock time in the given location
+func clockIn(clock time.Time, loc *time.Location) time.Time {
+	return time.Date(
+		clock.Year(), clock.Month(), clock.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, loc,
+	)
+}
+
+// inZone converts t into the named zone, leaving it unchanged if the zone is empty or unknown
+func inZone(t time.Time, zone string) time.Time {
+	if zone == "" {
+		return t
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return t
+	}
+	return t.In(loc)
+}
+
+// formatOffset formats a duration as an EXIF style offset ("+08:00")
+func formatOffset(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, int(d.Hours()), int(d.Minutes())%60)
+}
+
+// cameraNames returns the keys a camera can be configured under: "Make Model" and "Model"
+func cameraNames(exifData map[string]interface{}) []string {
+	model := exifString(exifData, "Model")
+	if model == "" {
+		return nil
+	}
+	names := []string{model}
+	if maker := exifString(exifData, "Make"); maker != "" && !strings.HasPrefix(model, maker) {
+		names = append([]string{maker + " " + model}, names...)
+	}
+	return names
+}
+
+// exifString returns an EXIF value as a trimmed string
+func exifString(exifData map[string]interface{}, key string) string {
+	value, ok := exifData[key]
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v", value))
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
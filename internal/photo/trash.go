@@ -0,0 +1,451 @@
+package photo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+const (
+	// DefaultTrashRetentionDays is how long deleted objects stay in the trash
+	DefaultTrashRetentionDays = 30
+	// DefaultDeleteThreshold is the share of photos, in percent, a single update may delete
+	DefaultDeleteThreshold = 20
+	// MinGuardedDeletions is the number of deletions below which the threshold is not checked,
+	// so small libraries can still lose a few photos in one update
+	MinGuardedDeletions = 5
+)
+
+// ErrTooManyDeletions is returned when an update would delete more photos than the threshold allows
+var ErrTooManyDeletions = errors.New("too many photos would be deleted")
+
+// TrashRetention returns the retention period from PHOTO_TRASH_RETENTION_DAYS or the default
+func TrashRetention() time.Duration {
+	days := DefaultTrashRetentionDays
+	if value, err := strconv.Atoi(os.Getenv("PHOTO_TRASH_RETENTION_DAYS")); err == nil && value >= 0 {
+		days = value
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// DeleteThreshold returns the deletion threshold in percent from PHOTO_DELETE_THRESHOLD or the
+// default, 100 disables the check
+func DeleteThreshold() int {
+	if value, err := strconv.Atoi(os.Getenv("PHOTO_DELETE_THRESHOLD")); err == nil && value >= 0 {
+		return value
+	}
+	return DefaultDeleteThreshold
+}
+
+// checkDeletions refuses to delete more than the threshold share of total photos
+func checkDeletions(deleted, total int) error {
+	threshold := DeleteThreshold()
+	if deleted < MinGuardedDeletions || deleted*100 <= threshold*total {
+		return nil
+	}
+	return fmt.Errorf(
+		"%w: %d of %d photos (more than %d%%), check that the images directory is complete or pass --allow-deletions",
+		ErrTooManyDeletions, deleted, total, threshold,
+	)
+}
+
+// TrashedObject is an R2 object moved to the trash
+type TrashedObject struct {
+	Key      string `json:"key"`
+	TrashKey string `json:"trash_key"`
+}
+
+// TrashedPhoto is the entry of a deleted photo with its stored private metadata and, when it was
+// deleted in the admin panel, its source files relative to the images directory
+type TrashedPhoto struct {
+	Photo    Photo                  `json:"photo"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Files    []string               `json:"files,omitempty"`
+}
+
+// TrashBatch is what a single update moved to the trash, its ID is the job ID
+type TrashBatch struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Photos    []TrashedPhoto  `json:"photos,omitempty"`
+	Objects   []TrashedObject `json:"objects,omitempty"`
+}
+
+// Expired reports whether the retention period of the batch has passed
+func (b *TrashBatch) Expired(now time.Time) bool {
+	return now.Sub(b.CreatedAt) > TrashRetention()
+}
+
+// TrashStore keeps the trash batches, one JSON file per batch
+type TrashStore struct {
+	Dir string
+}
+
+// NewTrashStore returns the store in the state directory
+func NewTrashStore(rootDir string) *TrashStore {
+	return &TrashStore{Dir: filepath.Join(StateDirPath(rootDir), "trash")}
+}
+
+func (s *TrashStore) path(id string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+".json")
+}
+
+// Save stores a batch, a batch without photos and objects is removed
+func (s *TrashStore) Save(batch *TrashBatch) error {
+	if len(batch.Photos) == 0 && len(batch.Objects) == 0 {
+		return s.Remove(batch.ID)
+	}
+	data, err := json.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal trash batch: %w", err)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create trash store: %w", err)
+	}
+	return os.WriteFile(s.path(batch.ID), data, 0644)
+}
+
+// FilePath returns where a source file of a batch is kept, rel is relative to the images directory
+func (s *TrashStore) FilePath(id, rel string) string {
+	return filepath.Join(s.Dir, "files", filepath.Base(id), filepath.FromSlash(rel))
+}
+
+// Remove deletes a batch record and its source files
+func (s *TrashStore) Remove(id string) error {
+	if id == "" {
+		return fmt.Errorf("trash batch ID is empty")
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove trash batch %s: %w", id, err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.Dir, "files", filepath.Base(id))); err != nil {
+		return fmt.Errorf("failed to remove files of trash batch %s: %w", id, err)
+	}
+	return nil
+}
+
+// List returns all batches, newest first
+func (s *TrashStore) List() ([]*TrashBatch, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read trash store: %w", err)
+	}
+	var batches []*TrashBatch
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.Dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trash batch: %w", err)
+		}
+		var batch TrashBatch
+		if err := json.Unmarshal(data, &batch); err != nil {
+			log.Printf("⚠ Warning: skipping unreadable trash batch %s: %v\n", entry.Name(), err)
+			continue
+		}
+		batches = append(batches, &batch)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].ID > batches[j].ID })
+	return batches, nil
+}
+
+// trashPhoto records a deleted photo with its stored metadata in the batch
+func (p *PhotoProcessor) trashPhoto(batch *TrashBatch, photo Photo) {
+	stored, err := p.Metadata.Load(photo.Filename)
+	if err != nil {
+		log.Printf("⚠ Warning: %v\n", err)
+	}
+	batch.Photos = append(batch.Photos, TrashedPhoto{Photo: photo, Metadata: stored})
+}
+
+// moveToTrash copies objects below the trash prefix and deletes the originals. Objects that
+// could not be copied are left in place, as are all objects without a private bucket.
+func (p *PhotoProcessor) moveToTrash(ctx context.Context, batch *TrashBatch, keys []string) error {
+	if !p.R2Client.Config.HasPrivateBucket() {
+		log.Printf("⚠ Warning: %v, %d objects are left in place\n", storage.ErrNoPrivateBucket, len(keys))
+		return nil
+	}
+	prefix := p.R2Client.Config.TrashPrefix + batch.ID + "/"
+	var moved []string
+	var errs []error
+	for _, key := range keys {
+		trashKey := prefix + key
+		if err := p.R2Client.CopyObject(ctx, key, trashKey); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		batch.Objects = append(batch.Objects, TrashedObject{Key: key, TrashKey: trashKey})
+		moved = append(moved, key)
+	}
+	if err := p.R2Client.DeleteObjects(ctx, moved); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// TrashDeleted moves the R2 objects, stored metadata and source files of a photo deleted in the
+// admin panel to a new trash batch, so it can be restored like the photos an update deleted. paths
+// are its source files in imagesDir; r2Client is nil in local mode.
+func TrashDeleted(ctx context.Context, rootDir, imagesDir string, r2Client *storage.R2Client, photo Photo, paths []string) (*TrashBatch, error) {
+	p := &PhotoProcessor{R2Client: r2Client, Metadata: NewMetadataStore(rootDir)}
+	store := NewTrashStore(rootDir)
+	now := time.Now()
+	batch := &TrashBatch{ID: newJobID(now), CreatedAt: now}
+	p.trashPhoto(batch, photo)
+
+	var errs []error
+	if r2Client != nil {
+		errs = append(errs, p.moveToTrash(ctx, batch, StoredKeys(r2Client.Config, photo).All()))
+	}
+	trashed := &batch.Photos[0]
+	for _, path := range paths {
+		rel, err := filepath.Rel(imagesDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			errs = append(errs, fmt.Errorf("%s is not in the images directory", path))
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		dst := store.FilePath(batch.ID, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			errs = append(errs, fmt.Errorf("failed to create trash directory: %w", err))
+			continue
+		}
+		if err := os.Rename(path, dst); err != nil {
+			errs = append(errs, fmt.Errorf("failed to move %s to the trash: %w", rel, err))
+			continue
+		}
+		trashed.Files = append(trashed.Files, rel)
+	}
+
+	if err := store.Save(batch); err != nil {
+		return batch, errors.Join(append(errs, fmt.Errorf("failed to record trash batch: %w", err))...)
+	}
+	if err := p.Metadata.Delete(photo.Filename); err != nil {
+		errs = append(errs, err)
+	}
+	return batch, errors.Join(errs...)
+}
+
+// purgeTrash permanently deletes the batches whose retention period has passed
+func (p *PhotoProcessor) purgeTrash(ctx context.Context, store *TrashStore) (int, error) {
+	batches, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	now := time.Now()
+	for _, batch := range batches {
+		if !batch.Expired(now) {
+			continue
+		}
+		if len(batch.Objects) > 0 {
+			if p.R2Client == nil || !p.R2Client.Config.HasPrivateBucket() {
+				continue // Keep the record until the objects can be deleted
+			}
+			var keys []string
+			for _, object := range batch.Objects {
+				keys = append(keys, object.TrashKey)
+			}
+			if err := p.R2Client.DeleteObjects(ctx, keys); err != nil {
+				return purged, fmt.Errorf("failed to purge trash batch %s: %w", batch.ID, err)
+			}
+		}
+		if err := store.Remove(batch.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RestoreResult lists what a restore brought back
+type RestoreResult struct {
+	Restored []string    `json:"restored"`
+	Failed   []FileError `json:"failed"`
+}
+
+// Restore brings deleted photos back from the trash: their R2 objects, stored metadata and
+// photos.json entries. With batchID empty, each file is taken from the newest batch that has it;
+// with no filenames, every photo of the batch is restored. Source files are moved back when the
+// admin panel trashed them, otherwise they have to be back in the images directory, or the next
+// update deletes the photos again.
+func Restore(ctx context.Context, batchID string, filenames []string) (*RestoreResult, error) {
+	if batchID == "" && len(filenames) == 0 {
+		return nil, fmt.Errorf("a batch ID or filenames are required")
+	}
+	processor, err := NewPhotoProcessor()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing processor: %w", err)
+	}
+	unlock, err := LockManifest(processor.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := processor.LoadExistingMetadata(); err != nil {
+		return nil, fmt.Errorf("failed to load existing metadata: %w", err)
+	}
+	store := NewTrashStore(processor.RootDir)
+	batches, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the photos to restore, keyed by the batch they are restored from
+	selected := make(map[*TrashBatch]map[string]bool)
+	wanted := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		wanted[filename] = true
+	}
+	result := &RestoreResult{}
+	for _, batch := range batches {
+		if batchID != "" && batch.ID != batchID {
+			continue
+		}
+		for _, trashed := range batch.Photos {
+			filename := trashed.Photo.Filename
+			if len(filenames) > 0 && !wanted[filename] {
+				continue
+			}
+			delete(wanted, filename)
+			if selected[batch] == nil {
+				selected[batch] = make(map[string]bool)
+			}
+			selected[batch][filename] = true
+		}
+	}
+	if batchID != "" && len(selected) == 0 && len(filenames) == 0 {
+		return nil, fmt.Errorf("trash batch %s not found", batchID)
+	}
+	for filename := range wanted {
+		result.Failed = append(result.Failed, FileError{File: filename, Error: "not in the trash"})
+	}
+
+	for batch, names := range selected {
+		objects := make(map[string]TrashedObject, len(batch.Objects))
+		for _, object := range batch.Objects {
+			objects[object.Key] = object
+		}
+		var kept []TrashedPhoto
+		var restoredKeys []string
+		for _, trashed := range batch.Photos {
+			photo := trashed.Photo
+			if !names[photo.Filename] {
+				kept = append(kept, trashed)
+				continue
+			}
+			if _, ok := processor.ExistingPhotos[photo.Filename]; ok {
+				result.Failed = append(result.Failed, FileError{File: photo.Filename, Error: "a photo with this name exists"})
+				kept = append(kept, trashed)
+				continue
+			}
+
+			// Copy the objects back before the entry references them again
+			var copyErr error
+			var keys []string
+			if processor.R2Client != nil {
+				for _, key := range StoredKeys(processor.R2Client.Config, photo).All() {
+					object, ok := objects[key]
+					if !ok {
+						continue // Never uploaded, or already purged
+					}
+					if copyErr = processor.R2Client.CopyObject(ctx, object.TrashKey, key); copyErr != nil {
+						break
+					}
+					keys = append(keys, object.TrashKey)
+				}
+			}
+			if copyErr != nil {
+				result.Failed = append(result.Failed, FileError{File: photo.Filename, Error: copyErr.Error()})
+				kept = append(kept, trashed)
+				continue
+			}
+
+			if trashed.Metadata != nil {
+				if err := processor.Metadata.Save(photo.Filename, trashed.Metadata); err != nil {
+					log.Printf("⚠ Warning: failed to restore metadata of %s: %v\n", photo.Filename, err)
+				}
+			}
+			for _, rel := range trashed.Files {
+				path := filepath.Join(processor.ImgDirPath, filepath.FromSlash(rel))
+				if _, err := os.Stat(path); err == nil {
+					log.Printf("⚠ Warning: %s is back in %s, keeping its trashed copy\n", rel, ImgDir)
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					log.Printf("⚠ Warning: %v\n", err)
+					continue
+				}
+				if err := os.Rename(store.FilePath(batch.ID, rel), path); err != nil {
+					log.Printf("⚠ Warning: failed to restore %s: %v\n", rel, err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(processor.ImgDirPath, filepath.FromSlash(photo.Source))); err != nil {
+				log.Printf("⚠ Warning: %s is not in %s, the next update deletes it again\n", photo.Source, ImgDir)
+			}
+			if t, err := time.Parse(time.RFC3339, photo.UTCTime); err == nil {
+				photo.Timestamp = t.Unix()
+			}
+			processor.ExistingPhotos[photo.Filename] = photo
+			restoredKeys = append(restoredKeys, keys...)
+			result.Restored = append(result.Restored, photo.Filename)
+			log.Printf("✓ Restored %s from trash batch %s\n", photo.Filename, batch.ID)
+		}
+
+		// The restored objects are back in place, their trash copies are no longer needed
+		if len(restoredKeys) > 0 {
+			trashKeys := make(map[string]bool, len(restoredKeys))
+			for _, key := range restoredKeys {
+				trashKeys[key] = true
+			}
+			if err := processor.R2Client.DeleteObjects(ctx, restoredKeys); err != nil {
+				log.Printf("⚠ Warning: failed to remove restored objects from the trash: %v\n", err)
+			} else {
+				remaining := batch.Objects[:0]
+				for _, object := range batch.Objects {
+					if !trashKeys[object.TrashKey] {
+						remaining = append(remaining, object)
+					}
+				}
+				batch.Objects = remaining
+			}
+		}
+		batch.Photos = kept
+		if err := store.Save(batch); err != nil {
+			log.Printf("⚠ Warning: %v\n", err)
+		}
+	}
+	sort.Strings(result.Restored)
+	if len(result.Restored) == 0 {
+		return result, nil
+	}
+
+	// Write and publish the manifest with the restored entries
+	allPhotos := make([]Photo, 0, len(processor.ExistingPhotos))
+	for _, photo := range processor.ExistingPhotos {
+		allPhotos = append(allPhotos, photo)
+	}
+	jsonData, err := MarshalManifest(organizeAlbums(allPhotos))
+	if err != nil {
+		return result, err
+	}
+	if err := WriteManifest(processor.RootDir, jsonData); err != nil {
+		return result, fmt.Errorf("error writing output file: %w", err)
+	}
+	logMsg := func(format string, v ...interface{}) { log.Printf(format+"\n", v...) }
+	return result, errors.Join(PublishManifest(ctx, processor.RootDir, processor.R2Client, jsonData, logMsg)...)
+}
@@ -0,0 +1,557 @@
+package photo
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+func TestCheckDeletions(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string // PHOTO_DELETE_THRESHOLD, the default when empty
+		deleted   int
+		total     int
+		wantErr   bool
+	}{
+		{name: "nothing deleted", deleted: 0, total: 100},
+		{name: "below the guarded minimum", deleted: MinGuardedDeletions - 1, total: MinGuardedDeletions - 1},
+		{name: "guarded minimum within the threshold", deleted: 5, total: 25},
+		{name: "guarded minimum above the threshold", deleted: 5, total: 24, wantErr: true},
+		{name: "exactly the threshold", deleted: 20, total: 100},
+		{name: "one above the threshold", deleted: 21, total: 100, wantErr: true},
+		{name: "everything", deleted: 50, total: 50, wantErr: true},
+		{name: "custom threshold", threshold: "50", deleted: 50, total: 100},
+		{name: "above a custom threshold", threshold: "50", deleted: 51, total: 100, wantErr: true},
+		{name: "threshold 100 disables the check", threshold: "100", deleted: 50, total: 50},
+		{name: "threshold 0", threshold: "0", deleted: 5, total: 1000, wantErr: true},
+		{name: "threshold 0 below the guarded minimum", threshold: "0", deleted: 4, total: 1000},
+		{name: "invalid threshold uses the default", threshold: "-1", deleted: 21, total: 100, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PHOTO_DELETE_THRESHOLD", tt.threshold)
+			err := checkDeletions(tt.deleted, tt.total)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDeletions(%d, %d) error = %v, want error %v", tt.deleted, tt.total, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrTooManyDeletions) {
+				t.Errorf("checkDeletions() error = %v, want %v", err, ErrTooManyDeletions)
+			}
+		})
+	}
+}
+
+// fakeR2 is an in-memory S3 endpoint serving the requests R2Client makes, objects are keyed by
+// bucket and key
+type fakeR2 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failCopy map[string]bool // Source keys whose copy fails
+	keep     map[string]bool // Keys a delete reports as deleted but keeps
+	failList bool            // Listing objects is denied
+}
+
+func (f *fakeR2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, _, _ := strings.Cut(path, "/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		var req struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, object := range req.Objects {
+			if !f.keep[object.Key] {
+				delete(f.objects, bucket+"/"+object.Key)
+			}
+		}
+		fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		data, ok := f.objects[src]
+		_, srcKey, _ := strings.Cut(src, "/")
+		if !ok || f.failCopy[srcKey] {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		f.objects[path] = data
+		fmt.Fprint(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[path] = data
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" && f.failList:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code></Error>`)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for name := range f.objects {
+			if key, ok := strings.CutPrefix(name, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated>`)
+		for _, key := range keys {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, key, len(f.objects[bucket+"/"+key]))
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// has reports whether an object exists
+func (f *fakeR2) has(bucket, key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[bucket+"/"+key]
+	return ok
+}
+
+// put stores an object
+func (f *fakeR2) put(bucket, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[bucket+"/"+key] = []byte(key)
+}
+
+const (
+	testBucket        = "public"
+	testPrivateBucket = "private"
+)
+
+// testR2Config returns the configuration of a fake R2 endpoint
+func testR2Config(endpoint string) storage.R2Config {
+	return storage.R2Config{
+		Endpoint:        endpoint,
+		Bucket:          testBucket,
+		PrivateBucket:   testPrivateBucket,
+		Region:          "auto",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		CDNUrl:          "https://cdn.example.com",
+		BasePrefix:      "photos/",
+		OriginalPrefix:  "originals/",
+		ThumbnailPrefix: "thumbnails/",
+		PrivatePrefix:   "private/",
+		TrashPrefix:     "trash/",
+	}
+}
+
+// newFakeR2 starts a fake R2 endpoint and returns a client for it
+func newFakeR2(t *testing.T) (*fakeR2, *storage.R2Client) {
+	t.Helper()
+	fake := &fakeR2{objects: make(map[string][]byte), failCopy: make(map[string]bool), keep: make(map[string]bool)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	cfg := testR2Config(server.URL)
+	client, err := storage.NewR2Client(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+// bucketOf returns the bucket a key is stored in
+func bucketOf(cfg storage.R2Config, key string) string {
+	if cfg.IsPrivateKey(key) {
+		return testPrivateBucket
+	}
+	return testBucket
+}
+
+func TestMoveToTrash(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []string
+		stored    []string // Objects in R2 before the move
+		failCopy  []string
+		noPrivate bool // No private bucket is configured
+		wantMoved []string
+		wantErr   bool
+	}{
+		{
+			name:      "public and private objects",
+			keys:      []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp", "private/photos/raw/a.dng"},
+			stored:    []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp", "private/photos/raw/a.dng"},
+			wantMoved: []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp", "private/photos/raw/a.dng"},
+		},
+		{
+			name:      "missing object",
+			keys:      []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp"},
+			stored:    []string{"photos/originals/a.jpg"},
+			wantMoved: []string{"photos/originals/a.jpg"},
+			wantErr:   true,
+		},
+		{
+			name:      "failed copy leaves the object in place",
+			keys:      []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp"},
+			stored:    []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp"},
+			failCopy:  []string{"photos/thumbnails/a.webp"},
+			wantMoved: []string{"photos/originals/a.jpg"},
+			wantErr:   true,
+		},
+		{
+			name:      "no private bucket leaves the objects in place",
+			keys:      []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp"},
+			stored:    []string{"photos/originals/a.jpg", "photos/thumbnails/a.webp"},
+			noPrivate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeR2(t)
+			if tt.noPrivate {
+				client.Config.PrivateBucket = ""
+			}
+			cfg := client.Config
+			for _, key := range tt.stored {
+				fake.put(bucketOf(cfg, key), key)
+			}
+			for _, key := range tt.failCopy {
+				fake.failCopy[key] = true
+			}
+			p := &PhotoProcessor{R2Client: client}
+			batch := &TrashBatch{ID: "20260301-120000-abcdef"}
+
+			err := p.moveToTrash(context.Background(), batch, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("moveToTrash() error = %v, want error %v", err, tt.wantErr)
+			}
+			var moved []string
+			for _, object := range batch.Objects {
+				if want := "trash/" + batch.ID + "/" + object.Key; object.TrashKey != want {
+					t.Errorf("trash key of %s = %s, want %s", object.Key, object.TrashKey, want)
+				}
+				if !fake.has(testPrivateBucket, object.TrashKey) {
+					t.Errorf("%s is not in the private bucket", object.TrashKey)
+				}
+				if fake.has(bucketOf(cfg, object.Key), object.Key) {
+					t.Errorf("%s was not deleted", object.Key)
+				}
+				moved = append(moved, object.Key)
+			}
+			if !reflect.DeepEqual(moved, tt.wantMoved) {
+				t.Errorf("moved %v, want %v", moved, tt.wantMoved)
+			}
+			for _, key := range tt.stored {
+				if !slices.Contains(moved, key) && !fake.has(bucketOf(cfg, key), key) {
+					t.Errorf("%s was deleted although it was not moved", key)
+				}
+			}
+		})
+	}
+}
+
+func TestTrashDeleted(t *testing.T) {
+	t.Setenv("PHOTO_STATE_DIR", "")
+	root := t.TempDir()
+	imagesDir := filepath.Join(root, ImgDir)
+	source := filepath.Join(imagesDir, "2024", "a.jpg")
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(root, "a.xmp")
+	if err := os.WriteFile(outside, []byte("xmp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata := map[string]interface{}{"GPSLatitude": "52.1"}
+	if err := NewMetadataStore(root).Save("a.jpg", metadata); err != nil {
+		t.Fatal(err)
+	}
+	fake, client := newFakeR2(t)
+	photo := Photo{Filename: "a.jpg", Path: "https://cdn.example.com/photos/originals/a.jpg", Year: "2024", Source: "2024/a.jpg"}
+	keys := KeysFor(client.Config, photo).All()
+	for _, key := range keys {
+		fake.put(testBucket, key)
+	}
+
+	batch, err := TrashDeleted(context.Background(), root, imagesDir, client, photo, []string{source, outside})
+	if err == nil {
+		t.Error("TrashDeleted() with a file outside the images directory returned no error")
+	}
+	if batch == nil {
+		t.Fatal("TrashDeleted() returned no batch")
+	}
+
+	store := NewTrashStore(root)
+	batches, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || batches[0].ID != batch.ID {
+		t.Fatalf("trash batches = %v, want %s", batches, batch.ID)
+	}
+	saved := batches[0]
+	if len(saved.Photos) != 1 {
+		t.Fatalf("batch has %d photos, want 1", len(saved.Photos))
+	}
+	trashed := saved.Photos[0]
+	if trashed.Photo.Filename != photo.Filename || !reflect.DeepEqual(trashed.Metadata, metadata) {
+		t.Errorf("trashed photo = %+v, want %s with metadata %v", trashed, photo.Filename, metadata)
+	}
+	if !reflect.DeepEqual(trashed.Files, []string{"2024/a.jpg"}) {
+		t.Errorf("trashed files = %v, want [2024/a.jpg]", trashed.Files)
+	}
+	if len(saved.Objects) != len(keys) {
+		t.Errorf("batch has %d objects, want %d", len(saved.Objects), len(keys))
+	}
+	for _, key := range keys {
+		if fake.has(testBucket, key) || !fake.has(testPrivateBucket, "trash/"+batch.ID+"/"+key) {
+			t.Errorf("%s was not moved to the trash", key)
+		}
+	}
+
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("%s is still in the images directory", source)
+	}
+	if _, err := os.Stat(store.FilePath(batch.ID, "2024/a.jpg")); err != nil {
+		t.Errorf("source file is not in the trash: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the images directory was moved: %v", err)
+	}
+	if stored, _ := NewMetadataStore(root).Load("a.jpg"); stored != nil {
+		t.Errorf("stored metadata = %v, want it moved to the trash", stored)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	now := time.Now()
+	batches := []*TrashBatch{
+		{
+			ID: "expired", CreatedAt: now.Add(-8 * 24 * time.Hour),
+			Photos:  []TrashedPhoto{{Photo: Photo{Filename: "a.jpg"}}},
+			Objects: []TrashedObject{{Key: "photos/originals/a.jpg", TrashKey: "trash/expired/photos/originals/a.jpg"}},
+		},
+		{
+			ID: "expired-local", CreatedAt: now.Add(-8 * 24 * time.Hour),
+			Photos: []TrashedPhoto{{Photo: Photo{Filename: "b.jpg"}, Files: []string{"2024/b.jpg"}}},
+		},
+		{
+			ID: "kept", CreatedAt: now.Add(-6 * 24 * time.Hour),
+			Photos:  []TrashedPhoto{{Photo: Photo{Filename: "c.jpg"}}},
+			Objects: []TrashedObject{{Key: "photos/originals/c.jpg", TrashKey: "trash/kept/photos/originals/c.jpg"}},
+		},
+	}
+	tests := []struct {
+		name       string
+		local      bool
+		wantPurged int
+		wantKept   []string
+	}{
+		{name: "R2", wantPurged: 2, wantKept: []string{"kept"}},
+		{name: "local mode keeps batches with objects", local: true, wantPurged: 1, wantKept: []string{"kept", "expired"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PHOTO_TRASH_RETENTION_DAYS", "7")
+			store := &TrashStore{Dir: t.TempDir()}
+			fake, client := newFakeR2(t)
+			for _, batch := range batches {
+				if err := store.Save(batch); err != nil {
+					t.Fatal(err)
+				}
+				for _, object := range batch.Objects {
+					fake.put(testPrivateBucket, object.TrashKey)
+				}
+			}
+			if err := os.MkdirAll(filepath.Dir(store.FilePath("expired-local", "2024/b.jpg")), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(store.FilePath("expired-local", "2024/b.jpg"), []byte("jpeg"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p := &PhotoProcessor{R2Client: client}
+			if tt.local {
+				p.R2Client = nil
+			}
+
+			purged, err := p.purgeTrash(context.Background(), store)
+			if err != nil {
+				t.Fatalf("purgeTrash() error = %v", err)
+			}
+			if purged != tt.wantPurged {
+				t.Errorf("purgeTrash() = %d, want %d", purged, tt.wantPurged)
+			}
+			remaining, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, batch := range remaining {
+				kept = append(kept, batch.ID)
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept batches %v, want %v", kept, tt.wantKept)
+			}
+			if _, err := os.Stat(store.FilePath("expired-local", "2024/b.jpg")); !os.IsNotExist(err) {
+				t.Error("source files of a purged batch were not deleted")
+			}
+			if got := fake.has(testPrivateBucket, "trash/expired/photos/originals/a.jpg"); got != tt.local {
+				t.Errorf("expired trash object exists = %v, want %v", got, tt.local)
+			}
+			if !fake.has(testPrivateBucket, "trash/kept/photos/originals/c.jpg") {
+				t.Error("trash object within the retention period was deleted")
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	fake, client := newFakeR2(t)
+	cfg := client.Config
+	for name, value := range map[string]string{
+		"NUXT_PROVIDER_S3_ENDPOINT":          cfg.Endpoint,
+		"NUXT_PROVIDER_S3_BUCKET":            cfg.Bucket,
+		"NUXT_PROVIDER_S3_PRIVATE_BUCKET":    cfg.PrivateBucket,
+		"NUXT_PROVIDER_S3_REGION":            cfg.Region,
+		"NUXT_PROVIDER_S3_ACCESS_KEY_ID":     cfg.AccessKeyID,
+		"NUXT_PROVIDER_S3_SECRET_ACCESS_KEY": cfg.SecretAccessKey,
+		"NUXT_PROVIDER_S3_CDN_URL":           cfg.CDNUrl,
+		"PHOTO_STATE_DIR":                    "",
+	} {
+		t.Setenv(name, value)
+	}
+	cfClient := storage.CFCli
+	storage.CFCli = nil // Publish to R2 only
+	t.Cleanup(func() { storage.CFCli = cfClient })
+	root := t.TempDir()
+	t.Chdir(root)
+
+	existing := Photo{Filename: "b.jpg", Path: "https://cdn.example.com/photos/originals/b.jpg", Year: "2024", Date: "2024-05-02"}
+	restored := Photo{
+		Filename: "a.jpg", Path: "https://cdn.example.com/photos/originals/a.jpg", Year: "2024", Date: "2024-05-01",
+		Source: "2024/a.jpg", UTCTime: "2024-05-01T10:00:00Z",
+	}
+	conflicting := Photo{Filename: "b.jpg", Path: existing.Path, Year: "2024"}
+	data, err := MarshalManifest(organizeAlbums([]Photo{existing}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(ManifestPath(root)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteManifest(root, data); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewTrashStore(root)
+	batch := &TrashBatch{
+		ID: "20260301-120000-abcdef", CreatedAt: time.Now(),
+		Photos: []TrashedPhoto{
+			{Photo: restored, Metadata: map[string]interface{}{"GPSLatitude": "52.1"}, Files: []string{"2024/a.jpg"}},
+			{Photo: conflicting},
+		},
+	}
+	for _, photo := range []Photo{restored, conflicting} {
+		for _, key := range KeysFor(cfg, photo).All() {
+			trashKey := cfg.TrashPrefix + batch.ID + "/" + key
+			fake.put(testPrivateBucket, trashKey)
+			batch.Objects = append(batch.Objects, TrashedObject{Key: key, TrashKey: trashKey})
+		}
+	}
+	if err := store.Save(batch); err != nil {
+		t.Fatal(err)
+	}
+	trashedFile := store.FilePath(batch.ID, "2024/a.jpg")
+	if err := os.MkdirAll(filepath.Dir(trashedFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(trashedFile, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Restore(context.Background(), batch.ID, nil)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Restored, []string{"a.jpg"}) {
+		t.Errorf("restored %v, want [a.jpg]", result.Restored)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].File != "b.jpg" {
+		t.Errorf("failed %v, want b.jpg", result.Failed)
+	}
+
+	for _, key := range KeysFor(cfg, restored).All() {
+		if !fake.has(testBucket, key) {
+			t.Errorf("%s was not restored", key)
+		}
+		if fake.has(testPrivateBucket, cfg.TrashPrefix+batch.ID+"/"+key) {
+			t.Errorf("trash copy of %s was not removed", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, ImgDir, "2024", "a.jpg")); err != nil {
+		t.Errorf("source file was not moved back: %v", err)
+	}
+	if stored, _ := NewMetadataStore(root).Load("a.jpg"); stored["GPSLatitude"] != "52.1" {
+		t.Errorf("stored metadata = %v, want it restored", stored)
+	}
+
+	content, err := os.ReadFile(ManifestPath(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ParseManifest(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filenames []string
+	for _, album := range manifest.Albums {
+		for _, photo := range album.Photos {
+			filenames = append(filenames, photo.Filename)
+		}
+	}
+	sort.Strings(filenames)
+	if !reflect.DeepEqual(filenames, []string{"a.jpg", "b.jpg"}) {
+		t.Errorf("manifest photos = %v, want [a.jpg b.jpg]", filenames)
+	}
+
+	// The batch keeps the photo that could not be restored and its objects
+	remaining, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || len(remaining[0].Photos) != 1 || remaining[0].Photos[0].Photo.Filename != "b.jpg" {
+		t.Fatalf("remaining batches = %+v, want b.jpg in %s", remaining, batch.ID)
+	}
+	for _, object := range remaining[0].Objects {
+		if strings.Contains(object.Key, "a.") {
+			t.Errorf("batch still lists the restored object %s", object.Key)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package photo
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+// Sources of a manifest version
+const (
+	VersionLocal  = "local" // A backup in the state directory
+	VersionRemote = "r2"    // A snapshot uploaded to R2 with every published photos.json
+)
+
+// CurrentVersion is the ID of the current photos.json
+const CurrentVersion = "current"
+
+// snapshotDir is the directory of the photos.json snapshots in R2, below the private prefix
+const snapshotDir = "manifests/"
+
+// ErrVersionNotFound is returned for a manifest version that does not exist
+var ErrVersionNotFound = errors.New("manifest version not found")
+
+// ManifestVersion is a previous version of photos.json that can be rolled back to
+type ManifestVersion struct {
+	ID      string        `json:"id"` // Source and name, e.g. "local:photos.20250101-120000.000.json"
+	Source  string        `json:"source"`
+	Name    string        `json:"name"`
+	Time    time.Time     `json:"time"`
+	Size    int64         `json:"size"`
+	Photos  int           `json:"photos"`
+	Current bool          `json:"current,omitempty"` // Same content as the current photos.json
+	Diff    ManifestStats `json:"diff"`              // What rolling back would change in the current photos.json
+	Error   string        `json:"error,omitempty"`   // The version could not be read or parsed
+}
+
+// versionSummary is what listing a version needs of its content. Versions never change, so the
+// summaries are cached by version ID and each version is only read once.
+type versionSummary struct {
+	Size    int64             `json:"size"`
+	Hash    string            `json:"hash"`    // See manifestHash
+	Entries map[string]string `json:"entries"` // MD5 of each entry by filename
+}
+
+// summarizeManifest returns the summary of a photos.json, empty for no content
+func summarizeManifest(data []byte) (*versionSummary, error) {
+	summary := &versionSummary{Size: int64(len(data)), Hash: manifestHash(data), Entries: make(map[string]string)}
+	if len(data) == 0 {
+		return summary, nil
+	}
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, album := range manifest.Albums {
+		for _, photo := range album.Photos {
+			encoded, err := json.Marshal(photo)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal %s: %w", photo.Filename, err)
+			}
+			summary.Entries[photo.Filename] = fmt.Sprintf("%x", md5.Sum(encoded))
+		}
+	}
+	return summary, nil
+}
+
+// stats returns what replacing current with the version would change, like manifestStats
+func (s *versionSummary) stats(current *versionSummary) ManifestStats {
+	stats := ManifestStats{
+		PhotosBefore: len(current.Entries),
+		PhotosAfter:  len(s.Entries),
+		BytesBefore:  current.Size,
+		BytesAfter:   s.Size,
+	}
+	for filename, hash := range s.Entries {
+		if previous, ok := current.Entries[filename]; !ok {
+			stats.Added++
+		} else if previous != hash {
+			stats.Changed++
+		}
+	}
+	for filename := range current.Entries {
+		if _, ok := s.Entries[filename]; !ok {
+			stats.Removed++
+		}
+	}
+	return stats
+}
+
+// versionCachePath returns the path of the cached version summaries
+func versionCachePath(rootDir string) string {
+	return filepath.Join(StateDirPath(rootDir), "versions.json")
+}
+
+// loadVersionCache reads the cached version summaries, empty when there are none
+func loadVersionCache(rootDir string) map[string]*versionSummary {
+	cache := make(map[string]*versionSummary)
+	data, err := os.ReadFile(versionCachePath(rootDir))
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		log.Printf("⚠ Warning: ignoring unreadable version cache: %v\n", err)
+		return make(map[string]*versionSummary)
+	}
+	return cache
+}
+
+// saveVersionCache writes the version summaries
+func saveVersionCache(rootDir string, cache map[string]*versionSummary) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("failed to marshal version cache: %w", err)
+	}
+	path := versionCachePath(rootDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// snapshotPrefix returns the R2 prefix of the photos.json snapshots
+func snapshotPrefix(cfg storage.R2Config) string {
+	return cfg.PrivatePrefix + cfg.BasePrefix + snapshotDir
+}
+
+// uploadSnapshot stores a published photos.json in R2 and prunes the snapshots with the same
+// retention as the local backups. Without a private bucket only the local backups are kept.
+func uploadSnapshot(ctx context.Context, r2Client *storage.R2Client, jsonData []byte, now time.Time) error {
+	if !r2Client.Config.HasPrivateBucket() {
+		return nil
+	}
+	key := snapshotPrefix(r2Client.Config) + "photos." + now.UTC().Format(backupTimeFormat) + ".json"
+	if err := r2Client.UploadBytes(ctx, jsonData, key, "application/json", "no-cache"); err != nil {
+		return fmt.Errorf("failed to upload photos.json snapshot: %w", err)
+	}
+	snapshots, err := ListSnapshots(ctx, r2Client)
+	if err != nil {
+		return err
+	}
+	var expired []string
+	for _, snapshot := range expiredBackups(snapshots, now) {
+		expired = append(expired, snapshotPrefix(r2Client.Config)+snapshot.Name)
+	}
+	if len(expired) > 0 {
+		if err := r2Client.DeleteObjects(ctx, expired); err != nil {
+			return fmt.Errorf("failed to prune photos.json snapshots: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots of photos.json in R2, newest first
+func ListSnapshots(ctx context.Context, r2Client *storage.R2Client) ([]ManifestBackup, error) {
+	objects, err := r2Client.ListObjects(ctx, snapshotPrefix(r2Client.Config))
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []ManifestBackup
+	for _, object := range objects {
+		name := path.Base(object.Key)
+		stamp, ok := strings.CutPrefix(name, "photos.")
+		if stamp, ok = strings.CutSuffix(stamp, ".json"); !ok {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.UTC)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, ManifestBackup{Name: name, Time: t.Local(), Size: object.Size})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name > snapshots[j].Name })
+	return snapshots, nil
+}
+
+// ListManifestVersions returns the local backups and the R2 snapshots of photos.json, newest
+// first, each compared with the current photos.json. Only versions missing from the cache are
+// read. When the snapshots cannot be listed the local backups are returned. r2Client is nil in
+// local mode.
+func ListManifestVersions(ctx context.Context, rootDir string, r2Client *storage.R2Client) ([]ManifestVersion, error) {
+	current, err := os.ReadFile(ManifestPath(rootDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", OutputFile, err)
+	}
+	currentSummary, err := summarizeManifest(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", OutputFile, err)
+	}
+
+	var versions []ManifestVersion
+	backups, err := ListBackups(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		versions = append(versions, newManifestVersion(VersionLocal, backup))
+	}
+	cache := loadVersionCache(rootDir)
+	summaries := make(map[string]*versionSummary, len(cache))
+	if r2Client != nil && r2Client.Config.HasPrivateBucket() {
+		snapshots, err := ListSnapshots(ctx, r2Client)
+		if err != nil {
+			log.Printf("⚠ Warning: failed to list photos.json snapshots in R2: %v\n", err)
+			// Their summaries are kept for the next listing
+			for id, summary := range cache {
+				if strings.HasPrefix(id, VersionRemote+":") {
+					summaries[id] = summary
+				}
+			}
+		}
+		for _, snapshot := range snapshots {
+			versions = append(versions, newManifestVersion(VersionRemote, snapshot))
+		}
+	}
+
+	changed := false
+	for i := range versions {
+		version := &versions[i]
+		summary, ok := cache[version.ID]
+		if !ok || summary.Size != version.Size {
+			data, err := readManifestVersion(ctx, rootDir, r2Client, version.Source, version.Name)
+			if err == nil {
+				summary, err = summarizeManifest(data)
+			}
+			if err != nil {
+				version.Error = err.Error()
+				continue
+			}
+			changed = true
+		}
+		summaries[version.ID] = summary
+		version.Photos = len(summary.Entries)
+		version.Current = summary.Hash == currentSummary.Hash
+		version.Diff = summary.stats(currentSummary)
+	}
+	// Summaries of versions that no longer exist are dropped
+	if changed || len(summaries) != len(cache) {
+		if err := saveVersionCache(rootDir, summaries); err != nil {
+			log.Printf("⚠ Warning: failed to save version cache: %v\n", err)
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Time.After(versions[j].Time) })
+	return versions, nil
+}
+
+// newManifestVersion returns the version of a backup or snapshot
+func newManifestVersion(source string, backup ManifestBackup) ManifestVersion {
+	return ManifestVersion{
+		ID:     source + ":" + backup.Name,
+		Source: source,
+		Name:   backup.Name,
+		Time:   backup.Time,
+		Size:   backup.Size,
+	}
+}
+
+// LoadManifestVersion returns the content of a version listed by ListManifestVersions, or of
+// the current photos.json for CurrentVersion, checked to be a valid photos.json
+func LoadManifestVersion(ctx context.Context, rootDir string, r2Client *storage.R2Client, id string) ([]byte, error) {
+	if id == CurrentVersion {
+		data, err := os.ReadFile(ManifestPath(rootDir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", OutputFile, err)
+		}
+		return data, nil
+	}
+
+	source, name, _ := strings.Cut(id, ":")
+	var backups []ManifestBackup
+	var err error
+	switch source {
+	case VersionLocal:
+		backups, err = ListBackups(rootDir)
+	case VersionRemote:
+		if r2Client == nil {
+			return nil, fmt.Errorf("%w: %s, R2 is not configured", ErrVersionNotFound, id)
+		}
+		backups, err = ListSnapshots(ctx, r2Client)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only listed names are read, so an id cannot point outside the backups
+	found := false
+	for _, backup := range backups {
+		found = found || backup.Name == name
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, id)
+	}
+
+	data, err := readManifestVersion(ctx, rootDir, r2Client, source, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ParseManifest(data); err != nil {
+		return nil, fmt.Errorf("version %s is not a valid %s: %w", id, OutputFile, err)
+	}
+	return data, nil
+}
+
+// readManifestVersion reads a backup or snapshot and checks that it parses
+func readManifestVersion(ctx context.Context, rootDir string, r2Client *storage.R2Client, source, name string) ([]byte, error) {
+	var data []byte
+	var err error
+	if source == VersionRemote {
+		data, err = r2Client.DownloadBytes(ctx, snapshotPrefix(r2Client.Config)+name)
+	} else {
+		data, err = os.ReadFile(filepath.Join(BackupDirPath(rootDir), name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%s is not valid JSON", name)
+	}
+	return data, nil
+}
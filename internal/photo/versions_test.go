@@ -0,0 +1,94 @@
+package photo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListManifestVersions(t *testing.T) {
+	t.Setenv("PHOTO_STATE_DIR", "")
+	root := t.TempDir()
+	manifest := func(photos ...Photo) []byte {
+		data, err := MarshalManifest(organizeAlbums(photos))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	a := Photo{Filename: "a.jpg", Year: "2024", Date: "2024-05-01"}
+	b := Photo{Filename: "b.jpg", Year: "2024", Date: "2024-05-02"}
+	c := Photo{Filename: "c.jpg", Year: "2023", Date: "2023-05-02"}
+	changed := b
+	changed.Alt = "海边"
+
+	current := manifest(a, changed)
+	if err := os.MkdirAll(filepath.Dir(ManifestPath(root)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ManifestPath(root), current, 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	backups := []struct {
+		data []byte
+		at   time.Time
+	}{
+		{manifest(a, b, c), now.Add(-2 * time.Hour)},
+		{current, now.Add(-time.Hour)},
+	}
+	for _, backup := range backups {
+		if err := writeBackup(root, backup.data, backup.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake, client := newFakeR2(t)
+	fake.failList = true // Snapshots cannot be listed, the local backups are still returned
+
+	versions, err := ListManifestVersions(context.Background(), root, client)
+	if err != nil {
+		t.Fatalf("ListManifestVersions() error = %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("ListManifestVersions() returned %d versions, want 2", len(versions))
+	}
+	newest, oldest := versions[0], versions[1]
+	if !newest.Current || newest.Photos != 2 || newest.Diff != (ManifestStats{PhotosBefore: 2, PhotosAfter: 2, BytesBefore: int64(len(current)), BytesAfter: int64(len(current))}) {
+		t.Errorf("newest version = %+v, want the current one", newest)
+	}
+	wantDiff := ManifestStats{
+		PhotosBefore: 2, PhotosAfter: 3, Added: 1, Changed: 1,
+		BytesBefore: int64(len(current)), BytesAfter: int64(len(backups[0].data)),
+	}
+	if oldest.Current || oldest.Photos != 3 || oldest.Diff != wantDiff || oldest.Error != "" {
+		t.Errorf("oldest version = %+v, want 3 photos and diff %+v", oldest, wantDiff)
+	}
+
+	// Cached versions are not read again
+	path := filepath.Join(BackupDirPath(root), oldest.Name)
+	if err := os.WriteFile(path, bytes.Repeat([]byte(" "), int(oldest.Size)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := ListManifestVersions(context.Background(), root, client)
+	if err != nil {
+		t.Fatalf("ListManifestVersions() error = %v", err)
+	}
+	if cached[1].Error != "" || cached[1].Diff != wantDiff {
+		t.Errorf("cached version = %+v, want diff %+v", cached[1], wantDiff)
+	}
+
+	// A changed version is read again
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := ListManifestVersions(context.Background(), root, client)
+	if err != nil {
+		t.Fatalf("ListManifestVersions() error = %v", err)
+	}
+	if reread[1].Photos != 0 || reread[1].Diff.Removed != 2 {
+		t.Errorf("changed version = %+v, want no photos", reread[1])
+	}
+}
@@ -0,0 +1,196 @@
+package photo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+// StoredKeys returns the keys the objects of a photo are stored under, which differ from KeysFor
+// when it was hidden or shown and its display image and thumbnail were not moved yet
+func StoredKeys(cfg storage.R2Config, photo Photo) ObjectKeys {
+	keys, _ := storedKeys(cfg, photo)
+	return keys
+}
+
+// storedKeys returns the keys the URLs of a photo point to, and false with the keys of KeysFor
+// when they point to neither side, e.g. a photo that was never uploaded
+func storedKeys(cfg storage.R2Config, photo Photo) (ObjectKeys, bool) {
+	keys := KeysFor(cfg, photo)
+	hidden, shown := photo, photo
+	hidden.IsHidden, shown.IsHidden = true, false
+	private, public := KeysFor(cfg, hidden), KeysFor(cfg, shown)
+	// The private keys end with the public ones, so they are matched first. Earlier versions
+	// stored CDN URLs for private keys too.
+	switch {
+	case photo.Path == storage.PrivateURLScheme+private.Original || strings.HasSuffix(photo.Path, "/"+private.Original):
+		keys.Original, keys.Thumbnail = private.Original, private.Thumbnail
+	case strings.HasSuffix(photo.Path, "/"+public.Original):
+		keys.Original, keys.Thumbnail = public.Original, public.Thumbnail
+	default:
+		return keys, false
+	}
+	return keys, true
+}
+
+// VisibilityMove is a photo whose display image and thumbnail were copied to the other bucket
+// when it was hidden or shown. The entry references the copies; Commit removes the old objects
+// once the manifest is written, Abort removes the copies when it is not. A nil move does nothing.
+type VisibilityMove struct {
+	Filename string
+	Path     string // URL of the copied display image
+	from, to []string
+	p        *PhotoProcessor
+}
+
+// MoveVisibility copies the display image and thumbnail of a photo to the private bucket when it
+// is hidden, or back when it is shown, and points its URLs to the copies. Where the objects are is
+// read from the URLs of the entry. It returns nil when nothing had to move; r2Client is nil in
+// local mode.
+func MoveVisibility(ctx context.Context, r2Client *storage.R2Client, photo *Photo) (*VisibilityMove, error) {
+	if r2Client == nil {
+		return nil, nil
+	}
+	if photo.IsHidden && !r2Client.Config.HasPrivateBucket() {
+		log.Printf("⚠ Warning: %v, %s stays unlisted in the public bucket\n", storage.ErrNoPrivateBucket, photo.Filename)
+	}
+	return (&PhotoProcessor{R2Client: r2Client}).moveVisibility(ctx, photo, nil)
+}
+
+// RepairVisibility moves the objects of the photos of albums whose URLs cannot be trusted, e.g. of
+// a rolled back manifest: where the objects are is looked up in R2. On failure the moves made so
+// far are aborted.
+func RepairVisibility(ctx context.Context, r2Client *storage.R2Client, albums []YearAlbum) ([]*VisibilityMove, error) {
+	if r2Client == nil {
+		return nil, nil
+	}
+	cfg := r2Client.Config
+	existing := make(map[string]bool)
+	for _, prefix := range []string{cfg.BasePrefix + cfg.OriginalPrefix, cfg.PrivatePrefix + cfg.BasePrefix + cfg.OriginalPrefix} {
+		objects, err := r2Client.ListObjects(ctx, prefix)
+		if errors.Is(err, storage.ErrNoPrivateBucket) {
+			continue // Nothing can be stored there
+		} else if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			existing[object.Key] = true
+		}
+	}
+
+	p := &PhotoProcessor{R2Client: r2Client}
+	var moves []*VisibilityMove
+	for i := range albums {
+		for j := range albums[i].Photos {
+			move, err := p.moveVisibility(ctx, &albums[i].Photos[j], existing)
+			if err != nil {
+				for _, move := range moves {
+					move.Abort(ctx)
+				}
+				return nil, err
+			}
+			if move != nil {
+				moves = append(moves, move)
+			}
+		}
+	}
+	return moves, nil
+}
+
+// moveVisibility copies the objects of a photo stored on the wrong side, recording the copies in
+// a dry run. Where the objects are is read from the URLs, or from existing keys when given.
+func (p *PhotoProcessor) moveVisibility(ctx context.Context, photo *Photo, existing map[string]bool) (*VisibilityMove, error) {
+	to := KeysFor(p.R2Client.Config, *photo)
+	from, ok := storedKeys(p.R2Client.Config, *photo)
+	if existing != nil {
+		from, ok = locateObjects(p.R2Client.Config, *photo, existing)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	path, thumbnail := p.R2Client.ObjectURL(to.Original), p.R2Client.ObjectURL(to.Thumbnail)
+	if from.Original == to.Original {
+		// In place, earlier versions may have stored a CDN URL of a private key
+		photo.Path, photo.Thumbnail = path, thumbnail
+		return nil, nil
+	}
+	if !p.R2Client.Config.HasPrivateBucket() {
+		return nil, nil // Moved once a private bucket is configured, hidden photos are not published meanwhile
+	}
+
+	move := &VisibilityMove{Filename: photo.Filename, Path: path, p: p}
+	for _, pair := range [][2]string{{from.Original, to.Original}, {from.Thumbnail, to.Thumbnail}} {
+		if err := p.copyObject(ctx, pair[0], pair[1], photo.Filename); err != nil {
+			move.Abort(ctx)
+			return nil, fmt.Errorf("failed to move %s: %w", pair[0], err)
+		}
+		move.from, move.to = append(move.from, pair[0]), append(move.to, pair[1])
+	}
+	photo.Path, photo.Thumbnail = path, thumbnail
+	return move, nil
+}
+
+// locateObjects returns the keys under which the display image of a photo exists, false when it
+// exists on neither side
+func locateObjects(cfg storage.R2Config, photo Photo, existing map[string]bool) (ObjectKeys, bool) {
+	keys := KeysFor(cfg, photo)
+	if existing[keys.Original] {
+		return keys, true
+	}
+	toggled := photo
+	toggled.IsHidden = !photo.IsHidden
+	if other := KeysFor(cfg, toggled); existing[other.Original] {
+		keys.Original, keys.Thumbnail = other.Original, other.Thumbnail
+		return keys, true
+	}
+	return keys, false
+}
+
+// Commit removes the objects the photo was moved from, or plans their removal in a dry run
+func (m *VisibilityMove) Commit(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	if m.p.Options.DryRun {
+		m.p.planDeletes(ctx, m.from)
+		return nil
+	}
+	if err := m.p.R2Client.DeleteObjects(ctx, m.from); err != nil {
+		return fmt.Errorf("failed to remove %s after moving it: %w", strings.Join(m.from, ", "), err)
+	}
+	return nil
+}
+
+// Abort removes the copies of a move whose manifest was not written
+func (m *VisibilityMove) Abort(ctx context.Context) error {
+	if m == nil || m.p.Options.DryRun || len(m.to) == 0 {
+		return nil
+	}
+	if err := m.p.R2Client.DeleteObjects(ctx, m.to); err != nil {
+		return fmt.Errorf("failed to remove %s after a failed move: %w", strings.Join(m.to, ", "), err)
+	}
+	return nil
+}
+
+// finishMoves commits the moves whose copies the written manifest references and aborts the
+// others, e.g. of photos whose visibility was changed again in the admin panel meanwhile
+func finishMoves(ctx context.Context, moves []*VisibilityMove, written bool, photos []Photo) error {
+	paths := make(map[string]string, len(photos))
+	for _, photo := range photos {
+		paths[photo.Filename] = photo.Path
+	}
+	var errs []error
+	for _, move := range moves {
+		if written && paths[move.Filename] == move.Path {
+			errs = append(errs, move.Commit(ctx))
+		} else {
+			errs = append(errs, move.Abort(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package photo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMoveVisibility(t *testing.T) {
+	tests := []struct {
+		name      string
+		noPrivate bool // No private bucket is configured
+		wantPath  string
+		wantMove  bool
+	}{
+		{name: "hidden photo moved to the private bucket", wantPath: "r2-private:private/photos/originals/a.jpg", wantMove: true},
+		{name: "no private bucket keeps the objects in place", noPrivate: true, wantPath: "https://cdn.example.com/photos/originals/a.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeR2(t)
+			if tt.noPrivate {
+				client.Config.PrivateBucket = ""
+			}
+			fake.put(testBucket, "photos/originals/a.jpg")
+			fake.put(testBucket, "photos/thumbnails/a.webp")
+			photo := Photo{
+				Filename:  "a.jpg",
+				Path:      "https://cdn.example.com/photos/originals/a.jpg",
+				Thumbnail: "https://cdn.example.com/photos/thumbnails/a.webp",
+				IsHidden:  true,
+			}
+
+			move, err := MoveVisibility(context.Background(), client, &photo)
+			if err != nil {
+				t.Fatalf("MoveVisibility() error = %v", err)
+			}
+			if (move != nil) != tt.wantMove {
+				t.Fatalf("MoveVisibility() move = %v, want a move %v", move, tt.wantMove)
+			}
+			if photo.Path != tt.wantPath {
+				t.Errorf("path = %s, want %s", photo.Path, tt.wantPath)
+			}
+			if err := move.Commit(context.Background()); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+			if got := fake.has(testBucket, "photos/originals/a.jpg"); got != tt.noPrivate {
+				t.Errorf("public original exists = %v, want %v", got, tt.noPrivate)
+			}
+			if got := fake.has(testPrivateBucket, "private/photos/originals/a.jpg"); got != tt.wantMove {
+				t.Errorf("private original exists = %v, want %v", got, tt.wantMove)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package photo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vincentchyu/vincentchyu.github.io/internal/storage"
+)
+
+// WritebackMode controls whether metadata edited in the admin is written into the image files
+type WritebackMode string
+
+const (
+	WritebackOff     WritebackMode = "off"     // photos.json only (default)
+	WritebackEmbed   WritebackMode = "embed"   // XMP/IPTC inside the image file
+	WritebackSidecar WritebackMode = "sidecar" // XMP sidecar next to the image ("DSC_0001.xmp")
+)
+
+// ExtXMP is the sidecar file extension
+const ExtXMP = ".xmp"
+
+// PhotoMetadata is the editable metadata that can be written back to image files
+type PhotoMetadata struct {
+	Title    string
+	Caption  string // Alt
+	Keywords []string
+	Rating   int
+}
+
+// MetadataOf returns the editable metadata of a photo
+func MetadataOf(photo Photo) PhotoMetadata {
+	return PhotoMetadata{Title: photo.Title, Caption: photo.Alt, Keywords: photo.Subject, Rating: photo.Rating}
+}
+
+// CurrentWritebackMode reads PHOTO_METADATA_WRITEBACK, defaulting to off
+func CurrentWritebackMode() WritebackMode {
+	switch mode := WritebackMode(strings.ToLower(os.Getenv("PHOTO_METADATA_WRITEBACK"))); mode {
+	case WritebackEmbed, WritebackSidecar:
+		return mode
+	default:
+		return WritebackOff
+	}
+}
+
+// SidecarPath returns the XMP sidecar path for an image
+func SidecarPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + ExtXMP
+}
+
+// WriteMetadata writes the metadata into the image (embed) or its sidecar.
+//
+// exiftool writes to a temporary file in the same directory which is then renamed over the
+// target, so a crash never leaves a half written image behind.
+func WriteMetadata(ctx context.Context, imagePath string, meta PhotoMetadata, mode WritebackMode) error {
+	var target, source string
+	cmdArgs := []string{"-charset", "utf8", "-charset", "iptc=utf8"}
+
+	switch mode {
+	case WritebackEmbed:
+		target, source = imagePath, imagePath
+	case WritebackSidecar:
+		target = SidecarPath(imagePath)
+		source = target
+		if _, err := os.Stat(target); err != nil {
+			// Create the sidecar from the image's own XMP so nothing already there is lost
+			source = imagePath
+			cmdArgs = append(cmdArgs, "-tagsFromFile", imagePath, "-xmp:all")
+		}
+	default:
+		return nil
+	}
+
+	tmp := fmt.Sprintf("%s.tmp-%d%s", strings.TrimSuffix(target, filepath.Ext(target)), os.Getpid(), filepath.Ext(target))
+	_ = os.Remove(tmp) // exiftool refuses to overwrite an existing output file
+
+	cmdArgs = append(cmdArgs, metadataTagArgs(meta, mode == WritebackEmbed)...)
+	cmdArgs = append(cmdArgs, "-o", tmp, source)
+
+	cmd := exec.CommandContext(ctx, "exiftool", cmdArgs...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("exiftool write failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(target), err)
+	}
+	return nil
+}
+
+// SnapshotMetadata saves the file WriteMetadata changes in mode, the image or its sidecar, and
+// returns a function that puts it back, removing a sidecar that did not exist
+func SnapshotMetadata(imagePath string, mode WritebackMode) (func() error, error) {
+	path := imagePath
+	if mode == WritebackSidecar {
+		path = SidecarPath(imagePath)
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return func() error {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return writeFileAtomic(path, data, info.Mode().Perm()) }, nil
+}
+
+// UploadEmbedded uploads an image whose metadata was embedded to the R2 objects that are copies
+// of it: the original when it is served as it is, and the RAW archive of a RAW photo. Display
+// images converted from RAW or HEIC files do not carry the metadata. The CDN may serve a cached
+// copy of the original until it expires. r2Client is nil in local mode.
+func UploadEmbedded(ctx context.Context, r2Client *storage.R2Client, photo Photo, imagePath string) error {
+	if r2Client == nil {
+		return nil
+	}
+	keys := StoredKeys(r2Client.Config, photo)
+	if !r2Client.Config.HasPrivateBucket() {
+		// Unreachable objects are left as they are, e.g. of photos hidden by earlier versions
+		if r2Client.Config.IsPrivateKey(keys.Original) {
+			keys.Original = ""
+		}
+		keys.Raw = ""
+	}
+	if keys.Original != "" && !servedAsJPEG(photo) {
+		if _, err := r2Client.UploadFile(ctx, imagePath, keys.Original, "public, max-age=31536000"); err != nil {
+			return fmt.Errorf("failed to upload original %s: %w", photo.Filename, err)
+		}
+	}
+	if keys.Raw != "" && photo.Raw == photo.Filename {
+		if _, err := r2Client.UploadFile(ctx, imagePath, keys.Raw, "private, no-store"); err != nil {
+			return fmt.Errorf("failed to archive raw %s: %w", photo.Raw, err)
+		}
+	}
+	return nil
+}
+
+// metadataTagArgs builds the exiftool assignments, IPTC is only written into images
+func metadataTagArgs(meta PhotoMetadata, withIPTC bool) []string {
+	args := []string{
+		"-XMP-dc:Title=" + meta.Title,
+		"-XMP-dc:Description=" + meta.Caption,
+		"-XMP-dc:Subject=",
+		"-XMP-xmp:Rating=" + strconv.Itoa(meta.Rating),
+	}
+	if withIPTC {
+		args = append(
+			args,
+			"-IPTC:CodedCharacterSet=UTF8",
+			"-IPTC:ObjectName="+meta.Title,
+			"-IPTC:Caption-Abstract="+meta.Caption,
+			"-IPTC:Keywords=",
+		)
+	}
+	for _, keyword := range meta.Keywords {
+		args = append(args, "-XMP-dc:Subject+="+keyword)
+		if withIPTC {
+			args = append(args, "-IPTC:Keywords+="+keyword)
+		}
+	}
+	return args
+}
+
+// ReadSidecar reads the metadata of an XMP sidecar with exiftool, returning false if there is none
+func ReadSidecar(ctx context.Context, imagePath string) (PhotoMetadata, bool) {
+	sidecar := SidecarPath(imagePath)
+	if _, err := os.Stat(sidecar); err != nil {
+		return PhotoMetadata{}, false
+	}
+
+	output, err := exec.CommandContext(ctx, "exiftool", "-json", "-charset", "utf8", sidecar).Output()
+	if err != nil {
+		return PhotoMetadata{}, false
+	}
+	var results []map[string]interface{}
+	if err := json.Unmarshal(output, &results); err != nil || len(results) == 0 {
+		return PhotoMetadata{}, false
+	}
+	return metadataFromExif(results[0]), true
+}
+
+// metadataFromExif collects the editable metadata from extracted EXIF/XMP/IPTC values
+func metadataFromExif(exifData map[string]interface{}) PhotoMetadata {
+	meta := PhotoMetadata{}
+	for _, key := range []string{"Title", "ObjectName"} {
+		if meta.Title = exifString(exifData, key); meta.Title != "" {
+			break
+		}
+	}
+	for _, key := range []string{"Description", "Caption-Abstract", "ImageDescription"} {
+		if meta.Caption = exifString(exifData, key); meta.Caption != "" {
+			break
+		}
+	}
+	meta.Keywords = exifStrings(exifData, "Subject")
+	if len(meta.Keywords) == 0 {
+		meta.Keywords = exifStrings(exifData, "Keywords")
+	}
+	if rating, err := strconv.Atoi(exifString(exifData, "Rating")); err == nil {
+		meta.Rating = rating
+	}
+	return meta
+}
+
+// mergeMetadata returns base with every non-empty field of override applied
+func mergeMetadata(base, override PhotoMetadata) PhotoMetadata {
+	if override.Title != "" {
+		base.Title = override.Title
+	}
+	if override.Caption != "" {
+		base.Caption = override.Caption
+	}
+	if len(override.Keywords) > 0 {
+		base.Keywords = override.Keywords
+	}
+	if override.Rating != 0 {
+		base.Rating = override.Rating
+	}
+	return base
+}
+
+// mergeIntoPhoto fills the photo's empty metadata fields from meta
+func mergeIntoPhoto(photo Photo, meta PhotoMetadata) Photo {
+	merged := mergeMetadata(meta, MetadataOf(photo))
+	photo.Title, photo.Alt, photo.Subject, photo.Rating = merged.Title, merged.Caption, merged.Keywords, merged.Rating
+	return photo
+}
+
+// exifStrings returns a list valued tag, which exiftool reports as a string when it has one entry
+func exifStrings(exifData map[string]interface{}, key string) []string {
+	var values []string
+	switch v := exifData[key].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+	case string:
+		if v != "" {
+			values = []string{v}
+		}
+	}
+	return values
+}
+
+// LocateSource finds the source image of a photo under imagesDir.
+// Older manifests have no Source, so fall back to <year>/<filename> and finally a search.
+func LocateSource(imagesDir string, photo Photo) (string, error) {
+	candidates := []string{}
+	if photo.Source != "" {
+		candidates = append(candidates, filepath.Join(imagesDir, filepath.FromSlash(photo.Source)))
+	}
+	if photo.Year != "" {
+		candidates = append(candidates, filepath.Join(imagesDir, photo.Year, photo.Filename))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	var found string
+	_ = filepath.WalkDir(
+		imagesDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if d.Name() == photo.Filename {
+				found = path
+				return fs.SkipAll
+			}
+			return nil
+		},
+	)
+	if found == "" {
+		return "", fmt.Errorf("source file not found for %s", photo.Filename)
+	}
+	return found, nil
+}
@@ -41,11 +41,11 @@ func init() {
 	)
 }
 
-func CfKvGetValue(keyName string) (value string, err error) {
+func CfKvGetValue(ctx context.Context, keyName string) (value string, err error) {
 	config := kVConfig
 	// read
 	resp, err := CFCli.KV.Namespaces.Values.Get(
-		context.TODO(),
+		ctx,
 		config.DatabaseId,
 		keyName,
 		kv.NamespaceValueGetParams{
@@ -75,18 +75,42 @@ func CfKvGetValue(keyName string) (value string, err error) {
 	return string(b), nil
 }
 
-func CfKvSetValue(keyName, value string, expirationTtl float64) error {
+// CfKvSetValue writes a value, expirationTtl 0 keeps it until it is overwritten or deleted
+func CfKvSetValue(ctx context.Context, keyName, value string, expirationTtl float64) error {
 	config := kVConfig
+	params := kv.NamespaceValueUpdateParams{
+		AccountID: cloudflare.F(config.AccountId),
+		Value:     cloudflare.F(value),
+		Metadata:  cloudflare.F[any](map[string]interface{}{}),
+	}
+	if expirationTtl > 0 {
+		params.ExpirationTTL = cloudflare.F(expirationTtl)
+	}
 	// write
 	_, err := CFCli.KV.Namespaces.Values.Update(
-		context.TODO(),
+		ctx,
+		config.DatabaseId,
+		keyName,
+		params,
+	)
+	if err != nil {
+		var upper *cloudflare.Error
+		if errors.As(err, &upper) {
+			return upper
+		}
+	}
+	return err
+}
+
+// CfKvDeleteValue removes a value
+func CfKvDeleteValue(ctx context.Context, keyName string) error {
+	config := kVConfig
+	_, err := CFCli.KV.Namespaces.Values.Delete(
+		ctx,
 		config.DatabaseId,
 		keyName,
-		kv.NamespaceValueUpdateParams{
-			AccountID:     cloudflare.F(config.AccountId),
-			Value:         cloudflare.F(value),
-			ExpirationTTL: cloudflare.F(expirationTtl),
-			Metadata:      cloudflare.F[any](map[string]interface{}{}),
+		kv.NamespaceValueDeleteParams{
+			AccountID: cloudflare.F(config.AccountId),
 		},
 	)
 	if err != nil {
@@ -3,8 +3,11 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,10 +22,25 @@ import (
 
 const R2RequestTimeout = 360 * time.Second
 
+// rawContentType is the content type of camera RAW files
+const rawContentType = "image/x-dcraw"
+
+// heicContentType is the content type of HEIC/HEIF images
+const heicContentType = "image/heic"
+
+// PrivateURLScheme prefixes the key of an object in the private bucket where a URL is expected.
+// The private bucket has no public domain, the admin server reads these objects for previews.
+const PrivateURLScheme = "r2-private:"
+
+// ErrNoPrivateBucket is returned for private objects when no private bucket is configured, they are
+// never stored in the public bucket
+var ErrNoPrivateBucket = errors.New("no private R2 bucket configured (R2_PRIVATE_BUCKET)")
+
 // R2Config holds the configuration for Cloudflare R2
 type R2Config struct {
 	Endpoint        string
 	Bucket          string
+	PrivateBucket   string // Bucket without a public domain, for the keys below PrivatePrefix
 	Region          string
 	AccessKeyID     string
 	SecretAccessKey string
@@ -30,6 +48,8 @@ type R2Config struct {
 	BasePrefix      string // e.g., "photos/"
 	OriginalPrefix  string // e.g., "originals/"
 	ThumbnailPrefix string // e.g., "thumbnails/"
+	PrivatePrefix   string // e.g., "private/", objects that are never referenced by the public gallery, kept in PrivateBucket
+	TrashPrefix     string // e.g., "trash/", deleted objects kept until the retention period ends
 }
 
 // R2Client wraps the S3 client for R2 operations
@@ -44,6 +64,7 @@ func LoadR2Config() (*R2Config, error) {
 	config := &R2Config{
 		Endpoint:        getEnv("NUXT_PROVIDER_S3_ENDPOINT", "R2_ENDPOINT"),
 		Bucket:          getEnv("NUXT_PROVIDER_S3_BUCKET", "R2_BUCKET"),
+		PrivateBucket:   getEnv("NUXT_PROVIDER_S3_PRIVATE_BUCKET", "R2_PRIVATE_BUCKET"),
 		Region:          getEnv("NUXT_PROVIDER_S3_REGION", "R2_REGION"),
 		AccessKeyID:     getEnv("NUXT_PROVIDER_S3_ACCESS_KEY_ID", "R2_ACCESS_KEY_ID"),
 		SecretAccessKey: getEnv("NUXT_PROVIDER_S3_SECRET_ACCESS_KEY", "R2_SECRET_ACCESS_KEY"),
@@ -53,12 +74,23 @@ func LoadR2Config() (*R2Config, error) {
 		ThumbnailPrefix: getEnvWithDefault(
 			"thumbnails/", "NUXT_PROVIDER_S3_PREFIX_THUMBNAIL_BASE", "R2_THUMBNAIL_PREFIX",
 		),
+		PrivatePrefix: getEnvWithDefault("private/", "NUXT_PROVIDER_S3_PRIVATE_PREFIX", "R2_PRIVATE_PREFIX"),
+		TrashPrefix:   getEnvWithDefault("trash/", "NUXT_PROVIDER_S3_TRASH_PREFIX", "R2_TRASH_PREFIX"),
 	}
 
 	// Validate required fields
 	if config.Endpoint == "" || config.Bucket == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
 		return nil, fmt.Errorf("missing required R2 configuration")
 	}
+	if config.PrivateBucket == config.Bucket {
+		return nil, fmt.Errorf("the private R2 bucket must differ from the public bucket %s", config.Bucket)
+	}
+	if !config.HasPrivateBucket() {
+		log.Printf(
+			"⚠ Warning: %v, hidden photos stay unlisted in the public bucket, RAW archives, manifest snapshots and the trash are skipped\n",
+			ErrNoPrivateBucket,
+		)
+	}
 
 	return config, nil
 }
@@ -116,44 +148,114 @@ func NewR2Client(config *R2Config) (*R2Client, error) {
 	}, nil
 }
 
+// IsPrivateKey reports whether an object is kept in the private bucket: private objects and the
+// trash, which holds deleted photos whatever their visibility was
+func (c R2Config) IsPrivateKey(key string) bool {
+	for _, prefix := range c.privatePrefixes() {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPrivateBucket reports whether objects below the private and trash prefixes can be stored
+func (c R2Config) HasPrivateBucket() bool {
+	return c.PrivateBucket != ""
+}
+
+// privatePrefixes returns the prefixes of the objects kept in the private bucket
+func (c R2Config) privatePrefixes() []string {
+	var prefixes []string
+	for _, prefix := range []string{c.PrivatePrefix, c.TrashPrefix} {
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// bucketFor returns the bucket an object is kept in, private keys never go to the public bucket
+func (r *R2Client) bucketFor(key string) (string, error) {
+	if !r.Config.IsPrivateKey(key) {
+		return r.Config.Bucket, nil
+	}
+	if !r.Config.HasPrivateBucket() {
+		return "", fmt.Errorf("%s: %w", key, ErrNoPrivateBucket)
+	}
+	return r.Config.PrivateBucket, nil
+}
+
 // CheckFileExists checks if a file exists in R2
-func (r *R2Client) CheckFileExists(key string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+func (r *R2Client) CheckFileExists(ctx context.Context, key string) bool {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
-	_, err := r.client.HeadObject(
+	bucket, err := r.bucketFor(key)
+	if err != nil {
+		return false
+	}
+	_, err = r.client.HeadObject(
 		ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(r.Config.Bucket),
+			Bucket: aws.String(bucket),
 			Key:    aws.String(key),
 		},
 	)
 	return err == nil
 }
 
-// UploadFile uploads a file to R2
-func (r *R2Client) UploadFile(localPath, key, cacheControl string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+// ObjectSize returns the size of an object in R2
+func (r *R2Client) ObjectSize(ctx context.Context, key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
+	bucket, err := r.bucketFor(key)
+	if err != nil {
+		return 0, err
+	}
+	out, err := r.client.HeadObject(
+		ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object %s from R2: %w", key, err)
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+// UploadFile uploads a file to R2 and returns the number of bytes sent, which is less than the
+// file size when the image was compressed
+func (r *R2Client) UploadFile(ctx context.Context, localPath, key, cacheControl string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	bucket, err := r.bucketFor(key)
+	if err != nil {
+		return 0, err
+	}
+
 	// Read file
 	fileData, err := os.ReadFile(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return 0, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Determine content type
 	contentType := getContentType(localPath)
 	// Upload to R2
 	// Default input fields
+	size := int64(len(fileData))
 	input := &s3.PutObjectInput{
-		Bucket:      aws.String(r.Config.Bucket),
+		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(fileData),
 		ContentType: aws.String(contentType),
 	}
 
-	// Check if image needs compression (only for images)
-	if strings.Contains(contentType, "image/") {
+	// Check if image needs compression (only for images the standard decoders can read)
+	if strings.Contains(contentType, "image/") && contentType != rawContentType && contentType != heicContentType {
 		// Try to compress if > 10MB
 		compressedData, newContentType, err := imaging.CompressImage(localPath)
 		if err != nil {
@@ -164,6 +266,7 @@ func (r *R2Client) UploadFile(localPath, key, cacheControl string) error {
 			// Use compressed data
 			log.Printf("Uploaded compressed image: %s (Original: %.2f MB, Compressed: %.2f MB)\n", localPath, float64(len(fileData))/1024/1024, float64(len(compressedData))/1024/1024)
 			input.Body = bytes.NewReader(compressedData)
+			size = int64(len(compressedData))
 			if newContentType != "" {
 				input.ContentType = aws.String(newContentType)
 			}
@@ -177,19 +280,23 @@ func (r *R2Client) UploadFile(localPath, key, cacheControl string) error {
 	_, err = r.client.PutObject(ctx, input)
 
 	if err != nil {
-		return fmt.Errorf("failed to upload to R2: %w", err)
+		return 0, fmt.Errorf("failed to upload to R2: %w", err)
 	}
 
-	return nil
+	return size, nil
 }
 
 // UploadBytes uploads byte data to R2
-func (r *R2Client) UploadBytes(data []byte, key, contentType, cacheControl string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+func (r *R2Client) UploadBytes(ctx context.Context, data []byte, key, contentType, cacheControl string) error {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
+	bucket, err := r.bucketFor(key)
+	if err != nil {
+		return err
+	}
 	input := &s3.PutObjectInput{
-		Bucket:      aws.String(r.Config.Bucket),
+		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(data),
 		ContentType: aws.String(contentType),
@@ -199,7 +306,7 @@ func (r *R2Client) UploadBytes(data []byte, key, contentType, cacheControl strin
 		input.CacheControl = aws.String(cacheControl)
 	}
 
-	_, err := r.client.PutObject(ctx, input)
+	_, err = r.client.PutObject(ctx, input)
 
 	if err != nil {
 		return fmt.Errorf("failed to upload to R2: %w", err)
@@ -208,14 +315,123 @@ func (r *R2Client) UploadBytes(data []byte, key, contentType, cacheControl strin
 	return nil
 }
 
+// CopyObject copies an object on the server side, keeping its content type and cache control.
+// Keys below the private prefix are copied from or to the private bucket.
+func (r *R2Client) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	srcBucket, err := r.bucketFor(srcKey)
+	if err != nil {
+		return err
+	}
+	dstBucket, err := r.bucketFor(dstKey)
+	if err != nil {
+		return err
+	}
+	return r.copyBetween(ctx, srcBucket, srcKey, dstBucket, dstKey)
+}
+
+// copyBetween copies an object from one bucket to another
+func (r *R2Client) copyBetween(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	_, err := r.client.CopyObject(
+		ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(dstBucket),
+			Key:               aws.String(dstKey),
+			CopySource:        aws.String((&url.URL{Path: srcBucket + "/" + srcKey}).EscapedPath()),
+			MetadataDirective: types.MetadataDirectiveCopy,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s in R2: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// ObjectInfo describes an object listed in R2
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects returns every object whose key starts with prefix
+func (r *R2Client) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	bucket, err := r.bucketFor(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return r.listBucket(ctx, bucket, prefix)
+}
+
+// listBucket returns every object of a bucket whose key starts with prefix
+func (r *R2Client) listBucket(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(
+		r.client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		},
+	)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in R2: %w", err)
+		}
+		for _, object := range page.Contents {
+			objects = append(
+				objects, ObjectInfo{
+					Key:          aws.ToString(object.Key),
+					Size:         aws.ToInt64(object.Size),
+					LastModified: aws.ToTime(object.LastModified),
+				},
+			)
+		}
+	}
+	return objects, nil
+}
+
+// DownloadBytes returns the content of an object
+func (r *R2Client) DownloadBytes(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
+	defer cancel()
+
+	bucket, err := r.bucketFor(key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.client.GetObject(
+		ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s from R2: %w", key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s from R2: %w", key, err)
+	}
+	return data, nil
+}
+
 // DeleteObject del data to R2
-func (r *R2Client) DeleteObject(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+func (r *R2Client) DeleteObject(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
-	_, err := r.client.DeleteObject(
+	bucket, err := r.bucketFor(key)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.DeleteObject(
 		ctx, &s3.DeleteObjectInput{
-			Bucket:                    aws.String(r.Config.Bucket),
+			Bucket:                    aws.String(bucket),
 			Key:                       aws.String(key),
 			BypassGovernanceRetention: nil,
 			ExpectedBucketOwner:       nil,
@@ -236,13 +452,31 @@ func (r *R2Client) DeleteObject(key string) error {
 	return nil
 }
 
-// DeleteObjects deletes multiple objects from R2 in a batch
-func (r *R2Client) DeleteObjects(keys []string) error {
+// DeleteObjects deletes multiple objects from R2 in a batch per bucket
+func (r *R2Client) DeleteObjects(ctx context.Context, keys []string) error {
+	byBucket := make(map[string][]string)
+	for _, key := range keys {
+		bucket, err := r.bucketFor(key)
+		if err != nil {
+			return err
+		}
+		byBucket[bucket] = append(byBucket[bucket], key)
+	}
+	for bucket, keys := range byBucket {
+		if err := r.deleteFromBucket(ctx, bucket, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFromBucket deletes objects of a bucket in batches
+func (r *R2Client) deleteFromBucket(ctx context.Context, bucket string, keys []string) error {
 	if len(keys) == 0 {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), R2RequestTimeout)
+	ctx, cancel := context.WithTimeout(ctx, R2RequestTimeout)
 	defer cancel()
 
 	var objects []types.ObjectIdentifier
@@ -265,7 +499,7 @@ func (r *R2Client) DeleteObjects(keys []string) error {
 		batch := objects[i:end]
 		_, err := r.client.DeleteObjects(
 			ctx, &s3.DeleteObjectsInput{
-				Bucket: aws.String(r.Config.Bucket),
+				Bucket: aws.String(bucket),
 				Delete: &types.Delete{
 					Objects: batch,
 					Quiet:   aws.Bool(true),
@@ -290,6 +524,40 @@ func (r *R2Client) GetCDNUrl(key string) string {
 	return fmt.Sprintf("%s/%s/%s", r.Config.Endpoint, r.Config.Bucket, key)
 }
 
+// ObjectURL returns the URL stored for an object: its CDN URL, or a reference to the private
+// bucket that only the admin server can read
+func (r *R2Client) ObjectURL(key string) string {
+	if r.Config.IsPrivateKey(key) {
+		return PrivateURLScheme + key
+	}
+	return r.GetCDNUrl(key)
+}
+
+// MigratePrivateObjects moves the private objects and the trash that earlier versions kept in the
+// public bucket to the private bucket, returning how many were moved
+func (r *R2Client) MigratePrivateObjects(ctx context.Context) (int, error) {
+	if !r.Config.HasPrivateBucket() {
+		return 0, nil
+	}
+	var objects []ObjectInfo
+	for _, prefix := range r.Config.privatePrefixes() {
+		listed, err := r.listBucket(ctx, r.Config.Bucket, prefix)
+		if err != nil {
+			return 0, err
+		}
+		objects = append(objects, listed...)
+	}
+	var moved []string
+	for _, object := range objects {
+		if err := r.copyBetween(ctx, r.Config.Bucket, object.Key, r.Config.PrivateBucket, object.Key); err != nil {
+			r.deleteFromBucket(ctx, r.Config.Bucket, moved)
+			return len(moved), err
+		}
+		moved = append(moved, object.Key)
+	}
+	return len(moved), r.deleteFromBucket(ctx, r.Config.Bucket, moved)
+}
+
 // getContentType determines the content type based on file extension
 func getContentType(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
@@ -303,7 +571,7 @@ func getContentType(filename string) string {
 	case ".gif":
 		return "image/gif"
 	case ".heic", ".heif":
-		return "image/heic"
+		return heicContentType
 	case ".avif":
 		return "image/avif"
 	case ".tiff", ".tif":
@@ -314,8 +582,8 @@ func getContentType(filename string) string {
 		return "image/svg+xml"
 	case ".ico":
 		return "image/x-icon"
-	case ".raw", ".arw", ".cr2", ".cr3", ".nef", ".dng", ".orf", ".rw2":
-		return "image/x-dcraw" // Generic RAW type, or application/octet-stream
+	case ".raw", ".arw", ".cr2", ".cr3", ".nef", ".nrw", ".dng", ".orf", ".rw2", ".raf", ".pef", ".srw":
+		return rawContentType // Generic RAW type, or application/octet-stream
 	default:
 		return "application/octet-stream"
 	}
@@ -0,0 +1,265 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultDebounce is the quiet period before a batch is reported, long enough for an
+	// export to finish writing a file
+	DefaultDebounce = 3 * time.Second
+	// DefaultPollInterval is how often the polling fallback scans the tree
+	DefaultPollInterval = 10 * time.Second
+)
+
+// Watcher reports files below Root that were added, changed or removed, in debounced batches
+type Watcher struct {
+	Root         string
+	Debounce     time.Duration          // Defaults to DefaultDebounce
+	PollInterval time.Duration          // Defaults to DefaultPollInterval
+	Poll         bool                   // Scan periodically even where filesystem notifications work
+	Filter       func(path string) bool // Files to report, nil reports every file
+}
+
+// Run watches until ctx is done. onChange receives the paths of a batch, or nil when events
+// were lost and everything has to be rescanned. It is called from Run's goroutine; changes made
+// while it runs are reported in the next batch.
+//
+// Filesystem notifications are used where available, otherwise the tree is polled.
+func (w *Watcher) Run(ctx context.Context, onChange func(paths []string)) error {
+	if !w.Poll {
+		fw, err := fsnotify.NewWatcher()
+		if err == nil {
+			var dirs map[string]bool
+			if dirs, err = w.addTree(fw, w.Root, nil); err == nil {
+				log.Printf("✓ Watching %s (%d directories)\n", w.Root, len(dirs))
+				defer fw.Close()
+				return w.runNotify(ctx, fw, dirs, onChange)
+			}
+			fw.Close()
+		}
+		log.Printf("⚠ Warning: filesystem notifications unavailable (%v), polling every %s\n", err, w.pollInterval())
+	}
+	log.Printf("✓ Polling %s every %s\n", w.Root, w.pollInterval())
+	return w.runPoll(ctx, onChange)
+}
+
+// runNotify collects fsnotify events into batches
+func (w *Watcher) runNotify(ctx context.Context, fw *fsnotify.Watcher, dirs map[string]bool, onChange func([]string)) error {
+	pending := make(map[string]bool)
+	overflow := false
+	timer := time.NewTimer(w.debounce())
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			switch {
+			case event.Has(fsnotify.Create):
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					// fsnotify is not recursive, and files may land before the directory is watched
+					added, err := w.addTree(fw, event.Name, pending)
+					if err != nil {
+						log.Printf("⚠ Warning: failed to watch %s: %v\n", event.Name, err)
+					}
+					for dir := range added {
+						dirs[dir] = true
+					}
+				} else if w.accept(event.Name) {
+					pending[event.Name] = true
+				}
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				// A removed directory is reported itself, the files it contained are gone
+				if dirs[event.Name] || w.accept(event.Name) {
+					pending[event.Name] = true
+				}
+				delete(dirs, event.Name)
+			case event.Has(fsnotify.Write):
+				if w.accept(event.Name) {
+					pending[event.Name] = true
+				}
+			default:
+				continue // Chmod only, e.g. Spotlight or backup tools touching attributes
+			}
+			timer.Reset(w.debounce())
+
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				overflow = true
+				timer.Reset(w.debounce())
+				continue
+			}
+			log.Printf("⚠ Warning: watch error: %v\n", err)
+
+		case <-timer.C:
+			if overflow {
+				log.Println("⚠ Warning: watch events were lost, rescanning everything")
+				onChange(nil)
+			} else if len(pending) > 0 {
+				onChange(sortedKeys(pending))
+			}
+			pending, overflow = make(map[string]bool), false
+		}
+	}
+}
+
+// addTree watches dir and its subdirectories, marking the files found in pending if it is not nil
+func (w *Watcher) addTree(fw *fsnotify.Watcher, dir string, pending map[string]bool) (map[string]bool, error) {
+	dirs := make(map[string]bool)
+	err := filepath.WalkDir(
+		dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != dir && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				if err := fw.Add(path); err != nil {
+					return fmt.Errorf("failed to watch %s: %w", path, err)
+				}
+				dirs[path] = true
+			} else if pending != nil && w.accept(path) {
+				pending[path] = true
+			}
+			return nil
+		},
+	)
+	return dirs, err
+}
+
+// fileState is what polling compares to detect a change
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// runPoll scans the tree every poll interval and reports a batch once a scan finds no new
+// changes and the debounce period has passed since the last one
+func (w *Watcher) runPoll(ctx context.Context, onChange func([]string)) error {
+	previous, err := w.scan()
+	if err != nil {
+		return err
+	}
+	pending := make(map[string]bool)
+	var lastChange time.Time
+
+	ticker := time.NewTicker(w.pollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := w.scan()
+		if err != nil {
+			log.Printf("⚠ Warning: failed to scan %s: %v\n", w.Root, err)
+			continue
+		}
+		changed := false
+		for path, state := range current {
+			if old, ok := previous[path]; !ok || old != state {
+				pending[path], changed = true, true
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				pending[path], changed = true, true
+			}
+		}
+		previous = current
+		if changed {
+			lastChange = time.Now()
+			continue
+		}
+
+		if len(pending) > 0 && time.Since(lastChange) >= w.debounce() {
+			onChange(sortedKeys(pending))
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// scan returns the state of every accepted file below Root
+func (w *Watcher) scan() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := filepath.WalkDir(
+		w.Root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path != w.Root && errors.Is(err, fs.ErrNotExist) {
+					return nil // Removed while scanning
+				}
+				return err
+			}
+			if d.IsDir() {
+				if path != w.Root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !w.accept(path) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		},
+	)
+	return files, err
+}
+
+// accept reports whether a file is reported, hidden files like .DS_Store never are
+func (w *Watcher) accept(path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return false
+	}
+	return w.Filter == nil || w.Filter(path)
+}
+
+func (w *Watcher) debounce() time.Duration {
+	if w.Debounce > 0 {
+		return w.Debounce
+	}
+	return DefaultDebounce
+}
+
+func (w *Watcher) pollInterval() time.Duration {
+	if w.PollInterval > 0 {
+		return w.PollInterval
+	}
+	return DefaultPollInterval
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -25,7 +25,7 @@ case "$1" in
     sh "$SCRIPT_DIR/stop_photograph-management.sh"
     ;;
   update)
-    go run cmd/update-photos/main.go
+    go run cmd/update-photos/main.go "${@:2}"
     ;;
   *)
     echo "用法: $0 {init|start|stop|update}"
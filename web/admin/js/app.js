@@ -16,6 +16,8 @@ const photoGrid = document.getElementById("photoGrid");
 const detailPanel = document.getElementById("detailPanel");
 const rebuildModal = document.getElementById("rebuildModal");
 const r2Modal = document.getElementById("r2Modal");
+const jobsModal = document.getElementById("jobsModal");
+const versionsModal = document.getElementById("versionsModal");
 
 // Initialize
 document.addEventListener("DOMContentLoaded", () => {
@@ -29,11 +31,11 @@ async function loadPhotos() {
     const response = await fetch("/api/photos");
     if (!response.ok) throw new Error("Failed to load photos");
 
-    const albums = await response.json();
+    const manifest = await response.json();
     allPhotos = [];
 
     // Flatten albums into single array
-    albums.forEach((album) => {
+    manifest.albums.forEach((album) => {
       allPhotos.push(...album.photos);
     });
 
@@ -41,12 +43,34 @@ async function loadPhotos() {
     renderPhotos();
     updateStats();
     populateYearFilter();
+    populatePlaceFilter();
   } catch (error) {
     console.error("Error loading photos:", error);
     photoGrid.innerHTML = '<div class="loading">加载失败，请刷新页面重试</div>';
   }
 }
 
+// Helper: Local image URL, browsers cannot show RAW/HEIC files so those use the uploaded rendition
+const CONVERTED_EXTENSIONS = /\.(arw|cr2|cr3|dng|nef|nrw|orf|pef|raf|raw|rw2|srw|heic|heif)$/i;
+
+function localImageUrl(photo, type = "original") {
+  if (CONVERTED_EXTENSIONS.test(photo.filename)) {
+    return r2ImageUrl(type === "thumbnail" ? photo.thumbnail : photo.path);
+  }
+  return `/api/images/${photo.year}/${photo.filename}`;
+}
+
+// Helper: Browser URL of an uploaded object. Hidden photos are in the private bucket, which has
+// no public domain and is read through the admin server.
+const PRIVATE_URL_SCHEME = "r2-private:";
+
+function r2ImageUrl(url) {
+  if (url && url.startsWith(PRIVATE_URL_SCHEME)) {
+    return `/api/private?key=${encodeURIComponent(url.slice(PRIVATE_URL_SCHEME.length))}`;
+  }
+  return url;
+}
+
 // Render photo grid
 function renderPhotos() {
   if (filteredPhotos.length === 0) {
@@ -90,7 +114,7 @@ function renderPhotos() {
                 }" 
                        ${selectedPhotos.has(photo.filename) ? "checked" : ""}>
                 <img class="photo-thumbnail lazy" 
-                     data-src="/api/images/${photo.year}/${photo.filename}" 
+                     data-src="${localImageUrl(photo, "thumbnail")}" 
                      alt="${photo.alt || photo.filename}">
                 <div class="photo-info">
                     <div class="photo-filename" title="${photo.filename}">${
@@ -148,18 +172,46 @@ function showDetail(filename) {
   document.getElementById(
     "detailSize"
   ).value = `${currentPhoto.width}×${currentPhoto.height}`;
+  document.getElementById("detailTitle").value = currentPhoto.title || "";
   document.getElementById("detailAlt").value = currentPhoto.alt || "";
+  document.getElementById("detailRating").value = String(
+    currentPhoto.rating || 0
+  );
   document.getElementById("detailTags").value = (
-    currentPhoto.Subject || []
+    currentPhoto.subject || []
   ).join(", ");
+  document.getElementById("detailLens").value = currentPhoto.lens || "";
   document.getElementById("detailIsHidden").checked = currentPhoto.is_hidden;
   document.getElementById(
     "detailImage"
-  ).src = `/api/images/${currentPhoto.year}/${currentPhoto.filename}`;
+  ).src = localImageUrl(currentPhoto);
+
+  document.getElementById("detailMetadataBox").open = false;
+  document.getElementById("detailMetadata").textContent = "";
 
   detailPanel.classList.add("active");
 }
 
+// Load the full metadata of the current photo, including private fields
+async function loadDetailMetadata() {
+  const box = document.getElementById("detailMetadataBox");
+  const pre = document.getElementById("detailMetadata");
+  if (!currentPhoto || !box.open) return;
+
+  pre.textContent = "加载中...";
+  try {
+    const response = await fetch(
+      `/api/photos/${encodeURIComponent(currentPhoto.filename)}/metadata`
+    );
+    if (!response.ok) throw new Error(await response.text());
+    const metadata = await response.json();
+    pre.textContent = JSON.stringify(metadata, null, 2);
+  } catch (error) {
+    console.error("Error loading metadata:", error);
+    pre.textContent = `加载失败：${error.message}`;
+  }
+}
+
 // Hide detail panel
 function hideDetail() {
   detailPanel.classList.remove("active");
@@ -185,14 +237,21 @@ async function saveDetail() {
   if (!currentPhoto) return;
 
   const updates = {
+    title: document.getElementById("detailTitle").value,
     alt: document.getElementById("detailAlt").value,
+    rating: parseInt(document.getElementById("detailRating").value, 10) || 0,
     is_hidden: document.getElementById("detailIsHidden").checked,
-    Subject: document
+    subject: document
       .getElementById("detailTags")
       .value.split(",")
       .map((t) => t.trim())
       .filter((t) => t),
   };
+  // Only send the lens when it changed, it is stored as a manual assignment
+  const lens = document.getElementById("detailLens").value.trim();
+  if (lens !== (currentPhoto.lens || "")) {
+    updates.lens = lens;
+  }
 
   const btnId = "saveDetailBtn";
   setButtonLoading(btnId, true);
@@ -204,33 +263,70 @@ async function saveDetail() {
       body: JSON.stringify(updates),
     });
 
-    if (!response.ok) throw new Error("Failed to update photo");
+    if (!response.ok) throw new Error(await response.text());
+
+    // Hiding or showing moves the objects, reload to pick up their new URLs
+    const visibilityChanged = currentPhoto.is_hidden !== updates.is_hidden;
 
     // Update local data
+    currentPhoto.title = updates.title;
     currentPhoto.alt = updates.alt;
+    currentPhoto.rating = updates.rating;
     currentPhoto.is_hidden = updates.is_hidden;
-    currentPhoto.Subject = updates.Subject;
+    currentPhoto.subject = updates.subject;
+    if (updates.lens !== undefined) {
+      currentPhoto.lens = updates.lens;
+    }
 
     renderPhotos();
     updateStats();
     hideDetail();
+    if (visibilityChanged) {
+      loadPhotos();
+    }
 
     // Success hint could be added here if needed, but UI closes so it's implicit
   } catch (error) {
     console.error("Error saving photo:", error);
-    alert("保存失败，请重试");
+    alert(`保存失败：${error.message}`);
   } finally {
     setButtonLoading(btnId, false);
   }
 }
 
 // Delete current photo
+// Reprocess the current photo without a full rebuild
+async function processCurrentPhoto() {
+  if (!currentPhoto) return;
+
+  const btnId = "processPhotoBtn";
+  setButtonLoading(btnId, true);
+
+  try {
+    const response = await fetch(
+      `/api/photos/${encodeURIComponent(currentPhoto.filename)}/process`,
+      { method: "POST" }
+    );
+    if (!response.ok) throw new Error(await response.text());
+    const result = await response.json();
+    if (result.error) alert(`照片已处理，但发布失败：${result.error}`);
+
+    await loadPhotos();
+    showDetail(currentPhoto.filename);
+  } catch (error) {
+    console.error("Error processing photo:", error);
+    alert(`处理失败：${error.message}`);
+  } finally {
+    setButtonLoading(btnId, false);
+  }
+}
+
 async function deleteCurrentPhoto() {
   if (!currentPhoto) return;
 
   if (
     !confirm(
-      `确定要删除照片 ${currentPhoto.filename} 吗？\n此操作将删除本地文件和 R2 上的文件，且无法恢复！`
+      `确定要删除照片 ${currentPhoto.filename} 吗？\n本地文件和 R2 上的文件会移入回收站，可用 update restore 恢复。`
     )
   ) {
     return;
@@ -314,6 +410,7 @@ async function batchUpdate(isHidden) {
     renderPhotos();
     updateStats();
     updateBatchButtons();
+    loadPhotos(); // Pick up the URLs of the moved objects
 
     alert(isHidden ? "所需照片已隐藏" : "所需照片已显示");
   } catch (error) {
@@ -328,19 +425,22 @@ async function batchUpdate(isHidden) {
   }
 }
 
-// Rebuild photos
-async function rebuild() {
+// Rebuild photos, allowDeletions skips the mass deletion check
+async function rebuild(allowDeletions = false) {
   rebuildModal.classList.add("active");
   document.getElementById("progressFill").style.width = "0%";
   document.getElementById("rebuildMessage").textContent = "准备中...";
+  document.getElementById("rebuildStats").innerHTML = "";
   document.getElementById("rebuildLogs").innerHTML = "";
+  document.getElementById("cancelRebuildBtn").disabled = false;
 
   try {
-    const response = await fetch("/api/rebuild", { method: "POST" });
+    const url = allowDeletions ? "/api/rebuild?allow_deletions=true" : "/api/rebuild";
+    const response = await fetch(url, { method: "POST" });
     if (!response.ok) throw new Error("Failed to start rebuild");
 
-    // Poll for status
-    pollRebuildStatus();
+    // Stream logs and progress
+    watchRebuildEvents();
   } catch (error) {
     console.error("Error starting rebuild:", error);
     alert("启动重建失败，请重试");
@@ -348,31 +448,380 @@ async function rebuild() {
   }
 }
 
-// Poll rebuild status
-async function pollRebuildStatus() {
+const STAGE_NAMES = {
+  scan: "扫描",
+  process: "处理",
+  cleanup: "清理",
+  publish: "发布",
+};
+
+// Format a byte count for display
+function formatBytes(bytes) {
+  if (bytes < 1024) return `${bytes} B`;
+  if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+  if (bytes < 1024 * 1024 * 1024) return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+  return `${(bytes / 1024 / 1024 / 1024).toFixed(2)} GB`;
+}
+
+// Format a duration in seconds for display
+function formatDuration(seconds) {
+  seconds = Math.round(seconds);
+  if (seconds < 60) return `${seconds} 秒`;
+  return `${Math.floor(seconds / 60)} 分 ${seconds % 60} 秒`;
+}
+
+// Render the processor's progress event below the progress bar
+function renderRebuildStats(detail) {
+  const statsDiv = document.getElementById("rebuildStats");
+  if (!detail) {
+    statsDiv.innerHTML = "";
+    return;
+  }
+
+  const lines = [
+    `已处理 ${detail.processed}/${detail.total} · 跳过 ${detail.skipped} · 失败 ${detail.failed}`,
+    `已上传 ${formatBytes(detail.uploaded_bytes)} · ${formatBytes(detail.bytes_per_second)}/s · ${detail.photos_per_second.toFixed(1)} 张/秒`,
+  ];
+  if (detail.stage === "process" && detail.eta_seconds > 0) {
+    lines.push(`预计剩余 ${formatDuration(detail.eta_seconds)}`);
+  }
+  if (detail.current_file) {
+    lines.push(`当前：${detail.current_file}`);
+  }
+  const stages = (detail.stages || [])
+    .map((s) => `${STAGE_NAMES[s.stage] || s.stage} ${s.duration_seconds.toFixed(1)}s`)
+    .join(" · ");
+  if (stages) {
+    lines.push(`耗时：${stages}`);
+  }
+  statsDiv.innerHTML = lines.map((line) => `<div>${line}</div>`).join("");
+}
+
+// Cancel the running rebuild, photos.json is left untouched
+async function cancelRebuild() {
+  const btn = document.getElementById("cancelRebuildBtn");
+  btn.disabled = true;
   try {
-    const response = await fetch("/api/rebuild/status");
-    const status = await response.json();
-
-    document.getElementById("progressFill").style.width = `${status.progress}%`;
-    document.getElementById("rebuildMessage").textContent = status.message;
-
-    const logsDiv = document.getElementById("rebuildLogs");
-    logsDiv.innerHTML = status.logs.map((log) => `<div>${log}</div>`).join("");
-    logsDiv.scrollTop = logsDiv.scrollHeight;
-
-    if (status.status === "running") {
-      setTimeout(pollRebuildStatus, 1000);
-    } else if (status.status === "completed") {
-      setTimeout(() => {
-        rebuildModal.classList.remove("active");
-        loadPhotos(); // Reload photos
-      }, 2000);
-    } else if (status.status === "failed") {
-      alert("重建失败，请查看日志");
+    const response = await fetch("/api/rebuild/cancel", { method: "POST" });
+    if (!response.ok) throw new Error(await response.text());
+  } catch (error) {
+    console.error("Error cancelling rebuild:", error);
+    btn.disabled = false;
+  }
+}
+
+// Log lines kept in the rebuild modal, matching the server's buffer
+const MAX_REBUILD_LOGS = 1000;
+
+let rebuildEvents = null;
+
+// Append a line to the rebuild log
+function appendRebuildLog(message) {
+  const logsDiv = document.getElementById("rebuildLogs");
+  const line = document.createElement("div");
+  line.textContent = message;
+  logsDiv.appendChild(line);
+  while (logsDiv.childElementCount > MAX_REBUILD_LOGS) {
+    logsDiv.firstElementChild.remove();
+  }
+  logsDiv.scrollTop = logsDiv.scrollHeight;
+}
+
+// Follow the rebuild through the server's event stream. The browser reconnects on its own
+// and resumes after the last received event.
+function watchRebuildEvents() {
+  if (rebuildEvents) rebuildEvents.close();
+  rebuildEvents = new EventSource("/api/rebuild/events");
+
+  rebuildEvents.addEventListener("log", (event) => {
+    appendRebuildLog(JSON.parse(event.data).message);
+  });
+
+  rebuildEvents.addEventListener("progress", (event) => {
+    const progress = JSON.parse(event.data);
+    document.getElementById("progressFill").style.width = `${progress.progress}%`;
+    document.getElementById("rebuildMessage").textContent = progress.message;
+    renderRebuildStats(progress.detail);
+  });
+
+  rebuildEvents.addEventListener("status", (event) => {
+    handleRebuildStatus(JSON.parse(event.data));
+  });
+}
+
+// Update the rebuild modal for a status event
+function handleRebuildStatus(status) {
+  document.getElementById("progressFill").style.width = `${status.progress}%`;
+  document.getElementById("rebuildMessage").textContent = status.message;
+  if (status.status === "running") return;
+
+  rebuildEvents.close();
+  rebuildEvents = null;
+  document.getElementById("cancelRebuildBtn").disabled = true;
+
+  if (status.status === "cancelled") {
+    loadPhotos();
+  } else if (status.status === "completed") {
+    const failed = (status.result && status.result.failed) || [];
+    if (failed.length > 0) {
+      // Keep the modal open so the failed files can be read
+      failed.forEach((f) => appendRebuildLog(`❌ ${f.file}: ${f.error}`));
+      loadPhotos();
+      return;
     }
+    setTimeout(() => {
+      rebuildModal.classList.remove("active");
+      loadPhotos(); // Reload photos
+    }, 2000);
+  } else if (status.status === "failed" && status.deletion_blocked) {
+    // Deleted photos go to the R2 trash and can be restored with `update restore`
+    if (confirm("本次重建将删除大量照片，可能是图片目录不完整。确认目录无误后仍要删除吗？删除的照片会先移入回收站。")) {
+      rebuild(true);
+    }
+  } else if (status.status === "failed") {
+    alert("重建失败，请查看日志");
+  }
+}
+
+const JOB_STATUS_NAMES = {
+  running: "⏳ 进行中",
+  completed: "✅ 完成",
+  failed: "❌ 失败",
+  cancelled: "⏹ 已取消",
+};
+
+const JOB_TRIGGER_NAMES = {
+  cli: "命令行",
+  admin: "后台",
+  watch: "监听",
+};
+
+// Show the rebuild job history
+async function showJobs() {
+  jobsModal.classList.add("active");
+  const listDiv = document.getElementById("jobsList");
+  listDiv.innerHTML = "";
+  document.getElementById("jobSummary").textContent = "加载中...";
+  document.getElementById("jobLogs").innerHTML = "";
+
+  try {
+    const response = await fetch("/api/rebuild/jobs");
+    if (!response.ok) throw new Error(await response.text());
+    const jobs = await response.json();
+
+    document.getElementById("jobSummary").textContent =
+      jobs.length > 0 ? "选择一条记录查看日志" : "暂无重建记录";
+    jobs.forEach((job) => {
+      const item = document.createElement("div");
+      item.className = "job-item";
+      const c = job.counts;
+      item.innerHTML = `
+        <span>${new Date(job.start_time).toLocaleString()} · ${JOB_TRIGGER_NAMES[job.trigger] || job.trigger}${job.full ? " · 全量" : ""}</span>
+        <span class="job-counts">+${c.added} ~${c.updated} -${c.deleted} ↪${c.renamed || 0} ✗${c.failed}</span>
+        <span>${JOB_STATUS_NAMES[job.status] || job.status}</span>
+      `;
+      item.addEventListener("click", () => {
+        listDiv
+          .querySelectorAll(".job-item")
+          .forEach((el) => el.classList.toggle("active", el === item));
+        showJob(job.id);
+      });
+      listDiv.appendChild(item);
+    });
   } catch (error) {
-    console.error("Error polling rebuild status:", error);
+    console.error("Error loading jobs:", error);
+    document.getElementById("jobSummary").textContent = `加载失败：${error.message}`;
+  }
+}
+
+// Show the logs and outcome of a rebuild job
+async function showJob(id) {
+  const summary = document.getElementById("jobSummary");
+  const logsDiv = document.getElementById("jobLogs");
+  summary.textContent = "加载中...";
+  logsDiv.innerHTML = "";
+
+  try {
+    const response = await fetch(`/api/rebuild/jobs/${encodeURIComponent(id)}`);
+    if (!response.ok) throw new Error(await response.text());
+    const job = await response.json();
+
+    const c = job.counts;
+    let text = `${job.id} · 新增 ${c.added} · 更新 ${c.updated} · 未变 ${c.unchanged} · 重命名 ${c.renamed || 0} · 删除 ${c.deleted} · 失败 ${c.failed}`;
+    if (job.end_time && !job.end_time.startsWith("0001")) {
+      const seconds = (new Date(job.end_time) - new Date(job.start_time)) / 1000;
+      text += ` · 耗时 ${formatDuration(seconds)}`;
+    }
+    if (job.error) text += ` · 错误：${job.error}`;
+    summary.textContent = text;
+
+    const lines = [...(job.logs || [])];
+    ((job.result && job.result.failed) || []).forEach((f) =>
+      lines.push(`❌ ${f.file}: ${f.error}`),
+    );
+    lines.forEach((line) => {
+      const div = document.createElement("div");
+      div.textContent = line;
+      logsDiv.appendChild(div);
+    });
+  } catch (error) {
+    console.error("Error loading job:", error);
+    summary.textContent = `加载失败：${error.message}`;
+  }
+}
+
+const VERSION_SOURCE_NAMES = {
+  local: "本地备份",
+  r2: "R2 快照",
+};
+
+// Diffs of versions against the current photos.json photo by photo, by version ID, cleared
+// whenever the versions are listed
+const versionDiffs = new Map();
+
+// Load what rolling back to a version would change, photo by photo
+async function loadVersionDiff(version) {
+  if (!versionDiffs.has(version.id)) {
+    const params = new URLSearchParams({ from: "current", to: version.id });
+    const response = await fetch(`/api/manifest/diff?${params}`);
+    if (!response.ok) throw new Error(await response.text());
+    versionDiffs.set(version.id, await response.json());
+  }
+  return versionDiffs.get(version.id);
+}
+
+// Show the previous versions of photos.json
+async function showVersions() {
+  versionsModal.classList.add("active");
+  const listDiv = document.getElementById("versionsList");
+  const summary = document.getElementById("versionsSummary");
+  listDiv.innerHTML = "";
+  summary.textContent = "加载中...";
+  document.getElementById("versionDiffSummary").textContent = "";
+  document.getElementById("versionDiff").innerHTML = "";
+  versionDiffs.clear();
+
+  try {
+    const response = await fetch("/api/manifest/versions");
+    if (!response.ok) throw new Error(await response.text());
+    const versions = await response.json();
+
+    summary.textContent =
+      versions.length > 0
+        ? "差异为回滚后相对当前 photos.json 的变化：+新增 -移除 ~修改，点击版本查看详情"
+        : "暂无历史版本";
+    versions.forEach((version) => {
+      const item = document.createElement("div");
+      item.className = "job-item";
+      const d = version.diff;
+      const info = version.error
+        ? `⚠ ${version.error}`
+        : `${version.photos} 张 · +${d.added} -${d.removed} ~${d.changed} · ${formatBytes(version.size)}`;
+      item.innerHTML = `
+        <span>${new Date(version.time).toLocaleString()} · ${VERSION_SOURCE_NAMES[version.source] || version.source}${version.current ? " · 当前" : ""}</span>
+        <span class="job-counts">${info}</span>
+      `;
+      item.addEventListener("click", () => {
+        listDiv
+          .querySelectorAll(".job-item")
+          .forEach((el) => el.classList.toggle("active", el === item));
+        showVersionDiff(version);
+      });
+      if (!version.error && !version.current) {
+        const button = document.createElement("button");
+        button.className = "btn btn-small";
+        button.textContent = "回滚";
+        button.addEventListener("click", (e) => {
+          e.stopPropagation();
+          rollbackManifest(version);
+        });
+        item.appendChild(button);
+      }
+      listDiv.appendChild(item);
+    });
+  } catch (error) {
+    console.error("Error loading versions:", error);
+    summary.textContent = `加载失败：${error.message}`;
+  }
+}
+
+// Show what rolling back to a version would change, photo by photo
+async function showVersionDiff(version) {
+  const summary = document.getElementById("versionDiffSummary");
+  const diffDiv = document.getElementById("versionDiff");
+  summary.textContent = "加载中...";
+  diffDiv.innerHTML = "";
+
+  try {
+    const diff = await loadVersionDiff(version);
+    if (diff.added.length + diff.removed.length + diff.changed.length === 0 && !diff.reordered) {
+      summary.textContent = `${version.name} 与当前 photos.json 相同`;
+      return;
+    }
+
+    summary.textContent = `回滚到 ${version.name}：新增 ${diff.added.length} · 移除 ${diff.removed.length} · 修改 ${diff.changed.length}${diff.reordered ? " · 顺序变化" : ""}`;
+    const lines = [
+      ...diff.added.map((f) => `+ ${f}`),
+      ...diff.removed.map((f) => `- ${f}`),
+      ...diff.changed.map(
+        (p) =>
+          `~ ${p.filename}: ${p.changes.map((c) => formatFieldChange(c)).join("; ")}`,
+      ),
+    ];
+    lines.forEach((line) => {
+      const div = document.createElement("div");
+      div.textContent = line;
+      diffDiv.appendChild(div);
+    });
+  } catch (error) {
+    console.error("Error loading diff:", error);
+    summary.textContent = `加载失败：${error.message}`;
+  }
+}
+
+// Format a changed field, e.g. "exif.ISO 100→200"
+function formatFieldChange(change) {
+  const format = (value) => {
+    const text = JSON.stringify(value);
+    return text.length > 60 ? `${text.slice(0, 59)}…` : text;
+  };
+  if (change.old === undefined) return `${change.field} 新增 ${format(change.new)}`;
+  if (change.new === undefined) return `${change.field} 删除`;
+  return `${change.field} ${format(change.old)}→${format(change.new)}`;
+}
+
+// Roll photos.json back to a previous version, locally, in R2 and in KV
+async function rollbackManifest(version) {
+  const d = version.diff;
+  if (
+    !confirm(
+      `确定要将 photos.json 回滚到 ${new Date(version.time).toLocaleString()} 的版本吗？\n` +
+        `回滚后共 ${version.photos} 张照片：新增 ${d.added}，移除 ${d.removed}，修改 ${d.changed}。\n` +
+        `当前版本会先备份，已删除的 R2 文件不会恢复。`
+    )
+  ) {
+    return;
+  }
+
+  try {
+    const response = await fetch("/api/manifest/rollback", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ id: version.id }),
+    });
+    if (!response.ok) throw new Error(await response.text());
+    const result = await response.json();
+
+    await loadPhotos();
+    await showVersions();
+    if (result.publish_errors && result.publish_errors.length > 0) {
+      alert(`已回滚本地文件，但发布失败：\n${result.publish_errors.join("\n")}`);
+    } else {
+      alert("回滚完成");
+    }
+  } catch (error) {
+    console.error("Error rolling back:", error);
+    alert(`回滚失败：${error.message}`);
   }
 }
 
@@ -383,14 +832,16 @@ function showR2Preview(filename, type = "thumbnail") {
 
   const url = type === "original" ? photo.path : photo.thumbnail;
   const title = type === "original" ? "R2 原图" : "R2 缩略图";
-  const proxyUrl = `/api/proxy?url=${encodeURIComponent(url)}`;
+  const proxyUrl = url.startsWith(PRIVATE_URL_SCHEME)
+    ? r2ImageUrl(url)
+    : `/api/proxy?url=${encodeURIComponent(url)}`;
 
   document.getElementById(
     "r2ModalTitle"
   ).textContent = `${title} - ${filename}`;
   document.getElementById("r2PreviewImage").src = proxyUrl;
   document.getElementById("r2Url").innerHTML = `
-        <strong>URL:</strong> <a href="${url}" target="_blank" style="color: var(--accent-primary); word-break: break-all;">${url}</a>
+        <strong>URL:</strong> <a href="${url.startsWith(PRIVATE_URL_SCHEME) ? proxyUrl : url}" target="_blank" style="color: var(--accent-primary); word-break: break-all;">${url}</a>
     `;
 
   // Reset zoom state
@@ -425,16 +876,22 @@ function filterPhotos() {
   const searchTerm = document.getElementById("searchInput").value.toLowerCase();
   const yearFilter = document.getElementById("yearFilter").value;
   const statusFilter = document.getElementById("statusFilter").value;
+  const placeFilter = document.getElementById("placeFilter").value;
 
   filteredPhotos = allPhotos.filter((photo) => {
     const matchesSearch = photo.filename.toLowerCase().includes(searchTerm);
     const matchesYear = !yearFilter || photo.year === yearFilter;
+    const matchesPlace =
+      !placeFilter ||
+      (photo.location &&
+        (placeKey(photo.location) === placeFilter ||
+          photo.location.country === placeFilter));
     const matchesStatus =
       !statusFilter ||
       (statusFilter === "hidden" && photo.is_hidden) ||
       (statusFilter === "visible" && !photo.is_hidden);
 
-    return matchesSearch && matchesYear && matchesStatus;
+    return matchesSearch && matchesYear && matchesPlace && matchesStatus;
   });
 
   renderPhotos();
@@ -465,6 +922,35 @@ function populateYearFilter() {
     years.map((year) => `<option value="${year}">${year}</option>`).join("");
 }
 
+// Place key used by the place filter: "Country / City"
+function placeKey(location) {
+  return `${location.country} / ${location.city}`;
+}
+
+// Populate place filter with countries and their cities
+function populatePlaceFilter() {
+  const countries = {};
+  allPhotos.forEach((p) => {
+    if (!p.location) return;
+    countries[p.location.country] = countries[p.location.country] || new Set();
+    countries[p.location.country].add(placeKey(p.location));
+  });
+  const placeFilter = document.getElementById("placeFilter");
+  placeFilter.innerHTML =
+    '<option value="">所有地点</option>' +
+    Object.keys(countries)
+      .sort()
+      .map(
+        (country) =>
+          `<option value="${country}">${country}</option>` +
+          [...countries[country]]
+            .sort()
+            .map((key) => `<option value="${key}">&nbsp;&nbsp;${key.split(" / ")[1]}</option>`)
+            .join("")
+      )
+      .join("");
+}
+
 // Setup event listeners
 function setupEventListeners() {
   // Detail panel
@@ -492,15 +978,37 @@ function setupEventListeners() {
   });
 
   // Delete photo
+  document
+    .getElementById("processPhotoBtn")
+    .addEventListener("click", processCurrentPhoto);
   document
     .getElementById("deletePhotoBtn")
     .addEventListener("click", deleteCurrentPhoto);
 
   // Rebuild
-  document.getElementById("rebuildBtn").addEventListener("click", rebuild);
+  document
+    .getElementById("detailMetadataBox")
+    .addEventListener("toggle", loadDetailMetadata);
+
+  document.getElementById("rebuildBtn").addEventListener("click", () => rebuild());
   document.getElementById("closeRebuildBtn").addEventListener("click", () => {
     rebuildModal.classList.remove("active");
   });
+  document
+    .getElementById("cancelRebuildBtn")
+    .addEventListener("click", cancelRebuild);
+
+  // Rebuild history
+  document.getElementById("jobsBtn").addEventListener("click", showJobs);
+  document.getElementById("closeJobsBtn").addEventListener("click", () => {
+    jobsModal.classList.remove("active");
+  });
+
+  // Manifest versions
+  document.getElementById("versionsBtn").addEventListener("click", showVersions);
+  document.getElementById("closeVersionsBtn").addEventListener("click", () => {
+    versionsModal.classList.remove("active");
+  });
 
   // R2 modal
   document.getElementById("closeR2Btn").addEventListener("click", () => {
@@ -539,12 +1047,15 @@ function setupEventListeners() {
   document
     .getElementById("yearFilter")
     .addEventListener("change", filterPhotos);
+  document
+    .getElementById("placeFilter")
+    .addEventListener("change", filterPhotos);
   document
     .getElementById("statusFilter")
     .addEventListener("change", filterPhotos);
 
   // Close modals on background click
-  [rebuildModal, r2Modal, uploadModal].forEach((modal) => {
+  [rebuildModal, r2Modal, uploadModal, jobsModal, versionsModal].forEach((modal) => {
     modal.addEventListener("click", (e) => {
       if (e.target === modal) {
         modal.classList.remove("active");
@@ -614,6 +1125,7 @@ async function handlePhotoUpload(e) {
 
   let uploaded = 0;
   let failed = 0;
+  let unprocessed = 0;
 
   for (let i = 0; i < files.length; i++) {
     const file = files[i];
@@ -623,7 +1135,8 @@ async function handlePhotoUpload(e) {
     try {
       addUploadLog(`📤 正在上传: ${file.name}`);
 
-      const response = await fetch("/api/photos/upload", {
+      // Each photo is processed right after its upload, no full rebuild needed
+      const response = await fetch("/api/photos/upload?process=true", {
         method: "POST",
         body: formData,
       });
@@ -632,6 +1145,10 @@ async function handlePhotoUpload(e) {
         const result = await response.json();
         uploaded++;
         addUploadLog(`✅ 成功: ${file.name} → ${result.year || "未知年份"}`);
+        if (result.process_error) {
+          unprocessed++;
+          addUploadLog(`⚠️ 处理失败: ${file.name} - ${result.process_error}`);
+        }
       } else {
         failed++;
         addUploadLog(`❌ 失败: ${file.name}`);
@@ -652,14 +1169,16 @@ async function handlePhotoUpload(e) {
   // Upload complete
   addUploadLog(`\n🎉 上传完成！成功: ${uploaded}, 失败: ${failed}`);
 
-  if (uploaded > 0) {
-    addUploadLog("🔄 开始自动重建...");
-
-    // Auto rebuild
+  if (unprocessed > 0) {
+    // Fall back to a full rebuild, it reports the failures in detail
+    addUploadLog("🔄 部分照片处理失败，开始完整重建...");
     setTimeout(async () => {
       uploadModal.classList.remove("active");
       await rebuild();
     }, 2000);
+  } else if (uploaded > 0) {
+    await loadPhotos();
+    setTimeout(() => uploadModal.classList.remove("active"), 2000);
   }
 
   // Reset file input
@@ -143,7 +143,7 @@ class VirtualPhotoGrid {
                 }" 
                        ${isSelected ? "checked" : ""}>
                 <img class="photo-thumbnail lazy" 
-                     data-src="/api/images/${photo.year}/${photo.filename}" 
+                     data-src="${localImageUrl(photo, "thumbnail")}" 
                      alt="${photo.alt || photo.filename}">
                 <div class="photo-info">
                     <div class="photo-filename" title="${photo.filename}">${
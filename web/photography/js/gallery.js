@@ -728,6 +728,116 @@ function updateUrlQuery(photoIndex) {
     }
 }
 
+const MANIFEST_BASE_URL = "https://cdn-photography-img-vincent.chyu.org/pages/";
+
+/**
+ * Fetches the albums from the published index and its per-year shards. Shards are immutable
+ * and cached by the browser, so only changed years are downloaded again. Falls back to the
+ * monolithic photos.json published before the manifest was split.
+ */
+async function fetchAlbums() {
+    const indexUrl = `${MANIFEST_BASE_URL}manifest/index.json`;
+    const response = await fetch(indexUrl, { cache: "no-cache" });
+    if (response.ok) {
+        const index = await response.json();
+        return Promise.all(
+            index.years.map(async (entry) => {
+                const shard = await fetch(new URL(entry.shard, indexUrl));
+                if (!shard.ok) {
+                    throw new Error(`HTTP error! status: ${shard.status}`);
+                }
+                return shard.json();
+            })
+        );
+    }
+
+    const legacy = await fetch(`${MANIFEST_BASE_URL}photos.json`);
+    if (!legacy.ok) {
+        throw new Error(`HTTP error! status: ${legacy.status}`);
+    }
+    const manifest = await legacy.json();
+    // Manifests before schema version 2 are a bare album array
+    return Array.isArray(manifest) ? manifest : manifest.albums || [];
+}
+
+// Selected place filter value, see placeKey. Kept when the gallery is re-rendered, e.g. on
+// orientation changes.
+let selectedPlace = "";
+
+/**
+ * Place filter value of a location at a depth: 1 country, 2 region, 3 city
+ */
+function placeKey(location, depth) {
+    return [location.country, location.region || "", location.city || ""]
+        .slice(0, depth)
+        .join(" / ");
+}
+
+/**
+ * Whether a photo was taken at a place, a country or region includes its cities
+ */
+function matchesPlace(photo, place) {
+    if (!place) return true;
+    if (!photo.location) return false;
+    return placeKey(photo.location, place.split(" / ").length) === place;
+}
+
+/**
+ * Albums with only the photos taken at a place. The photos are copied because rendering
+ * marks the first photo of every month and year.
+ */
+function filterAlbumsByPlace(albums, place) {
+    return albums
+        .map((album) => ({
+            ...album,
+            photos: (album.photos || [])
+                .filter((photo) => matchesPlace(photo, place))
+                .map((photo) => ({ ...photo, markers: undefined })),
+        }))
+        .filter((album) => album.photos.length > 0);
+}
+
+/**
+ * Populate the place filter with the countries, regions and cities of the photos and their
+ * photo counts. It stays hidden when no photo has a published place.
+ */
+function renderPlaceFilter(albums, onChange) {
+    const container = document.getElementById("place-filter");
+    const select = document.getElementById("place-filter-select");
+    if (!container || !select) return;
+
+    const counts = new Map();
+    albums.forEach((album) => {
+        (album.photos || []).forEach((photo) => {
+            if (!photo.location || !photo.location.country) return;
+            const parts = [photo.location.country, photo.location.region, photo.location.city];
+            for (let depth = 1; depth <= 3; depth++) {
+                if (!parts[depth - 1]) continue; // No region or city for this place
+                const key = placeKey(photo.location, depth);
+                counts.set(key, (counts.get(key) || 0) + 1);
+            }
+        });
+    });
+
+    if (!counts.has(selectedPlace)) {
+        selectedPlace = "";
+    }
+    container.hidden = counts.size === 0;
+    select.innerHTML = "";
+    select.appendChild(new Option("所有地点", ""));
+    // Sorted keys list every country before its regions and every region before its cities
+    [...counts.keys()].sort().forEach((key) => {
+        const names = key.split(" / ").filter(Boolean); // Cities without a region are one level up
+        const label = "\u00a0\u00a0".repeat(names.length - 1) + names[names.length - 1];
+        select.appendChild(new Option(`${label} (${counts.get(key)})`, key));
+    });
+    select.value = selectedPlace;
+    select.onchange = () => {
+        selectedPlace = select.value;
+        onChange();
+    };
+}
+
 async function loadGallery() {
     const timelineContainer = document.getElementById("timeline-sidebar");
     const galleryContainer = document.getElementById("gallery-content");
@@ -738,16 +848,9 @@ async function loadGallery() {
     }
 
     try {
-        // const response = await fetch("photos.json");
-        const response = await fetch(
-            "https://cdn-photography-img-vincent.chyu.org/pages/photos.json"
-        );
-        if (!response.ok) {
-            throw new Error(`HTTP error! status: ${response.status}`);
-        }
-        const albumsData = await response.json();
+        const albumsData = await fetchAlbums();
 
-        // Filter out hidden photos
+        // The published shards contain no hidden photos, the legacy photos.json still may
         const albums = albumsData.map((album) => {
             if (album.photos) {
                 return {
@@ -761,15 +864,28 @@ async function loadGallery() {
         // Global gallery state
         const galleryItems = [];
 
-        // Clear containers before re-rendering (important for orientation changes)
-        timelineContainer.innerHTML = "";
-        galleryContainer.innerHTML = "";
+        const render = () => {
+            // Clear containers before re-rendering (important for orientation changes)
+            galleryItems.length = 0;
+            timelineContainer.innerHTML = "";
+            galleryContainer.innerHTML = "";
+
+            const placeAlbums = filterAlbumsByPlace(albums, selectedPlace);
 
-        // Render Timeline (Left Sidebar)
-        renderTimeline(timelineContainer, albums);
+            // Render Timeline (Left Sidebar)
+            renderTimeline(timelineContainer, placeAlbums);
 
-        // Render Gallery (Right Content)
-        renderGallery(galleryContainer, albums, galleryItems);
+            // Render Gallery (Right Content)
+            renderGallery(galleryContainer, placeAlbums, galleryItems);
+        };
+
+        // Changing the place re-renders the photos taken there
+        renderPlaceFilter(albums, () => {
+            render();
+            bindImageLoadEvents();
+            setupScrollSpy();
+        });
+        render();
 
         // Bind Fancybox manually using event delegation
         // This avoids issues with 'trigger' being undefined in initialPage callback
@@ -1432,9 +1548,12 @@ function renderWaterfallLayout(container, photos, year, month, galleryItems) {
             src: photo.path,
             thumb: photo.thumbnail,
             caption: photo.alt || "",
-            exif: photo.exif, // Store full EXIF object
+            exif: Object.assign({}, photo.exif, {
+                Camera: photo.camera,
+                LensName: photo.lens,
+            }), // Store full EXIF object with canonical gear names
             filename: photo.filename || "",
-            Subject: photo.Subject || [], // Store root Subject
+            Subject: photo.subject || photo.Subject || [], // Store root subject, Subject before schema version 2
         });
     });
 
@@ -2141,7 +2260,12 @@ function extractShootingParams(exif) {
 function extractDeviceInfo(exif) {
     const info = [];
 
-    if (exif.Make && exif.Model) {
+    if (exif.Camera) {
+        info.push({
+            label: "相机",
+            value: exif.Camera,
+        });
+    } else if (exif.Make && exif.Model) {
         info.push({
             label: "相机",
             value: `${exif.Make} ${exif.Model}`,
@@ -2153,10 +2277,10 @@ function extractDeviceInfo(exif) {
         });
     }
 
-    if (exif.LensModel || exif.Lens) {
+    if (exif.LensName || exif.LensModel || exif.Lens) {
         info.push({
             label: "镜头",
-            value: exif.LensModel || exif.Lens,
+            value: exif.LensName || exif.LensModel || exif.Lens,
         });
     }
 